@@ -1,22 +1,35 @@
 package main
 
 import (
+	"archive/tar"
 	"bufio"
 	"bytes"
+	"compress/gzip"
 	"context"
+	"crypto/sha256"
+	"encoding/hex"
 	"encoding/json"
 	"errors"
+	"flag"
 	"fmt"
 	"io"
 	"io/fs"
+	"maps"
 	"os"
 	"os/exec"
+	"os/signal"
 	"path"
 	"path/filepath"
+	"reflect"
 	"regexp"
+	"runtime"
+	"slices"
 	"sort"
+	"strconv"
 	"strings"
+	"sync"
 	"syscall"
+	"time"
 )
 
 var scpLikeRemote = regexp.MustCompile(`^[^/\s]+@[^/\s:]+:.+`)
@@ -25,20 +38,256 @@ type app struct {
 	in     *bufio.Reader
 	out    io.Writer
 	errOut io.Writer
+	runner commandRunner
+
+	// remote overrides the configured remote for pull and push.
+	remote string
+	// jsonOutput prints reports as JSON instead of indented text.
+	jsonOutput bool
+	// quiet suppresses reports on fully successful runs.
+	quiet bool
+	// commitMessage, when set by -m/--message, replaces the commit editor.
+	commitMessage string
+	// noCommit stages changes instead of committing them, so several
+	// operations can be batched into one manual commit.
+	noCommit bool
+	// assumeYes takes the default answer to every prompt without reading
+	// stdin, so destructive confirmations, which default to no, stay
+	// declined.
+	assumeYes bool
+	// pushWithoutAsking answers the push prompts with yes, for unattended
+	// runs that should publish their commits.
+	pushWithoutAsking bool
+	// repoOverride, set by the global --repo flag, takes precedence over
+	// CFGS_REPO and the configured repo_path.
+	repoOverride string
+	// trackedCache holds `git ls-files` output per repo path; see
+	// gitTrackedEntries.
+	trackedCache map[string]trackedCacheEntry
+	// verbose traces each filesystem check and change doctor and add make,
+	// with the reason for it, to errOut.
+	verbose bool
+	// hostname selects host overlays and host variants, and names this
+	// machine in reports and audit commits.
+	hostname string
+	// gitRetryDelay is the wait before the first retry of a transient git
+	// network failure; each later retry waits twice as long.
+	gitRetryDelay time.Duration
 }
 
+// cfgsConfig is the on-disk cfgs configuration. The desc and enum tags feed
+// `cfgs config-schema`, so new fields should carry them too.
 type cfgsConfig struct {
-	RepoPath    string   `json:"repo_path"`
-	IgnoreGlobs []string `json:"ignore_globs,omitempty"`
+	RepoPath    string   `json:"repo_path,omitempty" desc:"Path to the dotfiles git repository."`
+	IgnoreGlobs []string `json:"ignore_globs,omitempty" desc:"Globs for live paths that are never offered for tracking."`
+	// Remote names the git remote used by sync and push. When empty and the
+	// repository has several remotes, cfgs asks which one to use.
+	Remote string `json:"remote,omitempty" desc:"Git remote used by sync and push."`
+	// ExcludedPaths lists managed files that doctor leaves unlinked on this
+	// machine, e.g. the files not picked by `init --import-existing`.
+	ExcludedPaths []string `json:"excluded_paths,omitempty" desc:"Managed paths doctor leaves unlinked on this machine."`
+	// Roots declares additional base directories managed next to
+	// XDG_CONFIG_HOME, which always stays the default root at the repo root.
+	Roots []rootConfig `json:"roots,omitempty" desc:"Additional base directories managed next to XDG_CONFIG_HOME."`
+	// LinkMode selects how new symlinks point at the repo: "absolute"
+	// (default) or "relative".
+	LinkMode string `json:"link_mode,omitempty" desc:"How new symlinks point at the repo." enum:"absolute,relative"`
+	// LinkStrategy selects how managed files appear at their live path:
+	// "symlink" (default; "copy" on Windows, where symlinks need extra
+	// privileges), "hardlink", or "copy". Hardlinks and copies are recorded
+	// in a manifest so doctor can tell a stale copy from a local edit.
+	LinkStrategy string `json:"link_strategy,omitempty" desc:"How managed files are placed at their live path." enum:"symlink,hardlink,copy"`
+	// RequiredCommands maps managed-path globs to a command that must be on
+	// PATH for doctor to link matching files, e.g. {"alacritty/**": "alacritty"}.
+	RequiredCommands map[string]string `json:"required_commands,omitempty" desc:"Managed-path globs mapped to a command that must be installed for doctor to link them."`
+	// SyncStrategy selects how sync pulls: "rebase" (default), "merge", or
+	// "ff-only".
+	SyncStrategy string `json:"sync_strategy,omitempty" desc:"How sync pulls from the remote." enum:"rebase,merge,ff-only"`
+	// ReadonlyRepoFiles clears the write bits on repo files once they are
+	// tracked, so edits through the live symlink fail loudly instead of
+	// silently changing the repo. Because copyFile preserves permissions,
+	// unlink and remove add the owner write bit back on the materialized
+	// live copy; doctor only touches links, never repo file contents.
+	ReadonlyRepoFiles bool `json:"readonly_repo_files,omitempty" desc:"Clear write bits on repo files after tracking them."`
+	// SignCommits signs every commit cfgs creates, including the merge and
+	// rebase commits of sync; SigningKey, when set, is passed as
+	// --gpg-sign=<key> in place of user.signingkey.
+	SignCommits bool   `json:"sign_commits,omitempty" desc:"GPG-sign commits created by cfgs."`
+	SigningKey  string `json:"signing_key,omitempty" desc:"Key passed to --gpg-sign when signing commits."`
+	// CommitTemplate, when set, replaces the editor for commits made after
+	// add, remove, and similar operations, e.g. "cfgs: {{action}} {{count}} file(s)".
+	CommitTemplate string `json:"commit_template,omitempty" desc:"Commit message used instead of the editor; {{action}} and {{count}} are filled in."`
+	// FzfPreview replaces the default fzf preview command. It runs in sh
+	// with the highlighted file's live path in $CFGS_PREVIEW_PATH, e.g.
+	// `head -50 "$CFGS_PREVIEW_PATH"`.
+	FzfPreview string `json:"fzf_preview,omitempty" desc:"Shell command fzf runs to preview a file; the live path is in $CFGS_PREVIEW_PATH."`
+	// Profiles holds named repo setups, e.g. "work" and "personal". When
+	// Active names one, its repo_path and ignore_globs replace the top-level
+	// fields on load, and saving writes them back into the profile. A flat
+	// config is migrated into a "default" profile.
+	Profiles map[string]profileConfig `json:"profiles,omitempty" desc:"Named repo setups selectable with cfgs profile."`
+	Active   string                   `json:"active,omitempty" desc:"Name of the profile in use."`
+	// NetworkRetries is how many times pull and push are retried after a
+	// transient network failure; unset means defaultNetworkRetries and 0
+	// disables retrying.
+	NetworkRetries *int `json:"network_retries,omitempty" desc:"Retries for git pull and push after transient network failures."`
+	// FileModes maps managed-path globs to the octal permissions
+	// `doctor --fix-perms` enforces on repo files, e.g. {"private/**": "600"}.
+	// When several globs match a file, the most specific one wins, so
+	// {"**": "644", "private/**": "600"} gives private files 0600. Modes
+	// recorded by `add --mode` take precedence.
+	FileModes map[string]string `json:"file_modes,omitempty" desc:"Managed-path globs mapped to the permissions doctor --fix-perms enforces on repo files."`
+	// SecretGlobs marks managed paths whose repo copy is encrypted with age.
+	// Tracking one encrypts the live file to AgeRecipient and leaves the
+	// plaintext in place; doctor decrypts it to the live path with the
+	// AgeIdentity key file instead of linking the ciphertext.
+	SecretGlobs  []string `json:"secret_globs,omitempty" desc:"Managed-path globs stored encrypted with age."`
+	AgeRecipient string   `json:"age_recipient,omitempty" desc:"age recipient secret files are encrypted to."`
+	AgeIdentity  string   `json:"age_identity,omitempty" desc:"age identity file used to decrypt secret files."`
+}
+
+// defaultNetworkRetries applies when network_retries is not configured.
+const defaultNetworkRetries = 3
+
+// linkStrategy returns how managed files are placed on this machine.
+func (c cfgsConfig) linkStrategy() string {
+	if c.LinkStrategy != "" {
+		return c.LinkStrategy
+	}
+	if runtime.GOOS == "windows" {
+		return linkStrategyCopy
+	}
+	return linkStrategySymlink
+}
+
+// networkRetries returns the configured retry count for network git calls.
+func (c cfgsConfig) networkRetries() int {
+	if c.NetworkRetries == nil {
+		return defaultNetworkRetries
+	}
+	return *c.NetworkRetries
+}
+
+// profileConfig is one named entry in cfgsConfig.Profiles.
+type profileConfig struct {
+	RepoPath    string   `json:"repo_path" desc:"Path to the dotfiles git repository."`
+	IgnoreGlobs []string `json:"ignore_globs,omitempty" desc:"Globs for live paths that are never offered for tracking."`
+}
+
+// defaultProfileName is the profile a flat config is migrated into.
+const defaultProfileName = "default"
+
+// rootConfig maps an XDG base directory onto a subdirectory of the repo.
+type rootConfig struct {
+	Name     string `json:"name" desc:"Unique root name."`
+	Base     string `json:"base" desc:"Base directory of the live files: an XDG base directory or $HOME." enum:"config,data,state,home"`
+	RepoDir  string `json:"repo_dir" desc:"Repo subdirectory that stores this root's files."`
+	MaxDepth int    `json:"max_depth,omitempty" desc:"How many directory levels to scan below the base (0 for unlimited; defaults to 1 for home)."`
+}
+
+// managedRoot is a resolved root: live files under base are stored in the
+// repo under repoDir ("" for the repo root).
+type managedRoot struct {
+	name     string
+	base     string
+	repoDir  string
+	maxDepth int
+	// skipDir is a directory under base that walks prune, set when the
+	// repo itself lives inside the root.
+	skipDir string
+}
+
+type doctorOptions struct {
+	// exclude skips matching managed paths and prunes them from the orphan
+	// walk for a single run.
+	exclude []globMatcher
+	// repoWins replaces diverged live regular files with links to the repo
+	// version. Live files newer than the repo file need confirmation unless
+	// force is set.
+	repoWins bool
+	force    bool
+	// linkMode, when set, rewrites correct links whose style differs.
+	linkMode string
+	// commitEmpty records a successful run as an empty commit when the repo
+	// has no other changes.
+	commitEmpty bool
+	// summaryOnly prints bucket counts and lists only the entries that need
+	// manual action.
+	summaryOnly bool
+	// assumeLinked trusts any live symlink without resolving it, trading
+	// detection of mispointed links for speed on large trees.
+	assumeLinked bool
+	// fixDanglingOnly only removes live symlinks into the repo whose target
+	// no longer exists and skips every other reconciliation step.
+	fixDanglingOnly bool
+	// reportFile receives the report instead of stdout.
+	reportFile string
+	// keepGoing exits successfully even when files need manual reconcile.
+	keepGoing bool
+	// managedFileList, when non-nil, restricts reconciliation to these paths
+	// and skips the orphan pass.
+	managedFileList []string
+	// incremental skips files whose link state matches the manifest from the
+	// last run and records a fresh manifest afterwards.
+	incremental bool
+	// showDiff appends a diff for every live file that differs from the repo.
+	showDiff bool
+	// backup renames a live regular file to <name>.cfgs-bak, keeping its
+	// mode, instead of deleting it before linking.
+	backup bool
+	// fixPerms chmods repo files to their configured mode before linking.
+	fixPerms bool
+	// prune removes the live symlink of a managed entry whose repo file no
+	// longer exists instead of asking for manual reconcile.
+	prune bool
+	// interactive asks, for each diverged live file, whether to keep the
+	// live or the repo version instead of leaving it for manual reconcile.
+	interactive bool
+}
+
+// liveBackupSuffix names the copy doctor --backup leaves next to a live file
+// it replaces with a symlink.
+const liveBackupSuffix = ".cfgs-bak"
+
+// linkStateManifest records, per managed file, what doctor saw the last time
+// the file was verified as linked.
+type linkStateManifest struct {
+	RepoPath string                    `json:"repo_path"`
+	LinkMode string                    `json:"link_mode"`
+	Files    map[string]linkStateEntry `json:"files"`
+}
+
+type linkStateEntry struct {
+	Target        string `json:"target"`
+	RepoSize      int64  `json:"repo_size"`
+	RepoModTime   int64  `json:"repo_mtime"`
+	LiveLinkMtime int64  `json:"live_link_mtime"`
 }
 
 type doctorReport struct {
 	didNotTouch           []string
+	skipped               []string
 	replacedWithSymlink   []string
 	unlinkedOrphanSymlink []string
-	requireManualResolve  []string
+	fixedPerms            []string
+	requireManualResolve  []manualResolve
+}
+
+// manualResolve is a path doctor left alone and the reason it could not
+// reconcile it.
+type manualResolve struct {
+	path   string
+	reason string
 }
 
+// reasonLiveDiffers marks a live regular file whose content differs from the
+// repo copy; `doctor --diff` shows these differences.
+const reasonLiveDiffers = "live file differs from repo"
+
+// reasonSecretDiffers marks a live secret whose content differs from the
+// decrypted repo copy.
+const reasonSecretDiffers = "live secret differs from the decrypted repo copy"
+
 type operationReport struct {
 	changed   bool
 	succeeded []string
@@ -46,9 +295,106 @@ type operationReport struct {
 	failed    []string
 }
 
+type trackOptions struct {
+	readonlyRepoFiles bool
+	linkMode          string
+	// mode, when non-zero, is applied to each repo file after it is moved in,
+	// and to the live file when that is a separate copy or decrypted secret.
+	mode fs.FileMode
+	// secrets, when non-nil, encrypts matching files into the repo instead
+	// of moving and linking them.
+	secrets *ageSecrets
+	// linkStrategy places the live file as a symlink, hardlink, or copy.
+	linkStrategy string
+}
+
+// modesManifestPath holds the explicit permissions recorded by `add --mode`,
+// one "<octal> <path>" line per file, since git only keeps the exec bit.
+const modesManifestPath = ".cfgs/modes"
+
+// dirsManifestPath lists the directories tracked as a whole by `add --dir`,
+// one path per line. Each is linked with a single directory symlink, and the
+// files inside it are not managed individually.
+const dirsManifestPath = ".cfgs/dirs"
+
+const (
+	linkModeAbsolute = "absolute"
+	linkModeRelative = "relative"
+)
+
+const (
+	linkStrategySymlink  = "symlink"
+	linkStrategyHardlink = "hardlink"
+	linkStrategyCopy     = "copy"
+)
+
+const (
+	syncStrategyRebase = "rebase"
+	syncStrategyMerge  = "merge"
+	syncStrategyFFOnly = "ff-only"
+)
+
+// linkStatus classifies a managed file's live path without changing it.
+type linkStatus string
+
+const (
+	linkStatusLinked      linkStatus = "linked"
+	linkStatusMissing     linkStatus = "missing"
+	linkStatusForeignLink linkStatus = "foreign-symlink"
+	linkStatusCopy        linkStatus = "copy"
+	linkStatusDiverged    linkStatus = "diverged"
+	linkStatusRepoMissing linkStatus = "repo-missing"
+	linkStatusUnsupported linkStatus = "unsupported"
+)
+
+var allLinkStatuses = []linkStatus{
+	linkStatusLinked,
+	linkStatusMissing,
+	linkStatusForeignLink,
+	linkStatusCopy,
+	linkStatusDiverged,
+	linkStatusRepoMissing,
+	linkStatusUnsupported,
+}
+
+// Exit codes for `cfgs status --exit-code`. When several states apply, the
+// first of unresolved, drift, and uncommitted in that order wins.
+const (
+	// statusExitDrift means live files are missing, plain copies, or diverged
+	// from the repo; doctor can reconcile them.
+	statusExitDrift = 2
+	// statusExitUncommitted means every file is linked but the repo has
+	// uncommitted changes.
+	statusExitUncommitted = 3
+	// statusExitUnresolved means a live path points elsewhere, a repo file is
+	// missing or unusable, or a dangling symlink into the repo remains.
+	statusExitUnresolved = 4
+)
+
+// exitError makes run exit with code without printing an error.
+type exitError struct {
+	code int
+}
+
+func (e exitError) Error() string {
+	return fmt.Sprintf("exit status %d", e.code)
+}
+
 type globMatcher struct {
 	pattern string
 	regex   *regexp.Regexp
+	// negate re-includes matching paths, like a gitignore "!pattern".
+	negate bool
+}
+
+type commandRequirement struct {
+	matcher globMatcher
+	command string
+}
+
+type modeRule struct {
+	matcher globMatcher
+	mode    fs.FileMode
 }
 
 var defaultIgnoreGlobs = []string{
@@ -56,26 +402,45 @@ var defaultIgnoreGlobs = []string{
 	"node_modules/**",
 	"**/node_modules",
 	"**/node_modules/**",
+	"*" + liveBackupSuffix,
 }
 
 func main() {
 	a := &app{
-		in:     bufio.NewReader(os.Stdin),
-		out:    os.Stdout,
-		errOut: os.Stderr,
+		in:            bufio.NewReader(os.Stdin),
+		out:           os.Stdout,
+		errOut:        os.Stderr,
+		runner:        execRunner{},
+		gitRetryDelay: gitRetryBaseDelay,
+	}
+	if host, err := os.Hostname(); err == nil {
+		a.hostname = host
+	} else {
+		fmt.Fprintf(a.errOut, "warning: could not determine the host name, so no host overlays or variants apply: %v\n", err)
 	}
-	os.Exit(a.run(context.Background(), os.Args[1:]))
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+	code := a.run(ctx, os.Args[1:])
+	stop()
+	os.Exit(code)
 }
 
 func (a *app) run(ctx context.Context, args []string) int {
+	args, err := a.parseGlobalFlags(args)
+	if err != nil {
+		fmt.Fprintf(a.errOut, "error: %v\n", err)
+		return 1
+	}
 	if len(args) == 0 {
 		a.printUsage()
 		return 1
 	}
 
-	if err := requireCommands("git", "fzf"); err != nil {
-		fmt.Fprintf(a.errOut, "error: %v\n", err)
-		return 1
+	// Importing into a plain directory is the git-free way back from export.
+	if args[0] != "import" {
+		if err := requireCommands("git"); err != nil {
+			fmt.Fprintf(a.errOut, "error: %v\n", err)
+			return 1
+		}
 	}
 
 	// Always read cfgs config before dispatching any command.
@@ -84,22 +449,62 @@ func (a *app) run(ctx context.Context, args []string) int {
 		return 1
 	}
 
-	var err error
+	cmdArgs := args[1:]
 	switch args[0] {
 	case "init":
-		err = a.cmdInit(ctx)
+		err = a.cmdInit(ctx, cmdArgs)
 	case "sync":
-		err = a.cmdSync(ctx)
+		err = a.cmdSync(ctx, cmdArgs)
 	case "add":
-		err = a.cmdAdd(ctx)
+		err = a.cmdAdd(ctx, cmdArgs)
 	case "remove":
-		err = a.cmdRemove(ctx)
+		err = a.cmdRemove(ctx, cmdArgs)
+	case "rename":
+		err = a.cmdRename(ctx, cmdArgs)
 	case "doctor":
-		err = a.cmdDoctor(ctx)
+		err = a.cmdDoctor(ctx, cmdArgs)
 	case "check":
-		err = a.cmdCheck(ctx)
+		err = a.cmdCheck(ctx, cmdArgs)
 	case "unlink":
-		err = a.cmdUnlink(ctx)
+		err = a.cmdUnlink(ctx, cmdArgs)
+	case "verify-repo":
+		err = a.cmdVerifyRepo(ctx, cmdArgs)
+	case "config-schema":
+		err = a.cmdConfigSchema(ctx, cmdArgs)
+	case "open":
+		err = a.cmdOpen(ctx, cmdArgs)
+	case "watch":
+		err = a.cmdWatch(ctx, cmdArgs)
+	case "diff":
+		err = a.cmdDiff(ctx, cmdArgs)
+	case "rollback":
+		err = a.cmdRollback(ctx, cmdArgs)
+	case "status":
+		err = a.cmdStatus(ctx, cmdArgs)
+	case "list":
+		err = a.cmdList(ctx, cmdArgs)
+	case "adopt":
+		err = a.cmdAdopt(ctx, cmdArgs)
+	case "restore":
+		err = a.cmdRestore(ctx, cmdArgs)
+	case "log":
+		err = a.cmdLog(ctx, cmdArgs)
+	case "profile":
+		err = a.cmdProfile(ctx, cmdArgs)
+	case "config":
+		err = a.cmdConfig(ctx, cmdArgs)
+	case "move-repo":
+		err = a.cmdMoveRepo(ctx, cmdArgs)
+	case "export":
+		err = a.cmdExport(ctx, cmdArgs)
+	case "import":
+		err = a.cmdImport(ctx, cmdArgs)
+	case "completion":
+		err = a.cmdCompletion(ctx, cmdArgs)
+	case "__complete":
+		err = a.cmdComplete(ctx, cmdArgs)
+	case "debug-ignore":
+		err = a.cmdDebugIgnore(ctx, cmdArgs)
 	case "help", "-h", "--help":
 		a.printUsage()
 		return 0
@@ -109,59 +514,278 @@ func (a *app) run(ctx context.Context, args []string) int {
 	}
 
 	if err != nil {
+		if errors.Is(err, flag.ErrHelp) {
+			return 0
+		}
+		var exitErr exitError
+		if errors.As(err, &exitErr) {
+			return exitErr.code
+		}
 		fmt.Fprintf(a.errOut, "error: %v\n", err)
 		return 1
 	}
 	return 0
 }
 
+// commandSummary is one line of the usage text; the list also drives shell
+// completion.
+type commandSummary struct {
+	name    string
+	summary string
+}
+
+var commandSummaries = []commandSummary{
+	{"init", "Initialize cfgs repository and track selected files"},
+	{"sync", "Pull latest from remote and run doctor"},
+	{"add", "Add more config files from XDG_CONFIG_HOME (--dir links whole directories)"},
+	{"remove", "Remove tracked files from repository and restore local copies"},
+	{"rename", "Move a tracked file to a new path, keeping its git history"},
+	{"doctor", "Reconcile symlinks between repo and XDG_CONFIG_HOME"},
+	{"check", "Quick git clean check with optional commit/push"},
+	{"unlink", "Replace tracked symlinks with local copies"},
+	{"verify-repo", "Check that every tracked repo file is a well-formed regular file"},
+	{"config-schema", "Print the JSON Schema for the cfgs config file"},
+	{"open", "Open the repository, or a tracked file, in the configured app"},
+	{"watch", "Poll the repo and live files and report drift as it happens"},
+	{"diff", "Show uncommitted repo changes, or live files against a past ref"},
+	{"rollback", "Return the repo to an earlier commit and run doctor"},
+	{"status", "Show link state and repo cleanliness without changing anything"},
+	{"list", "List tracked files with their link status"},
+	{"adopt", "Copy diverged live files back into the repo and relink them"},
+	{"restore", "Link every tracked file, refusing to start if any live path conflicts"},
+	{"log", "Show recent repo commits, optionally for one tracked file"},
+	{"profile", "List config profiles or switch the active one"},
+	{"config", "Get, set, or list values in the cfgs config file"},
+	{"move-repo", "Move the repository to a new path and repoint every symlink"},
+	{"export", "Write the tracked files to a .tar.gz archive"},
+	{"import", "Seed the repo from an export archive or a directory, then run doctor"},
+	{"completion", "Print a bash, zsh, or fish completion script"},
+}
+
+// parseGlobalFlags consumes the flags that may precede the command name and
+// returns the remaining arguments.
+func (a *app) parseGlobalFlags(args []string) ([]string, error) {
+	for len(args) > 0 {
+		switch {
+		case args[0] == "--repo":
+			if len(args) < 2 {
+				return nil, fmt.Errorf("--repo requires a path")
+			}
+			a.repoOverride, args = args[1], args[2:]
+		case strings.HasPrefix(args[0], "--repo="):
+			a.repoOverride, args = strings.TrimPrefix(args[0], "--repo="), args[1:]
+		default:
+			return args, nil
+		}
+	}
+	return args, nil
+}
+
 func (a *app) printUsage() {
-	fmt.Fprintln(a.out, "Usage: cfgs <command>")
+	fmt.Fprintln(a.out, "Usage: cfgs [--repo <path>] <command>")
 	fmt.Fprintln(a.out, "")
 	fmt.Fprintln(a.out, "Commands:")
-	fmt.Fprintln(a.out, "  init    Initialize cfgs repository and track selected files")
-	fmt.Fprintln(a.out, "  sync    Pull latest from remote and run doctor")
-	fmt.Fprintln(a.out, "  add     Add more config files from XDG_CONFIG_HOME")
-	fmt.Fprintln(a.out, "  remove  Remove tracked files from repository and restore local copies")
-	fmt.Fprintln(a.out, "  doctor  Reconcile symlinks between repo and XDG_CONFIG_HOME")
-	fmt.Fprintln(a.out, "  check   Quick git clean check with optional commit/push")
-	fmt.Fprintln(a.out, "  unlink  Replace tracked symlinks with local copies")
+	for _, command := range commandSummaries {
+		fmt.Fprintf(a.out, "  %-14s %s\n", command.name, command.summary)
+	}
+	fmt.Fprintln(a.out, "")
+	fmt.Fprintln(a.out, "Interactive selection uses fzf when installed. Without it, the candidates open")
+	fmt.Fprintln(a.out, "in $VISUAL or $EDITOR; delete the lines you do not want, then save and quit.")
+	fmt.Fprintln(a.out, "")
+	fmt.Fprintln(a.out, "Set CFGS_CONFIG to use a config file other than $XDG_CONFIG_HOME/cfgs/config.json.")
 }
 
-func (a *app) cmdInit(ctx context.Context) error {
+// trackedPathCommands take tracked paths as arguments, so completion offers
+// them through the hidden __complete command.
+var trackedPathCommands = []string{"remove", "unlink", "rename"}
+
+// cmdCompletion prints a completion script for shell. Subcommands are
+// completed statically; tracked paths come from `cfgs __complete`.
+func (a *app) cmdCompletion(ctx context.Context, args []string) error {
 	_ = ctx
+	flags := a.newFlagSet("completion")
+	positional, err := parseFlags(flags, args)
+	if err != nil {
+		return err
+	}
+	if len(positional) != 1 {
+		return fmt.Errorf("completion: expected one of bash, zsh, fish")
+	}
 
-	home, err := os.UserHomeDir()
+	names := make([]string, len(commandSummaries))
+	for i, command := range commandSummaries {
+		names[i] = command.name
+	}
+	pathCommands := strings.Join(trackedPathCommands, " ")
+
+	var b strings.Builder
+	switch positional[0] {
+	case "bash":
+		fmt.Fprintf(&b, `_cfgs() {
+	local cur=${COMP_WORDS[COMP_CWORD]}
+	if [ "$COMP_CWORD" -eq 1 ]; then
+		COMPREPLY=($(compgen -W %q -- "$cur"))
+		return
+	fi
+	case " %s " in
+	*" ${COMP_WORDS[1]} "*)
+		local IFS=$'
+'
+		COMPREPLY=($(cfgs __complete "${COMP_WORDS[1]}" "$cur" 2>/dev/null))
+		;;
+	esac
+}
+complete -F _cfgs cfgs
+`, strings.Join(names, " "), pathCommands)
+	case "zsh":
+		b.WriteString("#compdef cfgs\n\n_cfgs() {\n\tlocal -a commands\n\tcommands=(\n")
+		for _, command := range commandSummaries {
+			fmt.Fprintf(&b, "\t\t%s\n", shellSingleQuote(command.name+":"+strings.ReplaceAll(command.summary, ":", `\:`)))
+		}
+		fmt.Fprintf(&b, `	)
+	if (( CURRENT == 2 )); then
+		_describe 'command' commands
+		return
+	fi
+	case ${words[2]} in
+	%s)
+		local -a paths
+		paths=(${(f)"$(cfgs __complete ${words[2]} ${words[CURRENT]} 2>/dev/null)"})
+		compadd -a paths
+		;;
+	esac
+}
+
+compdef _cfgs cfgs
+`, strings.Join(trackedPathCommands, "|"))
+	case "fish":
+		b.WriteString("complete -c cfgs -f\n")
+		for _, command := range commandSummaries {
+			fmt.Fprintf(&b, "complete -c cfgs -n __fish_use_subcommand -a %s -d %s\n", command.name, shellSingleQuote(command.summary))
+		}
+		fmt.Fprintf(&b, "complete -c cfgs -n '__fish_seen_subcommand_from %s' -a '(cfgs __complete (commandline -opc)[2] (commandline -ct) 2>/dev/null)'\n", pathCommands)
+	default:
+		return fmt.Errorf("completion: unsupported shell %q (want bash, zsh, or fish)", positional[0])
+	}
+	_, err = io.WriteString(a.out, b.String())
+	return err
+}
+
+// cmdComplete is the hidden helper completion scripts call as
+// `cfgs __complete <command> <prefix>`. It prints one candidate per line and
+// stays silent when there is nothing to offer.
+func (a *app) cmdComplete(ctx context.Context, args []string) error {
+	_ = ctx
+	if len(args) == 0 || !slices.Contains(trackedPathCommands, args[0]) {
+		return nil
+	}
+	prefix := ""
+	if len(args) > 1 {
+		prefix = args[1]
+	}
+	a.errOut = io.Discard
+	repoPath, err := a.resolveRepoPath()
 	if err != nil {
-		return fmt.Errorf("resolve home directory: %w", err)
+		return nil
 	}
-	defaultRepo := filepath.Join(home, ".cfgs")
+	managed, err := a.loadManagedFiles(repoPath)
+	if err != nil {
+		return nil
+	}
+	for _, rel := range managed {
+		if strings.HasPrefix(rel, prefix) {
+			fmt.Fprintln(a.out, rel)
+		}
+	}
+	return nil
+}
 
-	repoInput, err := a.promptLine("Repository path or remote URL", defaultRepo)
+// cmdDebugIgnore is a hidden diagnostic that reports which ignore pattern,
+// if any, hides a path from the add candidates.
+func (a *app) cmdDebugIgnore(ctx context.Context, args []string) error {
+	_ = ctx
+	flags := a.newFlagSet("debug-ignore")
+	isDir := flags.Bool("dir", false, "treat the path as a directory even if it does not exist")
+	positional, err := parseFlags(flags, args)
 	if err != nil {
 		return err
 	}
-	repoInput = expandPath(repoInput)
+	if len(positional) != 1 {
+		return fmt.Errorf("debug-ignore: expected exactly one path relative to the config home, got %d", len(positional))
+	}
+	rel, err := normalizeManagedPath(positional[0])
+	if err != nil {
+		return err
+	}
+	if !*isDir {
+		if roots, err := configuredRoots(); err == nil {
+			if info, err := os.Stat(liveFilePath(roots, rel)); err == nil {
+				*isDir = info.IsDir()
+			}
+		}
+	}
+	matchers, err := a.configuredIgnoreMatchers()
+	if err != nil {
+		return err
+	}
+	ignored, matcher, matchedPath := explainIgnore(rel, *isDir, matchers)
+	switch {
+	case matcher == nil:
+		fmt.Fprintf(a.out, "%s: not ignored (no pattern matches)\n", rel)
+	case ignored:
+		fmt.Fprintf(a.out, "%s: ignored by %q (matched %s)\n", rel, matcher.pattern, matchedPath)
+	default:
+		fmt.Fprintf(a.out, "%s: not ignored, re-included by %q (matched %s)\n", rel, matcher.pattern, matchedPath)
+	}
+	return nil
+}
+
+// shellSingleQuote quotes s for POSIX shells, zsh, and fish alike.
+func shellSingleQuote(s string) string {
+	return "'" + strings.ReplaceAll(s, "'", `'\''`) + "'"
+}
+
+func (a *app) cmdInit(ctx context.Context, args []string) error {
+	flags := a.newFlagSet("init")
+	a.addYesFlags(flags)
+	a.addCommitMessageFlags(flags)
+	importExisting := flags.Bool("import-existing", false, "choose which files of a non-empty repository to link")
+	bareRemote := flags.Bool("bare-remote", false, "create a new local repository for an empty remote")
+	flags.BoolVar(&a.jsonOutput, "json", false, "print reports as JSON")
+	flags.BoolVar(&a.quiet, "quiet", false, "print reports only when something fails or needs manual reconcile")
+	var exclude stringListFlag
+	flags.Var(&exclude, "exclude", "leave live paths matching `glob` out of the candidates for this run (repeatable)")
+	if err := parseNoPositional(flags, args); err != nil {
+		return err
+	}
+	if *bareRemote && *importExisting {
+		return fmt.Errorf("--bare-remote and --import-existing are mutually exclusive")
+	}
+	excludeMatchers, err := compileGlobMatchers(exclude)
+	if err != nil {
+		return fmt.Errorf("--exclude: %w", err)
+	}
+
+	home, err := os.UserHomeDir()
+	if err != nil {
+		return fmt.Errorf("resolve home directory: %w", err)
+	}
+	defaultRepo := filepath.Join(home, ".cfgs")
 
 	var repoPath string
-	if looksLikeRemote(repoInput) {
-		dest, err := a.promptLine("Clone destination", defaultRepo)
+	if *bareRemote {
+		repoPath, err = a.createRepoForRemote(defaultRepo)
 		if err != nil {
 			return err
 		}
-		dest = expandPath(dest)
-		if err := ensureEmptyOrMissingDir(dest); err != nil {
-			return err
-		}
-		if _, err := runCommand("", "git", "clone", repoInput, dest); err != nil {
+	} else {
+		repoPath, err = a.promptRepoOrClone(defaultRepo)
+		if err != nil {
 			return err
 		}
-		repoPath = dest
-	} else {
-		repoPath = repoInput
 	}
 
-	repoPath, err = validateAndNormalizeRepo(repoPath)
+	repoPath, err = a.validateAndNormalizeRepo(repoPath)
 	if err != nil {
 		return err
 	}
@@ -173,32 +797,35 @@ func (a *app) cmdInit(ctx context.Context) error {
 	if ok && len(cfg.IgnoreGlobs) > 0 {
 		ignoreGlobs = append([]string(nil), cfg.IgnoreGlobs...)
 	}
-	if err := saveCfgsConfig(cfgsConfig{
-		RepoPath:    repoPath,
-		IgnoreGlobs: ignoreGlobs,
-	}); err != nil {
+	cfg.RepoPath = repoPath
+	cfg.IgnoreGlobs = ignoreGlobs
+	if err := saveCfgsConfig(cfg); err != nil {
 		return err
 	}
 
-	isEmpty, err := repoIsEmpty(repoPath)
+	isEmpty, err := a.repoIsEmpty(repoPath)
 	if err != nil {
 		return err
 	}
 	if !isEmpty {
+		if *importExisting {
+			return a.importExistingFiles(ctx, repoPath)
+		}
 		fmt.Fprintln(a.out, "Repository is not empty; running doctor.")
-		return a.cmdDoctorWithRepo(ctx, repoPath)
+		return a.cmdDoctorWithRepo(ctx, repoPath, doctorOptions{})
 	}
 
-	candidates, err := scanXDGRegularFiles()
+	candidates, problems, err := a.scanXDGRegularFiles(repoPath, excludeMatchers)
 	if err != nil {
 		return err
 	}
+	a.warnScanProblems(problems)
 	if len(candidates) == 0 {
-		fmt.Fprintln(a.out, "No files found in XDG_CONFIG_HOME.")
+		fmt.Fprintln(a.out, "No files found in the configured roots.")
 		return nil
 	}
 
-	selected, err := selectWithFzf(candidates, "init> ")
+	selected, err := a.selectWithFzf(candidates, "init> ")
 	if err != nil {
 		return err
 	}
@@ -207,80 +834,108 @@ func (a *app) cmdInit(ctx context.Context) error {
 		return nil
 	}
 
-	managed, err := loadManagedFiles(repoPath)
+	managed, err := a.loadManagedFiles(repoPath)
+	if err != nil {
+		return err
+	}
+	secrets, err := a.loadAgeSecrets(cfg)
 	if err != nil {
 		return err
 	}
-	report, _ := trackSelections(repoPath, managed, selected)
-	printOperationReport(a.out, "init", report)
+	report, _ := a.trackSelections(repoPath, managed, selected, trackOptions{
+		readonlyRepoFiles: cfg.ReadonlyRepoFiles,
+		linkMode:          cfg.LinkMode,
+		linkStrategy:      cfg.linkStrategy(),
+		secrets:           secrets,
+	})
+	if err := a.emitOperationReport("init", report); err != nil {
+		return err
+	}
 
 	if report.changed {
-		if err := a.commitAndAskPush(repoPath); err != nil {
+		if err := a.commitAndAskPush(ctx, repoPath, "init", len(report.succeeded)); err != nil {
 			return err
 		}
 	}
 	return nil
 }
 
-func (a *app) cmdSync(ctx context.Context) error {
-	_ = ctx
-	repoPath, err := a.resolveRepoPath()
+// promptRepoOrClone asks for an existing repository path or a remote URL,
+// cloning the latter into a destination the user picks.
+func (a *app) promptRepoOrClone(defaultRepo string) (string, error) {
+	repoInput, err := a.promptLine("Repository path or remote URL", defaultRepo)
 	if err != nil {
-		return err
+		return "", err
 	}
-	beforeHead, beforeExists, err := gitHead(repoPath)
-	if err != nil {
-		return err
+	repoInput = expandPath(repoInput)
+	if !looksLikeRemote(repoInput) {
+		return repoInput, nil
 	}
 
-	if _, err := runCommand(repoPath, "git", "pull", "--rebase", "--autostash"); err != nil {
-		_, _ = runCommand(repoPath, "git", "rebase", "--abort")
-		_, _ = runCommand(repoPath, "git", "merge", "--abort")
-		return fmt.Errorf("sync failed; aborted any in-progress merge/rebase. Resolve manually with git pull + conflict resolution: %w", err)
-	}
-	afterHead, afterExists, err := gitHead(repoPath)
+	dest, err := a.promptLine("Clone destination", defaultRepo)
 	if err != nil {
-		return err
+		return "", err
 	}
-
-	if err := a.showSyncDiff(repoPath, beforeHead, beforeExists, afterHead, afterExists); err != nil {
-		return err
+	dest = expandPath(dest)
+	if err := ensureEmptyOrMissingDir(dest); err != nil {
+		return "", err
 	}
-
-	return a.cmdDoctorWithRepo(ctx, repoPath)
+	if err := a.runInteractiveCommand("", "git", "clone", repoInput, dest); err != nil {
+		return "", err
+	}
+	return dest, nil
 }
 
-func (a *app) cmdAdd(ctx context.Context) error {
-	_ = ctx
-	repoPath, err := a.resolveRepoPath()
+// createRepoForRemote starts a fresh repository in an empty or missing
+// directory, points origin at a remote that has no history yet, and makes
+// an initial empty commit so the branch exists.
+func (a *app) createRepoForRemote(defaultRepo string) (string, error) {
+	repoPath, err := a.promptLine("Repository path", defaultRepo)
 	if err != nil {
-		return err
+		return "", err
 	}
-
-	allXDGFiles, err := scanXDGRegularFiles()
-	if err != nil {
-		return err
+	repoPath = expandPath(repoPath)
+	if err := ensureEmptyOrMissingDir(repoPath); err != nil {
+		return "", err
 	}
-	managed, err := loadManagedFiles(repoPath)
+	remoteURL, err := a.promptLine("Remote URL", "")
 	if err != nil {
-		return err
+		return "", err
+	}
+	remoteURL = strings.TrimSpace(remoteURL)
+	if remoteURL == "" {
+		return "", fmt.Errorf("remote URL is required")
 	}
-	managedSet := sliceToSet(managed)
 
-	var candidates []string
-	for _, rel := range allXDGFiles {
-		if _, ok := managedSet[rel]; !ok {
-			candidates = append(candidates, rel)
-		}
+	if err := os.MkdirAll(repoPath, 0o755); err != nil {
+		return "", err
 	}
-	sort.Strings(candidates)
+	if _, err := a.runCommand(repoPath, "git", "init"); err != nil {
+		return "", err
+	}
+	if _, err := a.runCommand(repoPath, "git", "remote", "add", "origin", remoteURL); err != nil {
+		return "", err
+	}
+	if err := a.commitChanges(repoPath, "Initialize cfgs repository", "--allow-empty"); err != nil {
+		return "", err
+	}
+	return repoPath, nil
+}
 
-	if len(candidates) == 0 {
-		fmt.Fprintln(a.out, "No untracked files available to add.")
+// importExistingFiles lets the user pick which files of a pre-populated repo
+// to link on this machine. Unselected files are recorded as excluded so later
+// doctor runs leave them alone.
+func (a *app) importExistingFiles(ctx context.Context, repoPath string) error {
+	managed, err := a.loadManagedFiles(repoPath)
+	if err != nil {
+		return err
+	}
+	if len(managed) == 0 {
+		fmt.Fprintln(a.out, "No tracked files found.")
 		return nil
 	}
 
-	selected, err := selectWithFzf(candidates, "add> ")
+	selected, err := a.selectWithFzf(managed, "import> ")
 	if err != nil {
 		return err
 	}
@@ -289,282 +944,412 @@ func (a *app) cmdAdd(ctx context.Context) error {
 		return nil
 	}
 
-	report, _ := trackSelections(repoPath, managed, selected)
-	printOperationReport(a.out, "add", report)
-
-	if report.changed {
-		if err := a.commitAndAskPush(repoPath); err != nil {
-			return err
+	selectedSet := sliceToSet(selected)
+	var excluded []string
+	for _, rel := range managed {
+		if _, ok := selectedSet[rel]; !ok {
+			excluded = append(excluded, rel)
 		}
 	}
-	return nil
+
+	cfg, _, err := loadCfgsConfig()
+	if err != nil {
+		return err
+	}
+	cfg.ExcludedPaths = excluded
+	if err := saveCfgsConfig(cfg); err != nil {
+		return err
+	}
+	if len(excluded) > 0 {
+		fmt.Fprintf(a.out, "Excluded %d file(s) on this machine; edit excluded_paths in the cfgs config to link them later.\n", len(excluded))
+	}
+	return a.cmdDoctorWithRepo(ctx, repoPath, doctorOptions{})
 }
 
-func (a *app) cmdRemove(ctx context.Context) error {
-	_ = ctx
+func (a *app) cmdSync(ctx context.Context, args []string) error {
+	flags := a.newFlagSet("sync")
+	a.addYesFlags(flags)
+	flags.StringVar(&a.remote, "remote", "", "git remote to pull from and push to")
+	strategyFlag := flags.String("strategy", "", "pull strategy: rebase, merge, or ff-only (default from config, else rebase)")
+	var diffOpts syncDiffOptions
+	flags.BoolVar(&diffOpts.stat, "stat", false, "show a diffstat of pulled changes instead of the full patch")
+	flags.BoolVar(&diffOpts.noDiff, "no-diff", false, "only print the pulled hash range, without a diff")
+	branchFlag := flags.String("branch", "", "check out `name` (local or tracked from the remote) before pulling")
+	if err := parseNoPositional(flags, args); err != nil {
+		return err
+	}
+	if diffOpts.stat && diffOpts.noDiff {
+		return fmt.Errorf("--stat and --no-diff are mutually exclusive")
+	}
+
+	cfg, _, err := loadCfgsConfig()
+	if err != nil {
+		return err
+	}
+	strategy := cfg.SyncStrategy
+	if *strategyFlag != "" {
+		strategy = *strategyFlag
+	}
+	pullArgs, err := syncPullArgs(cfg, strategy)
+	if err != nil {
+		return err
+	}
+
 	repoPath, err := a.resolveRepoPath()
 	if err != nil {
 		return err
 	}
-	managed, err := loadManagedFiles(repoPath)
+	remote, err := a.resolveRemote(repoPath)
 	if err != nil {
 		return err
 	}
-	if len(managed) == 0 {
-		fmt.Fprintln(a.out, "No tracked files to remove.")
-		return nil
+	if *branchFlag != "" {
+		if err := a.switchSyncBranch(ctx, repoPath, remote, *branchFlag, cfg.networkRetries()); err != nil {
+			return err
+		}
+	}
+	branch := a.gitBranchOrDetached(repoPath)
+	beforeHead, beforeExists, err := a.gitHead(repoPath)
+	if err != nil {
+		return err
 	}
 
-	selected, err := selectWithFzf(managed, "remove> ")
+	if err := a.runGitWithRetry(ctx, repoPath, cfg.networkRetries(), append(pullArgs, remoteRefArgs(remote, branch)...)...); err != nil {
+		switch normalizeSyncStrategy(strategy) {
+		case syncStrategyRebase:
+			_, _ = a.runCommand(repoPath, "git", "rebase", "--abort")
+		case syncStrategyMerge:
+			_, _ = a.runCommand(repoPath, "git", "merge", "--abort")
+		}
+		return fmt.Errorf("sync failed; aborted any in-progress merge/rebase. Resolve manually with git pull + conflict resolution: %w", err)
+	}
+	afterHead, afterExists, err := a.gitHead(repoPath)
 	if err != nil {
 		return err
 	}
-	if len(selected) == 0 {
-		fmt.Fprintln(a.out, "No files selected.")
-		return nil
+	if branch == "" {
+		fmt.Fprintln(a.out, "Synced detached HEAD using git's defaults.")
+	} else {
+		fmt.Fprintf(a.out, "Synced branch %s from %s.\n", branch, remote)
 	}
 
-	xdg, err := xdgConfigHome()
-	if err != nil {
+	if err := a.showSyncDiff(repoPath, beforeHead, beforeExists, afterHead, afterExists, diffOpts); err != nil {
+		return err
+	}
+	if err := a.reportAheadBehind(repoPath); err != nil {
 		return err
 	}
 
-	report := operationReport{}
+	if err := a.cmdDoctorWithRepo(ctx, repoPath, doctorOptions{}); err != nil {
+		return err
+	}
+	return a.offerPushAfterSync(ctx, repoPath, remote, branch)
+}
 
-	for _, raw := range selected {
-		rel, err := normalizeManagedPath(raw)
-		if err != nil {
-			report.failed = append(report.failed, fmt.Sprintf("%s: invalid path", raw))
-			continue
-		}
-
-		repoFile := filepath.Join(repoPath, filepath.FromSlash(rel))
-		liveFile := filepath.Join(xdg, filepath.FromSlash(rel))
-
-		if _, err := os.Stat(repoFile); err != nil {
-			report.failed = append(report.failed, fmt.Sprintf("%s: repo file missing", rel))
-			continue
-		}
-
-		if err := ensureLiveCopyForRemove(repoFile, liveFile); err != nil {
-			report.failed = append(report.failed, fmt.Sprintf("%s: %v", rel, err))
-			continue
-		}
-
-		if err := os.Remove(repoFile); err != nil {
-			report.failed = append(report.failed, fmt.Sprintf("%s: remove repo file: %v", rel, err))
-			continue
-		}
-		removeEmptyDirsUpward(repoPath, filepath.Dir(repoFile))
-
-		report.changed = true
-		report.succeeded = append(report.succeeded, rel)
+// switchSyncBranch checks out name before a sync, creating a local branch
+// that tracks remote/name when only the remote has it. It refuses to switch
+// with uncommitted changes so nothing is carried onto the other branch.
+func (a *app) switchSyncBranch(ctx context.Context, repoPath string, remote string, name string, retries int) error {
+	if _, err := a.runCommand(repoPath, "git", "check-ref-format", "--branch", name); err != nil {
+		return fmt.Errorf("invalid branch name %q", name)
+	}
+	if current, err := a.gitCurrentBranch(repoPath); err == nil && current == name {
+		return nil
+	}
+	dirty, err := a.gitIsDirty(repoPath)
+	if err != nil {
+		return err
+	}
+	if dirty {
+		return fmt.Errorf("cannot switch to branch %q: the repo has uncommitted changes; commit them with `cfgs check` or stash them first", name)
 	}
 
-	printOperationReport(a.out, "remove", report)
+	if _, err := a.runCommand(repoPath, "git", "show-ref", "--verify", "--quiet", "refs/heads/"+name); err == nil {
+		_, err := a.runCommand(repoPath, "git", "checkout", name)
+		return err
+	}
+	if err := a.runGitWithRetry(ctx, repoPath, retries, "fetch", remote, name); err != nil {
+		return fmt.Errorf("branch %q exists neither locally nor on %s: %w", name, remote, err)
+	}
+	_, err = a.runCommand(repoPath, "git", "checkout", "--track", "-b", name, remote+"/"+name)
+	return err
+}
 
-	if report.changed {
-		if err := a.commitAndAskPush(repoPath); err != nil {
+// offerPushAfterSync asks to push when the branch still has commits its
+// upstream lacks, e.g. ones made on this machine and never pushed.
+func (a *app) offerPushAfterSync(ctx context.Context, repoPath string, remote string, branch string) error {
+	ahead, _, hasUpstream, err := a.gitAheadBehind(repoPath)
+	if err != nil {
+		return err
+	}
+	if !hasUpstream || ahead == 0 {
+		return nil
+	}
+	target := remote
+	if branch != "" {
+		target = remote + "/" + branch
+	}
+	if !a.pushWithoutAsking {
+		pushNow, err := a.promptYesNo(fmt.Sprintf("Push %d unpushed commit(s) to %s?", ahead, target), false)
+		if err != nil {
 			return err
 		}
+		if !pushNow {
+			return nil
+		}
 	}
-	return nil
+	return a.pushWithRetry(ctx, repoPath, remote, branch)
 }
 
-func (a *app) cmdDoctor(ctx context.Context) error {
-	_ = ctx
-	repoPath, err := a.resolveRepoPath()
+// cmdRollback returns the repo to ref, either by hard reset or by reverting
+// every later commit, and then reconciles the live tree against it.
+func (a *app) cmdRollback(ctx context.Context, args []string) error {
+	flags := a.newFlagSet("rollback")
+	a.addYesFlags(flags)
+	revert := flags.Bool("revert", false, "create revert commits instead of resetting, keeping history intact")
+	positional, err := parseFlags(flags, args)
 	if err != nil {
 		return err
 	}
-	return a.cmdDoctorWithRepo(ctx, repoPath)
-}
-
-func (a *app) cmdDoctorWithRepo(ctx context.Context, repoPath string) error {
-	_ = ctx
+	if len(positional) != 1 {
+		return fmt.Errorf("rollback: expected exactly one ref, got %d", len(positional))
+	}
+	ref := positional[0]
 
-	managed, err := loadManagedFiles(repoPath)
+	repoPath, err := a.resolveRepoPath()
 	if err != nil {
 		return err
 	}
-	if len(managed) == 0 {
-		fmt.Fprintln(a.out, "No tracked files found.")
+	target, err := a.runCommand(repoPath, "git", "rev-parse", "--verify", "--quiet", ref+"^{commit}")
+	if err != nil {
+		return fmt.Errorf("unknown ref %q", ref)
+	}
+	commits, err := a.runCommand(repoPath, "git", "--no-pager", "log", "--oneline", target+"..HEAD")
+	if err != nil {
+		return err
+	}
+	if commits == "" {
+		fmt.Fprintf(a.out, "rollback: HEAD is already at or behind %s.\n", ref)
 		return nil
 	}
-
-	xdg, err := xdgConfigHome()
+	dirty, err := a.gitIsDirty(repoPath)
 	if err != nil {
 		return err
 	}
 
-	report := doctorReport{}
-	managedSet := sliceToSet(managed)
-
-	for _, rel := range managed {
-		repoFile := filepath.Join(repoPath, filepath.FromSlash(rel))
-		liveFile := filepath.Join(xdg, filepath.FromSlash(rel))
-
-		repoInfo, err := os.Stat(repoFile)
-		if err != nil || !repoInfo.Mode().IsRegular() {
-			report.requireManualResolve = append(report.requireManualResolve, rel)
-			continue
+	fmt.Fprintf(a.out, "rollback: commits after %s (%s):\n%s\n", ref, shortHash(target), commits)
+	if *revert {
+		if dirty {
+			return fmt.Errorf("rollback --revert needs a clean repository; commit or stash your changes first")
 		}
-
-		liveInfo, err := os.Lstat(liveFile)
+		ok, err := a.promptYesNo("Create revert commits for these changes?", false)
 		if err != nil {
-			if !errors.Is(err, fs.ErrNotExist) {
-				report.requireManualResolve = append(report.requireManualResolve, rel)
-				continue
-			}
-			if err := os.MkdirAll(filepath.Dir(liveFile), 0o755); err != nil {
-				report.requireManualResolve = append(report.requireManualResolve, rel)
-				continue
-			}
-			if err := os.Symlink(repoFile, liveFile); err != nil {
-				report.requireManualResolve = append(report.requireManualResolve, rel)
-				continue
-			}
-			report.replacedWithSymlink = append(report.replacedWithSymlink, rel)
-			continue
-		}
-
-		if liveInfo.Mode()&os.ModeSymlink != 0 {
-			ok, err := symlinkPointsTo(liveFile, repoFile)
-			if err != nil {
-				report.requireManualResolve = append(report.requireManualResolve, rel)
-			} else if ok {
-				report.didNotTouch = append(report.didNotTouch, rel)
-			} else {
-				report.requireManualResolve = append(report.requireManualResolve, rel)
-			}
-			continue
+			return err
 		}
-
-		if !liveInfo.Mode().IsRegular() {
-			report.requireManualResolve = append(report.requireManualResolve, rel)
-			continue
+		if !ok {
+			return nil
 		}
-
-		same, err := filesEqual(repoFile, liveFile)
+		revertArgs, err := gitCommitArgs("revert", "--no-edit", target+"..HEAD")
 		if err != nil {
-			report.requireManualResolve = append(report.requireManualResolve, rel)
-			continue
+			return err
 		}
-		if !same {
-			report.requireManualResolve = append(report.requireManualResolve, rel)
-			continue
+		if _, err := a.runCommand(repoPath, "git", revertArgs...); err != nil {
+			_, _ = a.runCommand(repoPath, "git", "revert", "--abort")
+			return fmt.Errorf("revert failed and was aborted; use `cfgs rollback` without --revert or revert by hand: %w", err)
 		}
-
-		if err := os.Remove(liveFile); err != nil {
-			report.requireManualResolve = append(report.requireManualResolve, rel)
-			continue
+	} else {
+		fmt.Fprintln(a.errOut, "WARNING: git reset --hard discards the commits above from this branch.")
+		if dirty {
+			fmt.Fprintln(a.errOut, "WARNING: the repository has uncommitted changes; they will be lost permanently.")
 		}
-		if err := os.Symlink(repoFile, liveFile); err != nil {
-			report.requireManualResolve = append(report.requireManualResolve, rel)
-			continue
+		ok, err := a.promptYesNo(fmt.Sprintf("Reset the repository to %s?", ref), false)
+		if err != nil {
+			return err
+		}
+		if !ok {
+			return nil
+		}
+		if _, err := a.runCommand(repoPath, "git", "reset", "--hard", target); err != nil {
+			return err
 		}
-		report.replacedWithSymlink = append(report.replacedWithSymlink, rel)
 	}
+	fmt.Fprintf(a.out, "rollback: repository restored to %s.\n", shortHash(target))
 
-	ignoreMatchers, err := configuredIgnoreMatchers()
-	if err != nil {
+	if err := a.cmdDoctorWithRepo(ctx, repoPath, doctorOptions{}); err != nil {
 		return err
 	}
-	orphanReport, err := reconcileOrphanRepoSymlinks(repoPath, xdg, managedSet, ignoreMatchers)
-	if err != nil {
-		return err
+	if *revert {
+		return a.askPush(ctx, repoPath)
 	}
-	report.unlinkedOrphanSymlink = append(report.unlinkedOrphanSymlink, orphanReport.unlinkedOrphanSymlink...)
-	report.requireManualResolve = append(report.requireManualResolve, orphanReport.requireManualResolve...)
+	return nil
+}
 
-	printDoctorReport(a.out, report)
+func normalizeSyncStrategy(strategy string) string {
+	strategy = strings.ToLower(strings.TrimSpace(strategy))
+	if strategy == "" {
+		return syncStrategyRebase
+	}
+	return strategy
+}
 
-	if len(report.requireManualResolve) > 0 {
-		return fmt.Errorf("manual reconcile required for %d file(s)", len(report.requireManualResolve))
+// syncPullArgs returns the git pull invocation for a sync strategy, without
+// the remote and branch.
+// syncPullArgs builds the pull for strategy. The rebase and merge strategies
+// can create commits, so they are signed like every other cfgs commit when
+// sign_commits is set.
+func syncPullArgs(cfg cfgsConfig, strategy string) ([]string, error) {
+	switch normalizeSyncStrategy(strategy) {
+	case syncStrategyRebase:
+		return append([]string{"pull", "--rebase", "--autostash"}, commitSigningArgs(cfg)...), nil
+	case syncStrategyMerge:
+		return append([]string{"pull", "--no-rebase", "--autostash"}, commitSigningArgs(cfg)...), nil
+	case syncStrategyFFOnly:
+		return []string{"pull", "--ff-only"}, nil
+	default:
+		return nil, fmt.Errorf("invalid sync strategy %q (want %s, %s, or %s)", strategy, syncStrategyRebase, syncStrategyMerge, syncStrategyFFOnly)
 	}
-	return nil
 }
 
-func (a *app) cmdCheck(ctx context.Context) error {
-	_ = ctx
-	repoPath, err := a.resolveRepoPath()
+func (a *app) cmdAdd(ctx context.Context, args []string) error {
+	flags := a.newFlagSet("add")
+	a.addYesFlags(flags)
+	a.addCommitMessageFlags(flags)
+	a.addNoCommitFlag(flags)
+	var linkOnly stringListFlag
+	flags.Var(&linkOnly, "link-only", "link an existing repo file `path` into place without moving anything (repeatable)")
+	modeFlag := flags.String("mode", "", "apply octal permission `perm` (e.g. 600) to the tracked files")
+	flags.BoolVar(&a.jsonOutput, "json", false, "print the report as JSON")
+	flags.BoolVar(&a.quiet, "quiet", false, "print the report only when something fails")
+	stdin := flags.Bool("stdin", false, "read newline-separated paths from standard input instead of fzf")
+	dirFlag := flags.Bool("dir", false, "track each path argument as a whole directory linked by one symlink")
+	var exclude stringListFlag
+	flags.Var(&exclude, "exclude", "leave live paths matching `glob` out of the candidates for this run (repeatable)")
+	positional, err := parseFlags(flags, args)
 	if err != nil {
 		return err
 	}
-
-	dirty, err := gitIsDirty(repoPath)
+	excludeMatchers, err := compileGlobMatchers(exclude)
+	if err != nil {
+		return fmt.Errorf("--exclude: %w", err)
+	}
+	explicit, err := a.pathArguments(positional, *stdin)
 	if err != nil {
 		return err
 	}
-	if !dirty {
-		fmt.Fprintln(a.out, "Git working tree is clean.")
-		return nil
+	if len(explicit) > 0 && len(linkOnly) > 0 {
+		return fmt.Errorf("--link-only cannot be combined with path arguments")
 	}
-
-	if err := a.showCheckDiff(repoPath); err != nil {
-		return err
+	if *dirFlag && len(explicit) == 0 {
+		return fmt.Errorf("--dir requires directory path arguments")
 	}
-
-	commitNow, err := a.promptYesNo("Uncommitted changes detected. Commit them now?", true)
+	if *dirFlag && *modeFlag != "" {
+		return fmt.Errorf("--dir cannot be combined with --mode")
+	}
+	var mode fs.FileMode
+	if *modeFlag != "" {
+		parsed, err := parseFileMode(*modeFlag)
+		if err != nil {
+			return err
+		}
+		mode = parsed
+	}
+	repoPath, err := a.resolveRepoPath()
 	if err != nil {
 		return err
 	}
-	if !commitNow {
-		fmt.Fprintln(a.out, "Skipped commit.")
-		return nil
+	if len(linkOnly) > 0 {
+		return a.linkExistingRepoFiles(ctx, repoPath, linkOnly)
+	}
+	if *dirFlag {
+		return a.addDirectories(ctx, repoPath, explicit)
 	}
 
-	if _, err := runCommand(repoPath, "git", "add", "-A"); err != nil {
+	allXDGFiles, problems, err := a.scanXDGRegularFiles(repoPath, excludeMatchers)
+	if err != nil {
 		return err
 	}
-	if err := commitWithEditor(repoPath); err != nil {
+	a.warnScanProblems(problems)
+	managed, err := a.loadManagedFiles(repoPath)
+	if err != nil {
 		return err
 	}
+	managedSet := sliceToSet(managed)
+	liveManaged := managedLiveSet(managed)
+
+	var candidates []string
+	for _, rel := range allXDGFiles {
+		if _, ok := liveManaged[rel]; !ok {
+			candidates = append(candidates, rel)
+		}
+	}
+	sort.Strings(candidates)
+
+	if len(candidates) == 0 && len(explicit) == 0 {
+		fmt.Fprintln(a.out, "No untracked files available to add.")
+		return nil
+	}
 
-	pushNow, err := a.promptYesNo("Push commit now?", false)
+	selected, err := a.selectFiles(candidates, explicit, "add> ")
 	if err != nil {
 		return err
 	}
-	if pushNow {
-		if _, err := runCommand(repoPath, "git", "push"); err != nil {
-			return err
-		}
+	if len(selected) == 0 {
+		fmt.Fprintln(a.out, "No files selected.")
+		return nil
 	}
 
-	return a.cmdDoctorWithRepo(ctx, repoPath)
-}
-
-func (a *app) cmdUnlink(ctx context.Context) error {
-	_ = ctx
-	repoPath, err := a.resolveRepoPath()
+	cfg, _, err := loadCfgsConfig()
 	if err != nil {
 		return err
 	}
-	managed, err := loadManagedFiles(repoPath)
+	secrets, err := a.loadAgeSecrets(cfg)
 	if err != nil {
 		return err
 	}
-	if len(managed) == 0 {
-		fmt.Fprintln(a.out, "No tracked files to unlink.")
-		return nil
+	report, trackedSet := a.trackSelections(repoPath, managed, selected, trackOptions{
+		readonlyRepoFiles: cfg.ReadonlyRepoFiles,
+		linkMode:          cfg.LinkMode,
+		linkStrategy:      cfg.linkStrategy(),
+		mode:              mode,
+		secrets:           secrets,
+	})
+	if mode != 0 {
+		if err := recordFileModes(repoPath, managedSet, trackedSet, mode); err != nil {
+			report.failed = append(report.failed, fmt.Sprintf("%s: %v", modesManifestPath, err))
+		}
+	}
+	if err := a.emitOperationReport("add", report); err != nil {
+		return err
+	}
+
+	if report.changed {
+		if err := a.commitAndAskPush(ctx, repoPath, "add", len(report.succeeded)); err != nil {
+			return err
+		}
 	}
+	return nil
+}
 
-	selected, err := selectWithFzf(managed, "unlink> ")
+// linkExistingRepoFiles hooks up files that were placed in the repo by hand:
+// each live path must be absent and is linked to the repo file, which is
+// staged if git does not track it yet.
+func (a *app) linkExistingRepoFiles(ctx context.Context, repoPath string, paths []string) error {
+	roots, err := configuredRoots()
 	if err != nil {
 		return err
 	}
-	if len(selected) == 0 {
-		fmt.Fprintln(a.out, "No files selected.")
-		return nil
+	cfg, _, err := loadCfgsConfig()
+	if err != nil {
+		return err
 	}
-
-	xdg, err := xdgConfigHome()
+	managed, err := a.loadManagedFiles(repoPath)
 	if err != nil {
 		return err
 	}
+	managedSet := sliceToSet(managed)
 
 	report := operationReport{}
-	for _, raw := range selected {
+	for _, raw := range paths {
 		rel, err := normalizeManagedPath(raw)
 		if err != nil {
 			report.failed = append(report.failed, fmt.Sprintf("%s: invalid path", raw))
@@ -572,109 +1357,3677 @@ func (a *app) cmdUnlink(ctx context.Context) error {
 		}
 
 		repoFile := filepath.Join(repoPath, filepath.FromSlash(rel))
-		liveFile := filepath.Join(xdg, filepath.FromSlash(rel))
-		liveInfo, err := os.Lstat(liveFile)
+		liveFile := liveFilePath(roots, rel)
+
+		repoInfo, err := os.Lstat(repoFile)
 		if err != nil {
-			report.skipped = append(report.skipped, fmt.Sprintf("%s: live file missing", rel))
+			report.failed = append(report.failed, fmt.Sprintf("%s: repo file missing", rel))
 			continue
 		}
-		if liveInfo.Mode()&os.ModeSymlink == 0 {
-			report.skipped = append(report.skipped, fmt.Sprintf("%s: live file is not a symlink", rel))
+		if !repoInfo.Mode().IsRegular() {
+			report.failed = append(report.failed, fmt.Sprintf("%s: repo file is not a regular file", rel))
 			continue
 		}
 
-		ok, err := symlinkPointsTo(liveFile, repoFile)
-		if err != nil || !ok {
-			report.skipped = append(report.skipped, fmt.Sprintf("%s: symlink does not point to repo file", rel))
+		if _, err := os.Lstat(liveFile); err == nil {
+			if ok, _ := symlinkPointsTo(liveFile, repoFile); ok {
+				report.skipped = append(report.skipped, fmt.Sprintf("%s: already linked", rel))
+			} else {
+				report.failed = append(report.failed, fmt.Sprintf("%s: live path already exists", rel))
+			}
+			continue
+		} else if !errors.Is(err, fs.ErrNotExist) {
+			report.failed = append(report.failed, fmt.Sprintf("%s: live path check failed: %v", rel, err))
 			continue
 		}
 
-		if err := os.Remove(liveFile); err != nil {
-			report.failed = append(report.failed, fmt.Sprintf("%s: remove symlink: %v", rel, err))
+		if err := os.MkdirAll(filepath.Dir(liveFile), 0o755); err != nil {
+			report.failed = append(report.failed, fmt.Sprintf("%s: create live dir: %v", rel, err))
 			continue
 		}
-		if err := copyFile(repoFile, liveFile); err != nil {
-			report.failed = append(report.failed, fmt.Sprintf("%s: copy file: %v", rel, err))
+		if err := createSymlink(repoFile, liveFile, cfg.LinkMode); err != nil {
+			report.failed = append(report.failed, fmt.Sprintf("%s: create symlink: %v", rel, err))
 			continue
 		}
-		report.changed = true
+		if _, tracked := managedSet[rel]; !tracked {
+			if _, err := a.runCommand(repoPath, "git", "add", "--", rel); err != nil {
+				report.failed = append(report.failed, fmt.Sprintf("%s: linked but git add failed: %v", rel, err))
+				continue
+			}
+			report.changed = true
+		}
 		report.succeeded = append(report.succeeded, rel)
 	}
 
-	printOperationReport(a.out, "unlink", report)
-	return nil
+	if err := a.emitOperationReport("add", report); err != nil {
+		return err
+	}
+	if report.changed {
+		return a.commitAndAskPush(ctx, repoPath, "add", len(report.succeeded))
+	}
+	return nil
+}
+
+// addDirectories tracks each directory as a unit and records it in the
+// tracked-directory manifest.
+func (a *app) addDirectories(ctx context.Context, repoPath string, paths []string) error {
+	cfg, _, err := loadCfgsConfig()
+	if err != nil {
+		return err
+	}
+	managed, err := a.loadManagedFiles(repoPath)
+	if err != nil {
+		return err
+	}
+	dirs, err := loadManagedDirs(repoPath)
+	if err != nil {
+		return err
+	}
+	ignoreMatchers, err := a.configuredIgnoreMatchers()
+	if err != nil {
+		return err
+	}
+
+	report, dirs := trackDirectories(repoPath, managed, dirs, paths, ignoreMatchers, cfg.linkStrategy(), cfg.LinkMode)
+	if report.changed {
+		if err := saveManagedDirs(repoPath, dirs); err != nil {
+			report.failed = append(report.failed, fmt.Sprintf("%s: %v", dirsManifestPath, err))
+		}
+	}
+	if err := a.emitOperationReport("add", report); err != nil {
+		return err
+	}
+	if report.changed {
+		return a.commitAndAskPush(ctx, repoPath, "add", len(report.succeeded))
+	}
+	return nil
+}
+
+func (a *app) cmdRemove(ctx context.Context, args []string) error {
+	flags := a.newFlagSet("remove")
+	a.addYesFlags(flags)
+	a.addCommitMessageFlags(flags)
+	a.addNoCommitFlag(flags)
+	flags.BoolVar(&a.jsonOutput, "json", false, "print the report as JSON")
+	flags.BoolVar(&a.quiet, "quiet", false, "print the report only when something fails")
+	stdin := flags.Bool("stdin", false, "read newline-separated paths from standard input instead of fzf")
+	all := flags.Bool("all", false, "remove every tracked file (asks for confirmation)")
+	positional, err := parseFlags(flags, args)
+	if err != nil {
+		return err
+	}
+	explicit, err := a.pathArguments(positional, *stdin)
+	if err != nil {
+		return err
+	}
+	if *all && len(explicit) > 0 {
+		return fmt.Errorf("--all cannot be combined with path arguments")
+	}
+	repoPath, err := a.resolveRepoPath()
+	if err != nil {
+		return err
+	}
+	managed, err := a.loadManagedFiles(repoPath)
+	if err != nil {
+		return err
+	}
+	dirs, err := loadManagedDirs(repoPath)
+	if err != nil {
+		return err
+	}
+	candidates := append(slices.Clone(managed), dirs...)
+	if len(candidates) == 0 && len(explicit) == 0 {
+		fmt.Fprintln(a.out, "No tracked files to remove.")
+		return nil
+	}
+
+	var selected []string
+	if *all {
+		ok, err := a.promptYesNo(fmt.Sprintf("Remove all %d tracked files and %d directories from the repository?", len(managed), len(dirs)), false)
+		if err != nil {
+			return err
+		}
+		if !ok {
+			return nil
+		}
+		selected = candidates
+	} else {
+		selected, err = a.selectFiles(candidates, explicit, "remove> ")
+		if err != nil {
+			return err
+		}
+	}
+	if len(selected) == 0 {
+		fmt.Fprintln(a.out, "No files selected.")
+		return nil
+	}
+
+	roots, err := configuredRoots()
+	if err != nil {
+		return err
+	}
+	cfg, _, err := loadCfgsConfig()
+	if err != nil {
+		return err
+	}
+
+	report := operationReport{}
+	keptDirs := slices.Clone(dirs)
+
+	for _, raw := range selected {
+		rel, err := normalizeManagedPath(raw)
+		if err != nil {
+			report.failed = append(report.failed, fmt.Sprintf("%s: invalid path", raw))
+			continue
+		}
+
+		repoFile := filepath.Join(repoPath, filepath.FromSlash(rel))
+		liveFile := liveFilePath(roots, rel)
+
+		if slices.Contains(dirs, rel) {
+			if err := removeTrackedDir(repoFile, liveFile, cfg.LinkMode); err != nil {
+				report.failed = append(report.failed, fmt.Sprintf("%s/: %v", rel, err))
+				continue
+			}
+			removeEmptyDirsUpward(repoPath, filepath.Dir(repoFile))
+			keptDirs = slices.DeleteFunc(keptDirs, func(dir string) bool { return dir == rel })
+			report.changed = true
+			report.succeeded = append(report.succeeded, rel+"/")
+			continue
+		}
+
+		if _, err := os.Stat(repoFile); err != nil {
+			report.failed = append(report.failed, fmt.Sprintf("%s: repo file missing", rel))
+			continue
+		}
+
+		if err := removeTrackedFile(repoFile, liveFile, cfg.ReadonlyRepoFiles, os.Remove); err != nil {
+			report.failed = append(report.failed, fmt.Sprintf("%s: %v", rel, err))
+			continue
+		}
+		removeEmptyDirsUpward(repoPath, filepath.Dir(repoFile))
+
+		report.changed = true
+		report.succeeded = append(report.succeeded, rel)
+	}
+	if len(keptDirs) != len(dirs) {
+		if err := saveManagedDirs(repoPath, keptDirs); err != nil {
+			report.failed = append(report.failed, fmt.Sprintf("%s: %v", dirsManifestPath, err))
+		}
+	}
+
+	if err := a.emitOperationReport("remove", report); err != nil {
+		return err
+	}
+
+	if report.changed {
+		if err := a.commitAndAskPush(ctx, repoPath, "remove", len(report.succeeded)); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// cmdRename moves a tracked file to a new repo path with git mv, so history
+// follows it, and moves the live symlink to match.
+func (a *app) cmdRename(ctx context.Context, args []string) error {
+	flags := a.newFlagSet("rename")
+	a.addYesFlags(flags)
+	a.addCommitMessageFlags(flags)
+	positional, err := parseFlags(flags, args)
+	if err != nil {
+		return err
+	}
+	if len(positional) != 2 {
+		return fmt.Errorf("rename: expected <old> <new>, got %d arguments", len(positional))
+	}
+	oldRel, err := normalizeManagedPath(positional[0])
+	if err != nil {
+		return fmt.Errorf("%s: %w", positional[0], err)
+	}
+	newRel, err := normalizeManagedPath(positional[1])
+	if err != nil {
+		return fmt.Errorf("%s: %w", positional[1], err)
+	}
+	if oldRel == newRel {
+		return fmt.Errorf("rename: %s and %s are the same path", oldRel, newRel)
+	}
+
+	repoPath, err := a.resolveRepoPath()
+	if err != nil {
+		return err
+	}
+	managed, err := a.loadManagedFiles(repoPath)
+	if err != nil {
+		return err
+	}
+	dirs, err := loadManagedDirs(repoPath)
+	if err != nil {
+		return err
+	}
+	if !slices.Contains(managed, oldRel) {
+		return fmt.Errorf("%s is not a tracked file", oldRel)
+	}
+	if _, ok := managedLiveSet(managed)[managedLiveRel(newRel)]; ok {
+		return fmt.Errorf("%s is already tracked; refusing to overwrite it", newRel)
+	}
+	if dir, ok := managedDirFor(dirs, newRel); ok {
+		return fmt.Errorf("%s is inside tracked directory %s", newRel, dir)
+	}
+
+	roots, err := configuredRoots()
+	if err != nil {
+		return err
+	}
+	cfg, _, err := loadCfgsConfig()
+	if err != nil {
+		return err
+	}
+	oldRepoFile := filepath.Join(repoPath, filepath.FromSlash(oldRel))
+	newRepoFile := filepath.Join(repoPath, filepath.FromSlash(newRel))
+	oldLiveFile := liveFilePath(roots, oldRel)
+	newLiveFile := liveFilePath(roots, newRel)
+
+	if _, err := os.Lstat(newRepoFile); err == nil {
+		return fmt.Errorf("%s already exists in the repo; refusing to overwrite it", newRel)
+	}
+	if _, err := os.Lstat(newLiveFile); err == nil {
+		return fmt.Errorf("live path for %s already exists: %s", newRel, newLiveFile)
+	}
+	oldLiveInfo, err := os.Lstat(oldLiveFile)
+	oldLinked := err == nil
+	if oldLinked {
+		ok, _ := symlinkPointsTo(oldLiveFile, oldRepoFile)
+		if oldLiveInfo.Mode()&os.ModeSymlink == 0 || !ok {
+			return fmt.Errorf("live path for %s is not linked to the repo; run cfgs doctor first", oldRel)
+		}
+	} else if !errors.Is(err, fs.ErrNotExist) {
+		return fmt.Errorf("inspect %s: %w", oldLiveFile, err)
+	}
+
+	if err := os.MkdirAll(filepath.Dir(newRepoFile), 0o755); err != nil {
+		return fmt.Errorf("create repo dir: %w", err)
+	}
+	if _, err := a.runCommand(repoPath, "git", "mv", "--", oldRel, newRel); err != nil {
+		removeEmptyDirsUpward(repoPath, filepath.Dir(newRepoFile))
+		return err
+	}
+	removeEmptyDirsUpward(repoPath, filepath.Dir(oldRepoFile))
+
+	modes, err := loadFileModes(repoPath)
+	if err != nil {
+		return err
+	}
+	if mode, ok := modes[oldRel]; ok {
+		delete(modes, oldRel)
+		modes[newRel] = mode
+		if err := saveFileModes(repoPath, modes); err != nil {
+			return fmt.Errorf("update %s: %w", modesManifestPath, err)
+		}
+	}
+
+	if oldLinked {
+		if err := os.Remove(oldLiveFile); err != nil {
+			return fmt.Errorf("remove old live symlink (repo already renamed; run cfgs doctor): %w", err)
+		}
+		oldRoot, _ := rootForManagedPath(roots, oldRel)
+		removeEmptyDirsUpward(oldRoot.base, filepath.Dir(oldLiveFile))
+	}
+	if err := os.MkdirAll(filepath.Dir(newLiveFile), 0o755); err != nil {
+		return fmt.Errorf("create live dir (repo already renamed; run cfgs doctor): %w", err)
+	}
+	if err := createSymlink(newRepoFile, newLiveFile, cfg.LinkMode); err != nil {
+		return fmt.Errorf("create symlink (repo already renamed; run cfgs doctor): %w", err)
+	}
+
+	fmt.Fprintf(a.out, "renamed %s -> %s\n", oldRel, newRel)
+	return a.commitAndAskPush(ctx, repoPath, "rename", 1)
+}
+
+// cmdExport writes every managed file, including the contents of tracked
+// directories, to a gzipped tar archive under its repo-relative path with its
+// mode. Metadata such as .cfgs/ is left out.
+func (a *app) cmdExport(ctx context.Context, args []string) error {
+	_ = ctx
+	flags := a.newFlagSet("export")
+	positional, err := parseFlags(flags, args)
+	if err != nil {
+		return err
+	}
+	if len(positional) != 1 {
+		return fmt.Errorf("export: expected <out.tar.gz>, got %d arguments", len(positional))
+	}
+
+	repoPath, err := a.resolveRepoPath()
+	if err != nil {
+		return err
+	}
+	managed, err := a.loadManagedFiles(repoPath)
+	if err != nil {
+		return err
+	}
+	dirs, err := loadManagedDirs(repoPath)
+	if err != nil {
+		return err
+	}
+	if len(dirs) > 0 {
+		tracked, err := a.gitTrackedFiles(repoPath)
+		if err != nil {
+			return err
+		}
+		for _, rel := range tracked {
+			if _, ok := managedDirFor(dirs, rel); ok && !isMetadataPath(rel) {
+				managed = append(managed, rel)
+			}
+		}
+		sort.Strings(managed)
+	}
+
+	outPath := expandPath(positional[0])
+	out, err := os.Create(outPath)
+	if err != nil {
+		return err
+	}
+	if err := writeExportArchive(out, repoPath, managed); err != nil {
+		out.Close()
+		os.Remove(outPath)
+		return err
+	}
+	if err := out.Close(); err != nil {
+		os.Remove(outPath)
+		return err
+	}
+	fmt.Fprintf(a.out, "exported %d file(s) to %s\n", len(managed), outPath)
+	return nil
+}
+
+func writeExportArchive(w io.Writer, repoPath string, files []string) error {
+	gz := gzip.NewWriter(w)
+	tw := tar.NewWriter(gz)
+	for _, rel := range files {
+		if err := addExportEntry(tw, repoPath, rel); err != nil {
+			return fmt.Errorf("export %s: %w", rel, err)
+		}
+	}
+	if err := tw.Close(); err != nil {
+		return err
+	}
+	return gz.Close()
+}
+
+func addExportEntry(tw *tar.Writer, repoPath string, rel string) error {
+	repoFile := filepath.Join(repoPath, filepath.FromSlash(rel))
+	info, err := os.Lstat(repoFile)
+	if err != nil {
+		return err
+	}
+	var link string
+	switch {
+	case info.Mode()&os.ModeSymlink != 0:
+		if link, err = os.Readlink(repoFile); err != nil {
+			return err
+		}
+	case !info.Mode().IsRegular():
+		return fmt.Errorf("not a regular file")
+	}
+	header, err := tar.FileInfoHeader(info, link)
+	if err != nil {
+		return err
+	}
+	header.Name = rel
+	if err := tw.WriteHeader(header); err != nil {
+		return err
+	}
+	if link != "" {
+		return nil
+	}
+	f, err := os.Open(repoFile)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+	_, err = io.Copy(tw, f)
+	return err
+}
+
+// cmdImport copies the files of an export archive or a plain directory into
+// a repository. With a destination directory it only unpacks there, which
+// needs no git; the result can become a repo with git init and cfgs init.
+// Without one it seeds the configured repo, which must be empty unless
+// --force is given, commits the files, and runs doctor to link them.
+func (a *app) cmdImport(ctx context.Context, args []string) error {
+	flags := a.newFlagSet("import")
+	a.addYesFlags(flags)
+	a.addCommitMessageFlags(flags)
+	force := flags.Bool("force", false, "import into a non-empty repo, overwriting files with the same path")
+	positional, err := parseFlags(flags, args)
+	if err != nil {
+		return err
+	}
+	if len(positional) == 0 || len(positional) > 2 {
+		return fmt.Errorf("import: expected <archive-or-dir> [<dir>], got %d arguments", len(positional))
+	}
+	src := expandPath(positional[0])
+
+	if len(positional) == 2 {
+		dest := expandPath(positional[1])
+		if err := ensureEmptyOrMissingDir(dest); err != nil {
+			return err
+		}
+		if err := os.MkdirAll(dest, 0o755); err != nil {
+			return err
+		}
+		count, err := importFiles(src, dest, false)
+		if err != nil {
+			return err
+		}
+		fmt.Fprintf(a.out, "imported %d file(s) into %s\n", count, dest)
+		return nil
+	}
+
+	repoPath, err := a.resolveRepoPath()
+	if err != nil {
+		return err
+	}
+	isEmpty, err := a.repoIsEmpty(repoPath)
+	if err != nil {
+		return err
+	}
+	if !isEmpty && !*force {
+		return fmt.Errorf("repository already tracks files; pass --force to import into it anyway")
+	}
+	count, err := importFiles(src, repoPath, *force)
+	if err != nil {
+		return err
+	}
+	if count == 0 {
+		fmt.Fprintln(a.out, "Nothing to import.")
+		return nil
+	}
+	fmt.Fprintf(a.out, "imported %d file(s) into %s\n", count, repoPath)
+	if err := a.commitAndAskPush(ctx, repoPath, "import", count); err != nil {
+		return err
+	}
+	return a.cmdDoctorWithRepo(ctx, repoPath, doctorOptions{})
+}
+
+// importEntry is one file read from an import source.
+type importEntry struct {
+	rel      string
+	mode     fs.FileMode
+	linkname string
+	content  io.Reader
+}
+
+// importFiles copies every entry of src, an export archive or a directory,
+// below dest and returns how many it wrote. Existing files are only replaced
+// with overwrite.
+func importFiles(src string, dest string, overwrite bool) (int, error) {
+	info, err := os.Stat(src)
+	if err != nil {
+		return 0, err
+	}
+	count := 0
+	write := func(entry importEntry) error {
+		if err := writeImportEntry(dest, entry, overwrite); err != nil {
+			return fmt.Errorf("import %s: %w", entry.rel, err)
+		}
+		count++
+		return nil
+	}
+	if info.IsDir() {
+		err = readImportDir(src, write)
+	} else {
+		err = readImportArchive(src, write)
+	}
+	return count, err
+}
+
+// importPath checks that name is a clean relative path that may be tracked.
+// It reports false for cfgs and git metadata, which imports skip.
+func importPath(name string) (string, bool, error) {
+	if isMetadataPath(path.Clean(name)) {
+		return "", false, nil
+	}
+	rel, err := normalizeManagedPath(name)
+	if err != nil || rel != name {
+		return "", false, fmt.Errorf("entry %q is not a clean relative path", name)
+	}
+	return rel, !isMetadataPath(rel), nil
+}
+
+func readImportArchive(src string, fn func(importEntry) error) error {
+	in, err := os.Open(src)
+	if err != nil {
+		return err
+	}
+	defer in.Close()
+	gz, err := gzip.NewReader(in)
+	if err != nil {
+		return fmt.Errorf("read archive: %w", err)
+	}
+	defer gz.Close()
+	tr := tar.NewReader(gz)
+	for {
+		header, err := tr.Next()
+		if errors.Is(err, io.EOF) {
+			return nil
+		}
+		if err != nil {
+			return fmt.Errorf("read archive: %w", err)
+		}
+		if header.Typeflag == tar.TypeDir {
+			continue
+		}
+		rel, ok, err := importPath(strings.TrimSuffix(header.Name, "/"))
+		if err != nil {
+			return err
+		}
+		if !ok {
+			continue
+		}
+		entry := importEntry{rel: rel, mode: header.FileInfo().Mode().Perm(), content: tr}
+		switch header.Typeflag {
+		case tar.TypeReg:
+		case tar.TypeSymlink:
+			entry.linkname = header.Linkname
+		default:
+			return fmt.Errorf("archive entry %q has unsupported type %q", header.Name, header.Typeflag)
+		}
+		if err := fn(entry); err != nil {
+			return err
+		}
+	}
+}
+
+func readImportDir(src string, fn func(importEntry) error) error {
+	return filepath.WalkDir(src, func(fullPath string, d fs.DirEntry, walkErr error) error {
+		if walkErr != nil {
+			return walkErr
+		}
+		rel, err := filepath.Rel(src, fullPath)
+		if err != nil || rel == "." {
+			return err
+		}
+		rel, ok, err := importPath(filepath.ToSlash(rel))
+		if err != nil {
+			return err
+		}
+		if !ok {
+			if d.IsDir() {
+				return filepath.SkipDir
+			}
+			return nil
+		}
+		if d.IsDir() {
+			return nil
+		}
+		info, err := d.Info()
+		if err != nil {
+			return err
+		}
+		entry := importEntry{rel: rel, mode: info.Mode().Perm()}
+		switch {
+		case info.Mode()&os.ModeSymlink != 0:
+			if entry.linkname, err = os.Readlink(fullPath); err != nil {
+				return err
+			}
+			return fn(entry)
+		case !info.Mode().IsRegular():
+			return nil
+		}
+		f, err := os.Open(fullPath)
+		if err != nil {
+			return err
+		}
+		defer f.Close()
+		entry.content = f
+		return fn(entry)
+	})
+}
+
+// writeImportEntry writes entry below dest. It refuses to write through a
+// parent that is a symlink, such as one an earlier entry of the same archive
+// created, and symlink entries that point outside dest, so an import can
+// never create, replace or delete files elsewhere.
+func writeImportEntry(dest string, entry importEntry, overwrite bool) error {
+	if entry.linkname != "" {
+		if filepath.IsAbs(entry.linkname) {
+			return fmt.Errorf("symlink target %q is absolute", entry.linkname)
+		}
+		resolved := path.Clean(path.Join(path.Dir(entry.rel), filepath.ToSlash(entry.linkname)))
+		if resolved == ".." || strings.HasPrefix(resolved, "../") {
+			return fmt.Errorf("symlink target %q leaves the destination", entry.linkname)
+		}
+	}
+	if err := checkImportParents(dest, entry.rel); err != nil {
+		return err
+	}
+	target := filepath.Join(dest, filepath.FromSlash(entry.rel))
+	if err := os.MkdirAll(filepath.Dir(target), 0o755); err != nil {
+		return err
+	}
+	if _, err := os.Lstat(target); err == nil {
+		if !overwrite {
+			return fmt.Errorf("%s already exists", target)
+		}
+		if err := os.Remove(target); err != nil {
+			return err
+		}
+	}
+	if entry.linkname != "" {
+		return os.Symlink(entry.linkname, target)
+	}
+	f, err := os.OpenFile(target, os.O_WRONLY|os.O_CREATE|os.O_EXCL, entry.mode)
+	if err != nil {
+		return err
+	}
+	if _, err := io.Copy(f, entry.content); err != nil {
+		f.Close()
+		return err
+	}
+	return f.Close()
+}
+
+// checkImportParents fails when a directory between dest and rel already
+// exists as a symlink or as something other than a directory.
+func checkImportParents(dest string, rel string) error {
+	dir := dest
+	parts := strings.Split(rel, "/")
+	for _, part := range parts[:len(parts)-1] {
+		dir = filepath.Join(dir, part)
+		info, err := os.Lstat(dir)
+		if errors.Is(err, fs.ErrNotExist) {
+			return nil
+		}
+		if err != nil {
+			return err
+		}
+		if info.Mode()&os.ModeSymlink != 0 {
+			return fmt.Errorf("parent %s is a symlink", dir)
+		}
+		if !info.IsDir() {
+			return fmt.Errorf("parent %s is not a directory", dir)
+		}
+	}
+	return nil
+}
+
+// cmdMoveRepo relocates the repository, or adopts a repository the user
+// already moved, then points repo_path and every managed symlink at the new
+// location.
+func (a *app) cmdMoveRepo(ctx context.Context, args []string) error {
+	_ = ctx
+	flags := a.newFlagSet("move-repo")
+	flags.BoolVar(&a.jsonOutput, "json", false, "print the report as JSON")
+	flags.BoolVar(&a.quiet, "quiet", false, "print nothing unless links need manual reconcile")
+	positional, err := parseFlags(flags, args)
+	if err != nil {
+		return err
+	}
+	if len(positional) != 1 {
+		return fmt.Errorf("move-repo: expected <newpath>, got %d arguments", len(positional))
+	}
+
+	cfg, ok, err := loadCfgsConfig()
+	if err != nil {
+		return err
+	}
+	if !ok || strings.TrimSpace(cfg.RepoPath) == "" {
+		return fmt.Errorf("no repo_path configured; run cfgs init first")
+	}
+	oldRepo, err := filepath.Abs(expandPath(cfg.RepoPath))
+	if err != nil {
+		return err
+	}
+	newRepo, err := filepath.Abs(expandPath(positional[0]))
+	if err != nil {
+		return err
+	}
+	if oldRepo == newRepo {
+		return fmt.Errorf("move-repo: repository is already at %s", oldRepo)
+	}
+
+	if _, err := os.Stat(oldRepo); err == nil {
+		if err := ensureEmptyOrMissingDir(newRepo); err != nil {
+			return err
+		}
+		if err := os.MkdirAll(filepath.Dir(newRepo), 0o755); err != nil {
+			return fmt.Errorf("create parent directory: %w", err)
+		}
+		if err := os.Rename(oldRepo, newRepo); err != nil {
+			return fmt.Errorf("move repository (move it by hand and rerun cfgs move-repo): %w", err)
+		}
+		fmt.Fprintf(a.out, "moved %s -> %s\n", oldRepo, newRepo)
+	} else if !errors.Is(err, fs.ErrNotExist) {
+		return fmt.Errorf("inspect %s: %w", oldRepo, err)
+	} else {
+		fmt.Fprintf(a.out, "%s no longer exists; assuming the repository was already moved to %s\n", oldRepo, newRepo)
+	}
+
+	newRepo, err = a.validateAndNormalizeRepo(newRepo)
+	if err != nil {
+		return err
+	}
+	cfg.RepoPath = newRepo
+	if err := saveCfgsConfig(cfg); err != nil {
+		return err
+	}
+
+	managed, err := a.loadManagedFiles(newRepo)
+	if err != nil {
+		return err
+	}
+	dirs, err := loadManagedDirs(newRepo)
+	if err != nil {
+		return err
+	}
+	roots, err := configuredRoots()
+	if err != nil {
+		return err
+	}
+	report := relinkMovedRepo(oldRepo, newRepo, roots, managed, dirs)
+	return a.finishDoctorReport(newRepo, report, doctorOptions{})
+}
+
+// relinkMovedRepo repoints every live symlink of a managed file or directory
+// that targets its old location under oldRepo, keeping the link's absolute or
+// relative style. Links already pointing into newRepo are left alone; links
+// that point anywhere else need manual reconcile.
+func relinkMovedRepo(oldRepo string, newRepo string, roots []managedRoot, managed []string, dirs []string) doctorReport {
+	report := doctorReport{}
+	relink := func(rel string, label string) {
+		liveFile := liveFilePath(roots, rel)
+		oldTarget := filepath.Join(filepath.Clean(oldRepo), filepath.FromSlash(rel))
+		newTarget := filepath.Join(newRepo, filepath.FromSlash(rel))
+
+		liveInfo, err := os.Lstat(liveFile)
+		if errors.Is(err, fs.ErrNotExist) {
+			report.skipped = append(report.skipped, label+" (live path missing; run cfgs doctor)")
+			return
+		}
+		if err != nil {
+			report.requireManualResolve = append(report.requireManualResolve, manualResolve{path: label, reason: fmt.Sprintf("inspect live path: %v", err)})
+			return
+		}
+		if liveInfo.Mode()&os.ModeSymlink == 0 {
+			report.didNotTouch = append(report.didNotTouch, label)
+			return
+		}
+		if ok, err := symlinkPointsTo(liveFile, newTarget); err == nil && ok {
+			report.didNotTouch = append(report.didNotTouch, label)
+			return
+		}
+		// The old target is gone, so the link can only be matched by where
+		// it says it points, not by resolving it.
+		target, inRepo, err := symlinkRepoTarget(liveFile, oldRepo)
+		if err != nil || !inRepo || target != oldTarget {
+			report.requireManualResolve = append(report.requireManualResolve, manualResolve{path: label, reason: "live symlink does not point to the old repo location"})
+			return
+		}
+		style := symlinkStyle(liveFile)
+		if err := os.Remove(liveFile); err != nil {
+			report.requireManualResolve = append(report.requireManualResolve, manualResolve{path: label, reason: fmt.Sprintf("remove old symlink: %v", err)})
+			return
+		}
+		if err := createSymlink(newTarget, liveFile, style); err != nil {
+			report.requireManualResolve = append(report.requireManualResolve, manualResolve{path: label, reason: fmt.Sprintf("create symlink: %v", err)})
+			return
+		}
+		report.replacedWithSymlink = append(report.replacedWithSymlink, label+" (repointed)")
+	}
+	for _, rel := range managed {
+		relink(rel, rel)
+	}
+	for _, rel := range dirs {
+		relink(rel, rel+"/")
+	}
+	return report
+}
+
+func (a *app) cmdDoctor(ctx context.Context, args []string) error {
+	flags := a.newFlagSet("doctor")
+	a.addYesFlags(flags)
+	var exclude stringListFlag
+	flags.Var(&exclude, "exclude", "skip managed paths matching `glob` for this run (repeatable)")
+	repoWins := flags.Bool("repo-wins", false, "overwrite diverged live files with the repo version")
+	force := flags.Bool("force", false, "with --repo-wins, overwrite newer live files without asking")
+	linkRelative := flags.Bool("link-relative", false, "recreate every link as a relative symlink")
+	linkAbsolute := flags.Bool("link-absolute", false, "recreate every link as an absolute symlink")
+	commitEmpty := flags.Bool("commit-empty", false, "record a clean reconciliation as an empty commit")
+	summaryOnly := flags.Bool("summary-only", false, "print counts instead of per-file lists, except for manual reconcile")
+	assumeLinked := flags.Bool("assume-linked", false, "trust existing live symlinks without resolving them (faster, may miss mispointed links)")
+	fixDanglingOnly := flags.Bool("fix-dangling-only", false, "only remove symlinks whose repo target no longer exists")
+	flags.BoolVar(&a.jsonOutput, "json", false, "print the report as JSON")
+	flags.BoolVar(&a.quiet, "quiet", false, "print nothing unless files need manual reconcile")
+	reportFile := flags.String("report-file", "", "write the report to `path` instead of stdout")
+	keepGoing := flags.Bool("keep-going", false, "exit 0 even when files need manual reconcile")
+	managedFileList := flags.String("managed-file-list", "", "only reconcile the newline-separated managed paths listed in `file`")
+	incremental := flags.Bool("incremental", false, "skip files unchanged since the last verified run")
+	showDiff := flags.Bool("diff", false, "show a diff for each live file that differs from the repo")
+	backup := flags.Bool("backup", false, "keep each replaced live file as <name>"+liveBackupSuffix+" instead of deleting it")
+	fixPerms := flags.Bool("fix-perms", false, "chmod repo files to the modes from add --mode and file_modes before linking")
+	prune := flags.Bool("prune", false, "remove live symlinks of tracked entries whose repo file was deleted")
+	interactive := flags.Bool("interactive", false, "ask how to resolve each diverged live file: keep live, keep repo, skip, or diff")
+	if err := parseNoPositional(flags, args); err != nil {
+		return err
+	}
+	if *interactive && (a.jsonOutput || *repoWins) {
+		return fmt.Errorf("--interactive cannot be combined with --json or --repo-wins")
+	}
+	if *linkRelative && *linkAbsolute {
+		return fmt.Errorf("--link-relative and --link-absolute are mutually exclusive")
+	}
+	if *managedFileList != "" && *fixDanglingOnly {
+		return fmt.Errorf("--managed-file-list and --fix-dangling-only are mutually exclusive")
+	}
+
+	opts := doctorOptions{
+		repoWins:        *repoWins,
+		force:           *force,
+		commitEmpty:     *commitEmpty,
+		summaryOnly:     *summaryOnly,
+		assumeLinked:    *assumeLinked,
+		fixDanglingOnly: *fixDanglingOnly,
+		reportFile:      *reportFile,
+		keepGoing:       *keepGoing,
+		incremental:     *incremental,
+		showDiff:        *showDiff,
+		backup:          *backup,
+		fixPerms:        *fixPerms,
+		prune:           *prune,
+		interactive:     *interactive,
+	}
+	switch {
+	case *linkRelative:
+		opts.linkMode = linkModeRelative
+	case *linkAbsolute:
+		opts.linkMode = linkModeAbsolute
+	}
+	if len(exclude) > 0 {
+		matchers, err := compileGlobMatchers(exclude)
+		if err != nil {
+			return err
+		}
+		opts.exclude = matchers
+	}
+	if *managedFileList != "" {
+		paths, err := readManagedFileList(*managedFileList)
+		if err != nil {
+			return err
+		}
+		opts.managedFileList = paths
+	}
+
+	repoPath, err := a.resolveRepoPath()
+	if err != nil {
+		return err
+	}
+	return a.cmdDoctorWithRepo(ctx, repoPath, opts)
+}
+
+func (a *app) cmdDoctorWithRepo(ctx context.Context, repoPath string, opts doctorOptions) error {
+	_ = ctx
+	if opts.fixDanglingOnly {
+		return a.fixDanglingSymlinks(repoPath, opts)
+	}
+
+	managed, err := a.loadManagedFiles(repoPath)
+	if err != nil {
+		return err
+	}
+	dirs, err := loadManagedDirs(repoPath)
+	if err != nil {
+		return err
+	}
+	if opts.managedFileList != nil {
+		managed, err = restrictManagedFiles(managed, opts.managedFileList)
+		if err != nil {
+			return err
+		}
+		dirs = nil
+	}
+	if len(managed) == 0 && len(dirs) == 0 {
+		fmt.Fprintln(a.out, "No tracked files found.")
+		return nil
+	}
+
+	roots, err := configuredRoots()
+	if err != nil {
+		return err
+	}
+
+	cfg, _, err := loadCfgsConfig()
+	if err != nil {
+		return err
+	}
+	linkMode := cfg.LinkMode
+	if opts.linkMode != "" {
+		linkMode = opts.linkMode
+	}
+	filter, err := newHostFilter(cfg)
+	if err != nil {
+		return err
+	}
+	classifier, err := a.newFileClassifier(repoPath, cfg)
+	if err != nil {
+		return err
+	}
+	secrets, strategy, placed := classifier.secrets, classifier.strategy, classifier.placed
+
+	report := doctorReport{}
+	if opts.fixPerms {
+		fixed, problems, err := fixRepoFilePerms(repoPath, managed, cfg.FileModes)
+		if err != nil {
+			return err
+		}
+		report.fixedPerms = fixed
+		report.requireManualResolve = append(report.requireManualResolve, problems...)
+	}
+
+	var previous map[string]linkStateEntry
+	if opts.incremental && opts.linkMode == "" {
+		previous = loadLinkStateManifest(repoPath, linkMode)
+	}
+
+	managedSet := sliceToSet(managed)
+
+	for _, rel := range managed {
+		if reason := filter.skip(rel); reason != "" {
+			a.tracef("%s: skip, %s", rel, reason)
+			report.skipped = append(report.skipped, rel+" ("+reason+")")
+			continue
+		}
+		if shouldIgnorePath(rel, false, opts.exclude) {
+			a.tracef("%s: skip, matches --exclude", rel)
+			report.skipped = append(report.skipped, rel+" (excluded by --exclude)")
+			continue
+		}
+
+		repoFile := filepath.Join(repoPath, filepath.FromSlash(rel))
+		liveFile := liveFilePath(roots, rel)
+
+		if entry, ok := previous[rel]; ok {
+			if current, ok := currentLinkState(repoFile, liveFile); ok && current == entry {
+				a.tracef("%s: unchanged since the last verified run", rel)
+				report.didNotTouch = append(report.didNotTouch, rel)
+				continue
+			}
+		}
+
+		repoInfo, err := os.Stat(repoFile)
+		a.tracef("%s: stat %s: %s", rel, repoFile, describeStat(repoInfo, err))
+		if opts.prune && errors.Is(err, fs.ErrNotExist) {
+			pruned, err := pruneVanishedRepoLink(liveFile, repoPath)
+			switch {
+			case err != nil:
+				report.requireManualResolve = append(report.requireManualResolve, manualResolve{path: rel, reason: fmt.Sprintf("repo file is missing; remove live symlink: %v", err)})
+				continue
+			case pruned:
+				a.tracef("%s: repo file is gone, removed %s", rel, liveFile)
+				report.unlinkedOrphanSymlink = append(report.unlinkedOrphanSymlink, rel+" (pruned, repo file was deleted)")
+				continue
+			}
+		}
+		if err != nil || !repoInfo.Mode().IsRegular() {
+			report.requireManualResolve = append(report.requireManualResolve, manualResolve{path: rel, reason: "repo file is missing or not a regular file"})
+			continue
+		}
+
+		if secrets.isSecret(rel) {
+			a.tracef("%s: secret, decrypting to %s", rel, liveFile)
+			note, err := secrets.materialize(repoFile, liveFile, opts.repoWins)
+			switch {
+			case err != nil:
+				report.requireManualResolve = append(report.requireManualResolve, manualResolve{path: rel, reason: err.Error()})
+			case note == "":
+				report.didNotTouch = append(report.didNotTouch, rel)
+			default:
+				report.replacedWithSymlink = append(report.replacedWithSymlink, rel+note)
+			}
+			continue
+		}
+
+		if strategy != linkStrategySymlink {
+			a.tracef("%s: %s strategy, checking %s", rel, strategy, liveFile)
+			note, err := reconcilePlacedFile(repoFile, liveFile, rel, strategy, placed, opts.repoWins)
+			switch {
+			case err != nil:
+				report.requireManualResolve = append(report.requireManualResolve, manualResolve{path: rel, reason: err.Error()})
+			case note == "":
+				report.didNotTouch = append(report.didNotTouch, rel)
+			default:
+				report.replacedWithSymlink = append(report.replacedWithSymlink, rel+note)
+			}
+			continue
+		}
+
+		liveInfo, err := os.Lstat(liveFile)
+		a.tracef("%s: lstat %s: %s", rel, liveFile, describeStat(liveInfo, err))
+		if err != nil {
+			if !errors.Is(err, fs.ErrNotExist) {
+				report.requireManualResolve = append(report.requireManualResolve, manualResolve{path: rel, reason: fmt.Sprintf("inspect live path: %v", err)})
+				continue
+			}
+			if err := os.MkdirAll(filepath.Dir(liveFile), 0o755); err != nil {
+				report.requireManualResolve = append(report.requireManualResolve, manualResolve{path: rel, reason: fmt.Sprintf("create parent directory: %v", err)})
+				continue
+			}
+			a.tracef("%s: live path missing, symlink %s -> %s", rel, liveFile, repoFile)
+			if err := createSymlink(repoFile, liveFile, linkMode); err != nil {
+				report.requireManualResolve = append(report.requireManualResolve, manualResolve{path: rel, reason: fmt.Sprintf("create symlink: %v", err)})
+				continue
+			}
+			report.replacedWithSymlink = append(report.replacedWithSymlink, rel)
+			continue
+		}
+
+		if liveInfo.Mode()&os.ModeSymlink != 0 && opts.assumeLinked && opts.linkMode == "" {
+			a.tracef("%s: live path is a symlink, trusted by --assume-linked", rel)
+			report.didNotTouch = append(report.didNotTouch, rel)
+			continue
+		}
+		if liveInfo.Mode()&os.ModeSymlink != 0 {
+			ok, err := symlinkPointsTo(liveFile, repoFile)
+			if err != nil {
+				a.tracef("%s: resolve symlink %s: %v", rel, liveFile, err)
+			} else {
+				a.tracef("%s: symlink %s points to repo file: %t", rel, liveFile, ok)
+			}
+			if (err != nil || !ok) && pointsToRepoAlias(liveFile, repoPath, rel) {
+				// The link targets the shared copy this host overlay or
+				// variant now overrides, or vice versa; repoint it.
+				a.tracef("%s: symlink targets a shadowed repo path, remove and relink %s", rel, liveFile)
+				if err := os.Remove(liveFile); err != nil {
+					report.requireManualResolve = append(report.requireManualResolve, manualResolve{path: rel, reason: fmt.Sprintf("remove symlink to shadowed path: %v", err)})
+					continue
+				}
+				if err := createSymlink(repoFile, liveFile, linkMode); err != nil {
+					report.requireManualResolve = append(report.requireManualResolve, manualResolve{path: rel, reason: fmt.Sprintf("create symlink: %v", err)})
+					continue
+				}
+				report.replacedWithSymlink = append(report.replacedWithSymlink, rel+" (repointed from the path it shadows)")
+				continue
+			}
+			if err != nil || !ok {
+				report.requireManualResolve = append(report.requireManualResolve, manualResolve{path: rel, reason: "live symlink does not point to the repo file"})
+				continue
+			}
+			if opts.linkMode == "" || symlinkStyle(liveFile) == opts.linkMode {
+				report.didNotTouch = append(report.didNotTouch, rel)
+				continue
+			}
+			a.tracef("%s: symlink style is not %s, remove and relink %s", rel, opts.linkMode, liveFile)
+			if err := os.Remove(liveFile); err != nil {
+				report.requireManualResolve = append(report.requireManualResolve, manualResolve{path: rel, reason: fmt.Sprintf("remove symlink before relinking: %v", err)})
+				continue
+			}
+			if err := createSymlink(repoFile, liveFile, opts.linkMode); err != nil {
+				report.requireManualResolve = append(report.requireManualResolve, manualResolve{path: rel, reason: fmt.Sprintf("create symlink: %v", err)})
+				continue
+			}
+			report.replacedWithSymlink = append(report.replacedWithSymlink, rel+" (relinked as "+opts.linkMode+")")
+			continue
+		}
+
+		if !liveInfo.Mode().IsRegular() {
+			report.requireManualResolve = append(report.requireManualResolve, manualResolve{path: rel, reason: "live path is neither a regular file nor a symlink"})
+			continue
+		}
+
+		same, err := filesEqual(repoFile, liveFile)
+		if err != nil {
+			report.requireManualResolve = append(report.requireManualResolve, manualResolve{path: rel, reason: fmt.Sprintf("compare with repo: %v", err)})
+			continue
+		}
+		a.tracef("%s: live path is a regular file, content matches repo: %t", rel, same)
+		note := ""
+		if !same && opts.interactive {
+			choice, err := a.promptConflict(rel, repoFile, liveFile)
+			if err != nil {
+				return err
+			}
+			switch choice {
+			case conflictKeepLive:
+				if err := a.adoptLiveFile(repoPath, rel, repoFile, liveFile, cfg.ReadonlyRepoFiles, secrets); err != nil {
+					report.requireManualResolve = append(report.requireManualResolve, manualResolve{path: rel, reason: fmt.Sprintf("adopt live file: %v", err)})
+					continue
+				}
+				note = " (adopted live file into the repo)"
+			case conflictKeepRepo:
+				note = " (overwrote diverged live file)"
+			default:
+				report.requireManualResolve = append(report.requireManualResolve, manualResolve{path: rel, reason: reasonLiveDiffers})
+				continue
+			}
+		} else if !same {
+			if !opts.repoWins {
+				report.requireManualResolve = append(report.requireManualResolve, manualResolve{path: rel, reason: reasonLiveDiffers})
+				continue
+			}
+			overwrite, err := a.confirmRepoWins(rel, repoInfo, liveInfo, opts.force)
+			if err != nil {
+				return err
+			}
+			if !overwrite {
+				report.requireManualResolve = append(report.requireManualResolve, manualResolve{path: rel, reason: reasonLiveDiffers})
+				continue
+			}
+			note = " (overwrote diverged live file)"
+		}
+
+		if opts.backup {
+			backupFile := liveFile + liveBackupSuffix
+			if _, err := os.Lstat(backupFile); err == nil {
+				report.requireManualResolve = append(report.requireManualResolve, manualResolve{path: rel, reason: fmt.Sprintf("backup %s already exists", filepath.Base(backupFile))})
+				continue
+			}
+			a.tracef("%s: rename %s -> %s", rel, liveFile, backupFile)
+			if err := os.Rename(liveFile, backupFile); err != nil {
+				report.requireManualResolve = append(report.requireManualResolve, manualResolve{path: rel, reason: fmt.Sprintf("back up live copy: %v", err)})
+				continue
+			}
+			note += fmt.Sprintf(" (backed up to %s)", filepath.Base(backupFile))
+		} else {
+			a.tracef("%s: remove live copy %s", rel, liveFile)
+			if err := os.Remove(liveFile); err != nil {
+				report.requireManualResolve = append(report.requireManualResolve, manualResolve{path: rel, reason: fmt.Sprintf("remove live copy: %v", err)})
+				continue
+			}
+		}
+		a.tracef("%s: symlink %s -> %s", rel, liveFile, repoFile)
+		if err := createSymlink(repoFile, liveFile, linkMode); err != nil {
+			report.requireManualResolve = append(report.requireManualResolve, manualResolve{path: rel, reason: fmt.Sprintf("create symlink: %v", err)})
+			continue
+		}
+		report.replacedWithSymlink = append(report.replacedWithSymlink, rel+note)
+	}
+
+	var linkDirs []string
+	for _, rel := range dirs {
+		if filter.isExcluded(rel) {
+			report.skipped = append(report.skipped, rel+"/ (excluded on this machine)")
+			continue
+		}
+		if shouldIgnorePath(rel, true, opts.exclude) {
+			report.skipped = append(report.skipped, rel+"/ (excluded by --exclude)")
+			continue
+		}
+		linkDirs = append(linkDirs, rel)
+	}
+	dirReport := reconcileManagedDirs(repoPath, roots, linkDirs, linkMode, opts.linkMode)
+	report.didNotTouch = append(report.didNotTouch, dirReport.didNotTouch...)
+	report.replacedWithSymlink = append(report.replacedWithSymlink, dirReport.replacedWithSymlink...)
+	report.requireManualResolve = append(report.requireManualResolve, dirReport.requireManualResolve...)
+	for _, rel := range dirs {
+		managedSet[rel] = struct{}{}
+	}
+
+	if opts.managedFileList == nil {
+		ignoreMatchers, err := a.configuredIgnoreMatchers()
+		if err != nil {
+			return err
+		}
+		ignoreMatchers = append(ignoreMatchers, opts.exclude...)
+		a.excludeNestedRepo(roots, repoPath, !a.quiet && !a.jsonOutput)
+		orphanReport, err := reconcileOrphanRepoSymlinks(repoPath, roots, managedSet, ignoreMatchers, false)
+		if err != nil {
+			return err
+		}
+		report.unlinkedOrphanSymlink = append(report.unlinkedOrphanSymlink, orphanReport.unlinkedOrphanSymlink...)
+		report.requireManualResolve = append(report.requireManualResolve, orphanReport.requireManualResolve...)
+	}
+
+	if placed != nil {
+		if err := savePlacedFiles(repoPath, placed); err != nil {
+			fmt.Fprintf(a.errOut, "warning: could not save the %s manifest: %v\n", strategy, err)
+		}
+	}
+
+	if opts.incremental {
+		if err := saveLinkStateManifest(repoPath, roots, managed, linkMode, previous); err != nil {
+			fmt.Fprintf(a.errOut, "warning: could not save incremental state: %v\n", err)
+		}
+	}
+
+	if err := a.finishDoctorReport(repoPath, report, opts); err != nil {
+		return err
+	}
+	if len(report.requireManualResolve) > 0 {
+		return nil
+	}
+	if opts.commitEmpty {
+		return a.commitEmptyMarker(repoPath, "doctor")
+	}
+	return nil
+}
+
+// placedFilesManifest records, for the hardlink and copy strategies, the
+// content hash of each live file as doctor or add last placed it. A live file
+// still matching its hash is a stale copy that may be refreshed; one that
+// does not was edited locally.
+type placedFilesManifest struct {
+	RepoPath string            `json:"repo_path"`
+	Files    map[string]string `json:"files"`
+}
+
+func placedFilesManifestPath() (string, error) {
+	configPath, err := cfgsConfigPath()
+	if err != nil {
+		return "", err
+	}
+	return filepath.Join(filepath.Dir(configPath), "placed-files.json"), nil
+}
+
+// loadPlacedFiles returns the recorded hashes for repoPath; a missing,
+// unreadable, or foreign manifest yields an empty map.
+func loadPlacedFiles(repoPath string) map[string]string {
+	files := map[string]string{}
+	manifestPath, err := placedFilesManifestPath()
+	if err != nil {
+		return files
+	}
+	data, err := os.ReadFile(manifestPath)
+	if err != nil {
+		return files
+	}
+	var manifest placedFilesManifest
+	if err := json.Unmarshal(data, &manifest); err != nil || manifest.RepoPath != repoPath {
+		return files
+	}
+	maps.Copy(files, manifest.Files)
+	return files
+}
+
+func savePlacedFiles(repoPath string, files map[string]string) error {
+	manifestPath, err := placedFilesManifestPath()
+	if err != nil {
+		return err
+	}
+	if err := os.MkdirAll(filepath.Dir(manifestPath), 0o755); err != nil {
+		return err
+	}
+	data, err := json.Marshal(placedFilesManifest{RepoPath: repoPath, Files: files})
+	if err != nil {
+		return err
+	}
+	return os.WriteFile(manifestPath, append(data, '\n'), 0o644)
+}
+
+// recordPlacedFile stores the hash of the file just placed for rel.
+func recordPlacedFile(repoPath string, rel string, repoFile string) error {
+	sum, err := fileSHA256(repoFile)
+	if err != nil {
+		return err
+	}
+	files := loadPlacedFiles(repoPath)
+	files[rel] = sum
+	return savePlacedFiles(repoPath, files)
+}
+
+func fileSHA256(name string) (string, error) {
+	f, err := os.Open(name)
+	if err != nil {
+		return "", err
+	}
+	defer f.Close()
+	h := sha256.New()
+	if _, err := io.Copy(h, f); err != nil {
+		return "", err
+	}
+	return hex.EncodeToString(h.Sum(nil)), nil
+}
+
+// placeLiveFile puts repoFile at liveFile as a hardlink or, for the copy
+// strategy, a copy that keeps the repo file's mode and times.
+func placeLiveFile(repoFile string, liveFile string, strategy string) error {
+	if err := os.MkdirAll(filepath.Dir(liveFile), 0o755); err != nil {
+		return err
+	}
+	if strategy == linkStrategyHardlink {
+		return os.Link(repoFile, liveFile)
+	}
+	return copyFileWith(repoFile, liveFile, copyFileOptions{preserveTimes: true})
+}
+
+// linkLiveFile puts repoFile at a missing liveFile the way strategy asks: a
+// symlink in linkMode, or a hardlink or copy recorded in the placed-files
+// manifest so doctor can later tell it from a local edit.
+func linkLiveFile(repoPath string, rel string, repoFile string, liveFile string, strategy string, linkMode string) error {
+	if strategy == "" || strategy == linkStrategySymlink {
+		if err := os.MkdirAll(filepath.Dir(liveFile), 0o755); err != nil {
+			return err
+		}
+		return createSymlink(repoFile, liveFile, linkMode)
+	}
+	if err := placeLiveFile(repoFile, liveFile, strategy); err != nil {
+		return err
+	}
+	if err := recordPlacedFile(repoPath, rel, repoFile); err != nil {
+		return fmt.Errorf("record in %s manifest: %v", strategy, err)
+	}
+	return nil
+}
+
+// reconcilePlacedFile is doctor's counterpart of the symlink checks for the
+// hardlink and copy strategies. It returns an empty note when liveFile is
+// already current, and updates placed for every file it (re)places. A live
+// file that differs from the repo is refreshed only when it still matches
+// what cfgs last placed, or when overwrite is set.
+func reconcilePlacedFile(repoFile string, liveFile string, rel string, strategy string, placed map[string]string, overwrite bool) (string, error) {
+	repoSum, err := fileSHA256(repoFile)
+	if err != nil {
+		return "", fmt.Errorf("hash repo file: %v", err)
+	}
+	note := " (" + strategy + ")"
+
+	liveInfo, err := os.Lstat(liveFile)
+	switch {
+	case errors.Is(err, fs.ErrNotExist):
+	case err != nil:
+		return "", fmt.Errorf("inspect live path: %v", err)
+	case liveInfo.Mode()&os.ModeSymlink != 0:
+		if ok, err := symlinkPointsTo(liveFile, repoFile); err != nil || !ok {
+			return "", fmt.Errorf("live symlink does not point to the repo file")
+		}
+		note = " (replaced symlink with " + strategy + ")"
+	case !liveInfo.Mode().IsRegular():
+		return "", fmt.Errorf("live path is neither a regular file nor a symlink")
+	default:
+		if strategy == linkStrategyHardlink {
+			if repoInfo, err := os.Stat(repoFile); err == nil && os.SameFile(repoInfo, liveInfo) {
+				placed[rel] = repoSum
+				return "", nil
+			}
+		}
+		liveSum, err := fileSHA256(liveFile)
+		if err != nil {
+			return "", fmt.Errorf("hash live file: %v", err)
+		}
+		if liveSum == repoSum && strategy == linkStrategyCopy {
+			placed[rel] = repoSum
+			return "", nil
+		}
+		switch {
+		case liveSum == repoSum || liveSum == placed[rel]:
+			note = " (refreshed " + strategy + ")"
+		case overwrite:
+			note = " (overwrote diverged live file)"
+		default:
+			return "", errors.New(reasonLiveDiffers)
+		}
+	}
+
+	if err == nil {
+		if err := os.Remove(liveFile); err != nil {
+			return "", fmt.Errorf("remove live path: %v", err)
+		}
+	}
+	if err := placeLiveFile(repoFile, liveFile, strategy); err != nil {
+		return "", fmt.Errorf("place %s: %v", strategy, err)
+	}
+	placed[rel] = repoSum
+	return note, nil
+}
+
+// linkStateManifestPath is machine state, so it stays under
+// $XDG_CONFIG_HOME/cfgs even when CFGS_CONFIG points the config elsewhere.
+func linkStateManifestPath() (string, error) {
+	xdg, err := xdgConfigHome()
+	if err != nil {
+		return "", err
+	}
+	return filepath.Join(xdg, "cfgs", "doctor-state.json"), nil
+}
+
+// loadLinkStateManifest returns the recorded link states, or nil when the
+// manifest is missing, unreadable, or was written for a different repo or
+// link mode, so that doctor falls back to a full run.
+func loadLinkStateManifest(repoPath string, linkMode string) map[string]linkStateEntry {
+	manifestPath, err := linkStateManifestPath()
+	if err != nil {
+		return nil
+	}
+	data, err := os.ReadFile(manifestPath)
+	if err != nil {
+		return nil
+	}
+	var manifest linkStateManifest
+	if err := json.Unmarshal(data, &manifest); err != nil {
+		return nil
+	}
+	if manifest.RepoPath != repoPath || manifest.LinkMode != linkMode {
+		return nil
+	}
+	return manifest.Files
+}
+
+// saveLinkStateManifest records every managed file that is now correctly
+// linked. Entries that still match previous are carried over without
+// re-resolving the link.
+func saveLinkStateManifest(repoPath string, roots []managedRoot, managed []string, linkMode string, previous map[string]linkStateEntry) error {
+	manifest := linkStateManifest{
+		RepoPath: repoPath,
+		LinkMode: linkMode,
+		Files:    map[string]linkStateEntry{},
+	}
+	for _, rel := range managed {
+		repoFile := filepath.Join(repoPath, filepath.FromSlash(rel))
+		liveFile := liveFilePath(roots, rel)
+		current, ok := currentLinkState(repoFile, liveFile)
+		if !ok {
+			continue
+		}
+		if entry, ok := previous[rel]; !ok || entry != current {
+			if linked, err := symlinkPointsTo(liveFile, repoFile); err != nil || !linked {
+				continue
+			}
+		}
+		manifest.Files[rel] = current
+	}
+
+	manifestPath, err := linkStateManifestPath()
+	if err != nil {
+		return err
+	}
+	if err := os.MkdirAll(filepath.Dir(manifestPath), 0o755); err != nil {
+		return err
+	}
+	data, err := json.Marshal(manifest)
+	if err != nil {
+		return err
+	}
+	return os.WriteFile(manifestPath, append(data, '\n'), 0o644)
+}
+
+// currentLinkState captures the cheap-to-read facts incremental doctor
+// compares: the repo file's size and mtime and the live symlink's own mtime
+// and target. It reports false when the live path is not a symlink.
+func currentLinkState(repoFile string, liveFile string) (linkStateEntry, bool) {
+	repoInfo, err := os.Stat(repoFile)
+	if err != nil || !repoInfo.Mode().IsRegular() {
+		return linkStateEntry{}, false
+	}
+	liveInfo, err := os.Lstat(liveFile)
+	if err != nil || liveInfo.Mode()&os.ModeSymlink == 0 {
+		return linkStateEntry{}, false
+	}
+	target, err := os.Readlink(liveFile)
+	if err != nil {
+		return linkStateEntry{}, false
+	}
+	return linkStateEntry{
+		Target:        target,
+		RepoSize:      repoInfo.Size(),
+		RepoModTime:   repoInfo.ModTime().UnixNano(),
+		LiveLinkMtime: liveInfo.ModTime().UnixNano(),
+	}, true
+}
+
+// readManagedFileList reads newline-separated managed paths, ignoring blank
+// lines and # comments.
+func readManagedFileList(listPath string) ([]string, error) {
+	data, err := os.ReadFile(expandPath(listPath))
+	if err != nil {
+		return nil, fmt.Errorf("read managed file list: %w", err)
+	}
+	paths := []string{}
+	for i, line := range strings.Split(string(data), "\n") {
+		line = strings.TrimSpace(line)
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+		rel, err := normalizeManagedPath(line)
+		if err != nil {
+			return nil, fmt.Errorf("%s:%d: %s: %w", listPath, i+1, line, err)
+		}
+		paths = append(paths, rel)
+	}
+	return unique(paths), nil
+}
+
+// restrictManagedFiles keeps the managed paths named in requested and fails
+// when any requested path is not managed by the repo.
+func restrictManagedFiles(managed []string, requested []string) ([]string, error) {
+	managedSet := sliceToSet(managed)
+	var kept, unmanaged []string
+	for _, rel := range requested {
+		if _, ok := managedSet[rel]; ok {
+			kept = append(kept, rel)
+		} else {
+			unmanaged = append(unmanaged, rel)
+		}
+	}
+	if len(unmanaged) > 0 {
+		sort.Strings(unmanaged)
+		return nil, fmt.Errorf("managed file list names paths the repo does not track: %s", strings.Join(unmanaged, ", "))
+	}
+	sort.Strings(kept)
+	return kept, nil
+}
+
+// commitEmptyMarker records an audit commit noting which host ran action and
+// when. It does nothing when the repo has uncommitted changes, since the
+// marker is meant for runs that changed nothing.
+func (a *app) commitEmptyMarker(repoPath string, action string) error {
+	dirty, err := a.gitIsDirty(repoPath)
+	if err != nil {
+		return err
+	}
+	if dirty {
+		fmt.Fprintln(a.out, "Repository has uncommitted changes; skipped empty audit commit.")
+		return nil
+	}
+	message := fmt.Sprintf("cfgs %s: reconciled on %s at %s", action, a.reportHostname(), time.Now().UTC().Format(time.RFC3339))
+	commitArgs, err := gitCommitArgs("commit", "--allow-empty", "-m", message)
+	if err != nil {
+		return err
+	}
+	if _, err := a.runCommand(repoPath, "git", commitArgs...); err != nil {
+		return err
+	}
+	fmt.Fprintf(a.out, "Recorded empty commit: %s\n", message)
+	return nil
+}
+
+// fixDanglingSymlinks removes managed and orphan live symlinks whose repo
+// target is gone, leaving everything else untouched.
+func (a *app) fixDanglingSymlinks(repoPath string, opts doctorOptions) error {
+	managed, err := a.loadManagedFiles(repoPath)
+	if err != nil {
+		return err
+	}
+	roots, err := configuredRoots()
+	if err != nil {
+		return err
+	}
+
+	report := doctorReport{}
+	for _, rel := range managed {
+		pruned, err := pruneVanishedRepoLink(liveFilePath(roots, rel), repoPath)
+		if err != nil {
+			report.requireManualResolve = append(report.requireManualResolve, manualResolve{path: rel, reason: fmt.Sprintf("remove dangling symlink: %v", err)})
+			continue
+		}
+		if !pruned {
+			continue
+		}
+		report.unlinkedOrphanSymlink = append(report.unlinkedOrphanSymlink, rel+" (removed dangling symlink)")
+	}
+
+	ignoreMatchers, err := a.configuredIgnoreMatchers()
+	if err != nil {
+		return err
+	}
+	ignoreMatchers = append(ignoreMatchers, opts.exclude...)
+	orphanReport, err := reconcileOrphanRepoSymlinks(repoPath, roots, sliceToSet(managed), ignoreMatchers, true)
+	if err != nil {
+		return err
+	}
+	report.unlinkedOrphanSymlink = append(report.unlinkedOrphanSymlink, orphanReport.unlinkedOrphanSymlink...)
+	report.requireManualResolve = append(report.requireManualResolve, orphanReport.requireManualResolve...)
+
+	return a.finishDoctorReport(repoPath, report, opts)
+}
+
+// finishDoctorReport emits the report to stdout or --report-file and turns
+// pending manual reconciles into an error unless --keep-going is set.
+func (a *app) finishDoctorReport(repoPath string, report doctorReport, opts doctorOptions) error {
+	var buf bytes.Buffer
+	switch {
+	case a.jsonOutput:
+		if err := writeDoctorReportJSON(&buf, a.reportHostname(), repoPath, report); err != nil {
+			return err
+		}
+	default:
+		printDoctorReport(&buf, report, opts.summaryOnly, opts.reportFile == "" && a.quiet)
+	}
+	if opts.showDiff && !a.jsonOutput {
+		if err := a.writeDivergedDiffs(&buf, repoPath, report.requireManualResolve); err != nil {
+			return err
+		}
+	}
+
+	if opts.reportFile != "" {
+		if err := os.WriteFile(opts.reportFile, buf.Bytes(), 0o644); err != nil {
+			return fmt.Errorf("write report file: %w", err)
+		}
+	} else if _, err := a.out.Write(buf.Bytes()); err != nil {
+		return err
+	}
+
+	if len(report.requireManualResolve) == 0 {
+		return nil
+	}
+	if opts.keepGoing {
+		if !a.quiet {
+			fmt.Fprintf(a.errOut, "warning: manual reconcile required for %d file(s)\n", len(report.requireManualResolve))
+		}
+		return nil
+	}
+	return fmt.Errorf("manual reconcile required for %d file(s)", len(report.requireManualResolve))
+}
+
+// confirmRepoWins decides whether a diverged live file may be replaced by the
+// repo version. A live file modified after the repo file is only overwritten
+// with force or explicit confirmation.
+func (a *app) confirmRepoWins(rel string, repoInfo fs.FileInfo, liveInfo fs.FileInfo, force bool) (bool, error) {
+	if force || !liveInfo.ModTime().After(repoInfo.ModTime()) {
+		return true, nil
+	}
+	const layout = "2006-01-02 15:04:05"
+	question := fmt.Sprintf("%s: live file (modified %s) is newer than the repo file (modified %s). Overwrite it with the repo version?",
+		rel, liveInfo.ModTime().Format(layout), repoInfo.ModTime().Format(layout))
+	return a.promptYesNo(question, false)
+}
+
+func (a *app) cmdCheck(ctx context.Context, args []string) error {
+	flags := a.newFlagSet("check")
+	a.addYesFlags(flags)
+	a.addCommitMessageFlags(flags)
+	flags.StringVar(&a.remote, "remote", "", "git remote to push to")
+	amend := flags.Bool("amend", false, "fold the changes into the last commit")
+	commitEmpty := flags.Bool("commit-empty", false, "record a clean tree as an empty commit")
+	all := flags.Bool("all", false, "commit every change without picking files")
+	if err := parseNoPositional(flags, args); err != nil {
+		return err
+	}
+	repoPath, err := a.resolveRepoPath()
+	if err != nil {
+		return err
+	}
+
+	dirty, err := a.gitIsDirty(repoPath)
+	if err != nil {
+		return err
+	}
+	if !dirty {
+		fmt.Fprintln(a.out, "Git working tree is clean.")
+		if *commitEmpty {
+			return a.commitEmptyMarker(repoPath, "check")
+		}
+		return nil
+	}
+
+	if err := a.showUncommittedDiff(repoPath, "check"); err != nil {
+		return err
+	}
+
+	question := "Uncommitted changes detected. Commit them now?"
+	if *amend {
+		question = "Uncommitted changes detected. Amend the last commit with them?"
+	}
+	commitNow, err := a.promptYesNo(question, true)
+	if err != nil {
+		return err
+	}
+	if !commitNow {
+		fmt.Fprintln(a.out, "Skipped commit.")
+		return nil
+	}
+
+	var commitArgs []string
+	if *amend {
+		ok, err := a.confirmAmend(repoPath)
+		if err != nil {
+			return err
+		}
+		if !ok {
+			fmt.Fprintln(a.out, "Skipped commit.")
+			return nil
+		}
+		commitArgs = append(commitArgs, "--amend")
+	}
+
+	addArgs := []string{"add", "-A"}
+	if !*all && !a.assumeYes {
+		selected, err := a.selectChangedPaths(repoPath)
+		if errors.Is(err, errSelectionCancelled) {
+			fmt.Fprintln(a.out, "Skipped commit.")
+			return nil
+		}
+		if err != nil {
+			return err
+		}
+		if len(selected) > 0 {
+			// The pathspec keeps anything staged beforehand out of the commit.
+			addArgs = append([]string{"add", "-A", "--"}, selected...)
+			commitArgs = append(append(commitArgs, "--"), selected...)
+		}
+	}
+	if _, err := a.runCommand(repoPath, "git", addArgs...); err != nil {
+		return err
+	}
+	if err := a.commitChanges(repoPath, a.commitMessage, commitArgs...); err != nil {
+		return err
+	}
+
+	if err := a.askPush(ctx, repoPath); err != nil {
+		return err
+	}
+
+	return a.cmdDoctorWithRepo(ctx, repoPath, doctorOptions{})
+}
+
+// confirmAmend checks that there is a commit to amend and asks before
+// rewriting one that is already on a remote branch.
+func (a *app) confirmAmend(repoPath string) (bool, error) {
+	hasHead, err := a.repoHasHead(repoPath)
+	if err != nil {
+		return false, err
+	}
+	if !hasHead {
+		return false, fmt.Errorf("nothing to amend: repository has no commits")
+	}
+	pushed, err := a.gitHeadIsPushed(repoPath)
+	if err != nil {
+		return false, err
+	}
+	if !pushed {
+		return true, nil
+	}
+	fmt.Fprintln(a.errOut, "warning: the last commit is already on a remote branch; amending rewrites published history and will need a force push.")
+	return a.promptYesNo("Amend anyway?", false)
+}
+
+func (a *app) cmdUnlink(ctx context.Context, args []string) error {
+	flags := a.newFlagSet("unlink")
+	a.addYesFlags(flags)
+	a.addCommitMessageFlags(flags)
+	a.addNoCommitFlag(flags)
+	deleteRepo := flags.Bool("delete-repo", false, "also delete the repo file so it is no longer tracked")
+	flags.BoolVar(&a.jsonOutput, "json", false, "print the report as JSON")
+	flags.BoolVar(&a.quiet, "quiet", false, "print the report only when something fails")
+	stdin := flags.Bool("stdin", false, "read newline-separated paths from standard input instead of fzf")
+	all := flags.Bool("all", false, "unlink every tracked file")
+	positional, err := parseFlags(flags, args)
+	if err != nil {
+		return err
+	}
+	explicit, err := a.pathArguments(positional, *stdin)
+	if err != nil {
+		return err
+	}
+	if *all && len(explicit) > 0 {
+		return fmt.Errorf("--all cannot be combined with path arguments")
+	}
+	repoPath, err := a.resolveRepoPath()
+	if err != nil {
+		return err
+	}
+	managed, err := a.loadManagedFiles(repoPath)
+	if err != nil {
+		return err
+	}
+	dirs, err := loadManagedDirs(repoPath)
+	if err != nil {
+		return err
+	}
+	candidates := append(slices.Clone(managed), dirs...)
+	if len(candidates) == 0 && len(explicit) == 0 {
+		fmt.Fprintln(a.out, "No tracked files to unlink.")
+		return nil
+	}
+
+	var selected []string
+	if *all {
+		if *deleteRepo {
+			// Deleting every repo file is as destructive as remove --all.
+			ok, err := a.promptYesNo(fmt.Sprintf("Unlink and delete all %d tracked files and %d directories from the repository?", len(managed), len(dirs)), false)
+			if err != nil {
+				return err
+			}
+			if !ok {
+				return nil
+			}
+		}
+		selected = candidates
+	} else {
+		selected, err = a.selectFiles(candidates, explicit, "unlink> ")
+		if err != nil {
+			return err
+		}
+	}
+	if len(selected) == 0 {
+		fmt.Fprintln(a.out, "No files selected.")
+		return nil
+	}
+
+	roots, err := configuredRoots()
+	if err != nil {
+		return err
+	}
+	cfg, _, err := loadCfgsConfig()
+	if err != nil {
+		return err
+	}
+	modes, err := loadFileModes(repoPath)
+	if err != nil {
+		return err
+	}
+	modesChanged := false
+	keptDirs := slices.Clone(dirs)
+
+	report := operationReport{}
+	for _, raw := range selected {
+		rel, err := normalizeManagedPath(raw)
+		if err != nil {
+			report.failed = append(report.failed, fmt.Sprintf("%s: invalid path", raw))
+			continue
+		}
+
+		repoFile := filepath.Join(repoPath, filepath.FromSlash(rel))
+		liveFile := liveFilePath(roots, rel)
+		if slices.Contains(dirs, rel) {
+			if status := classifyManagedDir(repoFile, liveFile); status != linkStatusLinked {
+				report.skipped = append(report.skipped, fmt.Sprintf("%s/: live path is %s", rel, status))
+				continue
+			}
+			if err := unlinkManagedDir(repoFile, liveFile, cfg.LinkMode); err != nil {
+				report.failed = append(report.failed, fmt.Sprintf("%s/: %v", rel, err))
+				continue
+			}
+			if *deleteRepo {
+				if _, err := a.runCommand(repoPath, "git", "rm", "-r", "--quiet", "--force", "--", rel); err != nil {
+					report.failed = append(report.failed, fmt.Sprintf("%s/: live copy restored but git rm failed: %v", rel, err))
+					continue
+				}
+				removeEmptyDirsUpward(repoPath, filepath.Dir(repoFile))
+				keptDirs = slices.DeleteFunc(keptDirs, func(dir string) bool { return dir == rel })
+			}
+			report.changed = true
+			report.succeeded = append(report.succeeded, rel+"/")
+			continue
+		}
+		liveInfo, err := os.Lstat(liveFile)
+		if err != nil {
+			report.skipped = append(report.skipped, fmt.Sprintf("%s: live file missing", rel))
+			continue
+		}
+		if liveInfo.Mode()&os.ModeSymlink == 0 {
+			report.skipped = append(report.skipped, fmt.Sprintf("%s: live file is not a symlink", rel))
+			continue
+		}
+
+		ok, err := symlinkPointsTo(liveFile, repoFile)
+		if err != nil || !ok {
+			report.skipped = append(report.skipped, fmt.Sprintf("%s: symlink does not point to repo file", rel))
+			continue
+		}
+
+		if err := os.Remove(liveFile); err != nil {
+			report.failed = append(report.failed, fmt.Sprintf("%s: remove symlink: %v", rel, err))
+			continue
+		}
+		if err := copyFileWith(repoFile, liveFile, copyFileOptions{preserveTimes: true}); err != nil {
+			report.failed = append(report.failed, fmt.Sprintf("%s: copy file: %v", rel, err))
+			continue
+		}
+		if mode, ok := modes[rel]; ok {
+			if err := os.Chmod(liveFile, mode); err != nil {
+				report.failed = append(report.failed, fmt.Sprintf("%s: restore mode %04o: %v", rel, mode, err))
+				continue
+			}
+		} else if cfg.ReadonlyRepoFiles {
+			if err := addOwnerWriteBit(liveFile); err != nil {
+				report.failed = append(report.failed, fmt.Sprintf("%s: restore write permission: %v", rel, err))
+				continue
+			}
+		}
+		if *deleteRepo {
+			if _, err := a.runCommand(repoPath, "git", "rm", "--quiet", "--force", "--", rel); err != nil {
+				report.failed = append(report.failed, fmt.Sprintf("%s: live copy restored but git rm failed: %v", rel, err))
+				continue
+			}
+			removeEmptyDirsUpward(repoPath, filepath.Dir(repoFile))
+			if _, ok := modes[rel]; ok {
+				delete(modes, rel)
+				modesChanged = true
+			}
+		}
+		report.changed = true
+		report.succeeded = append(report.succeeded, rel)
+	}
+
+	if modesChanged {
+		if err := saveFileModes(repoPath, modes); err != nil {
+			report.failed = append(report.failed, fmt.Sprintf("%s: %v", modesManifestPath, err))
+		}
+	}
+	if len(keptDirs) != len(dirs) {
+		if err := saveManagedDirs(repoPath, keptDirs); err != nil {
+			report.failed = append(report.failed, fmt.Sprintf("%s: %v", dirsManifestPath, err))
+		}
+	}
+
+	if err := a.emitOperationReport("unlink", report); err != nil {
+		return err
+	}
+	if !*deleteRepo {
+		if len(report.succeeded) > 0 && !a.jsonOutput {
+			fmt.Fprintln(a.out, "Unlinked files are still tracked in the repo; use `cfgs unlink --delete-repo` or `cfgs remove` to untrack them.")
+		}
+		return nil
+	}
+	if report.changed {
+		return a.commitAndAskPush(ctx, repoPath, "unlink", len(report.succeeded))
+	}
+	return nil
+}
+
+// cmdVerifyRepo inspects the managed files in the repo without looking at the
+// live side, flagging entries doctor could never link and unusual permissions.
+func (a *app) cmdVerifyRepo(ctx context.Context, args []string) error {
+	_ = ctx
+	if err := a.parseNoArgs("verify-repo", args); err != nil {
+		return err
+	}
+	repoPath, err := a.resolveRepoPath()
+	if err != nil {
+		return err
+	}
+	managed, err := a.loadManagedFiles(repoPath)
+	if err != nil {
+		return err
+	}
+	if len(managed) == 0 {
+		fmt.Fprintln(a.out, "No tracked files found.")
+		return nil
+	}
+
+	var wellFormed, problems []string
+	for _, rel := range managed {
+		if problem := verifyRepoFile(filepath.Join(repoPath, filepath.FromSlash(rel))); problem != "" {
+			problems = append(problems, fmt.Sprintf("%s: %s", rel, problem))
+			continue
+		}
+		wellFormed = append(wellFormed, rel)
+	}
+
+	printReportBucket(a.out, "", "well-formed", wellFormed)
+	printReportBucket(a.out, "", "problems", problems)
+	if len(problems) > 0 {
+		return fmt.Errorf("%d tracked file(s) are not well-formed", len(problems))
+	}
+	return nil
+}
+
+// verifyRepoFile returns a description of what is wrong with a managed repo
+// file, or "" when it is a regular file with ordinary permissions.
+func verifyRepoFile(repoFile string) string {
+	info, err := os.Lstat(repoFile)
+	if err != nil {
+		if errors.Is(err, fs.ErrNotExist) {
+			return "missing from the working tree"
+		}
+		return fmt.Sprintf("cannot inspect: %v", err)
+	}
+	mode := info.Mode()
+	switch {
+	case mode&os.ModeSymlink != 0:
+		return "is a symlink"
+	case mode.IsDir():
+		return "is a directory"
+	case !mode.IsRegular():
+		return fmt.Sprintf("is not a regular file (%s)", mode.Type())
+	case mode&os.ModeSetuid != 0 || mode&os.ModeSetgid != 0:
+		return fmt.Sprintf("has setuid/setgid bits (%s)", mode)
+	case mode.Perm()&0o002 != 0:
+		return fmt.Sprintf("is world-writable (%04o)", mode.Perm())
+	}
+	return ""
+}
+
+func (a *app) cmdConfigSchema(ctx context.Context, args []string) error {
+	_ = ctx
+	if err := a.parseNoArgs("config-schema", args); err != nil {
+		return err
+	}
+	schema := jsonSchemaFor(reflect.TypeOf(cfgsConfig{}))
+	schema["$schema"] = "https://json-schema.org/draft/2020-12/schema"
+	schema["title"] = "cfgs config"
+	data, err := json.MarshalIndent(schema, "", "  ")
+	if err != nil {
+		return err
+	}
+	fmt.Fprintln(a.out, string(data))
+	return nil
+}
+
+// jsonSchemaFor derives a JSON Schema from a config type using its json,
+// desc, and enum struct tags.
+func jsonSchemaFor(t reflect.Type) map[string]any {
+	switch t.Kind() {
+	case reflect.Pointer:
+		return jsonSchemaFor(t.Elem())
+	case reflect.String:
+		return map[string]any{"type": "string"}
+	case reflect.Bool:
+		return map[string]any{"type": "boolean"}
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64,
+		reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64:
+		return map[string]any{"type": "integer"}
+	case reflect.Float32, reflect.Float64:
+		return map[string]any{"type": "number"}
+	case reflect.Slice, reflect.Array:
+		return map[string]any{"type": "array", "items": jsonSchemaFor(t.Elem())}
+	case reflect.Map:
+		return map[string]any{"type": "object", "additionalProperties": jsonSchemaFor(t.Elem())}
+	case reflect.Struct:
+		properties := map[string]any{}
+		required := []string{}
+		for i := 0; i < t.NumField(); i++ {
+			field := t.Field(i)
+			name, options, _ := strings.Cut(field.Tag.Get("json"), ",")
+			if !field.IsExported() || name == "-" {
+				continue
+			}
+			if name == "" {
+				name = field.Name
+			}
+			prop := jsonSchemaFor(field.Type)
+			if desc := field.Tag.Get("desc"); desc != "" {
+				prop["description"] = desc
+			}
+			if enum := field.Tag.Get("enum"); enum != "" {
+				prop["enum"] = strings.Split(enum, ",")
+			}
+			properties[name] = prop
+			if !strings.Contains(options, "omitempty") {
+				required = append(required, name)
+			}
+		}
+		schema := map[string]any{
+			"type":                 "object",
+			"properties":           properties,
+			"additionalProperties": false,
+		}
+		if len(required) > 0 {
+			schema["required"] = required
+		}
+		return schema
+	default:
+		return map[string]any{}
+	}
+}
+
+// cmdProfile lists the configured profiles, or switches the active one. With
+// --repo it creates or repoints the named profile before switching to it.
+func (a *app) cmdProfile(ctx context.Context, args []string) error {
+	_ = ctx
+	flags := a.newFlagSet("profile")
+	repoFlag := flags.String("repo", "", "create or repoint the profile at repository `path`")
+	positional, err := parseFlags(flags, args)
+	if err != nil {
+		return err
+	}
+	if len(positional) > 1 {
+		return fmt.Errorf("profile: expected at most one name, got %d", len(positional))
+	}
+	cfg, _, err := loadCfgsConfig()
+	if err != nil {
+		return err
+	}
+	names := make([]string, 0, len(cfg.Profiles))
+	for name := range cfg.Profiles {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	if len(positional) == 0 {
+		if *repoFlag != "" {
+			return fmt.Errorf("--repo needs a profile name")
+		}
+		if len(names) == 0 {
+			fmt.Fprintln(a.out, "No profiles configured.")
+			return nil
+		}
+		for _, name := range names {
+			marker := " "
+			if name == cfg.Active {
+				marker = "*"
+			}
+			fmt.Fprintf(a.out, "%s %s\t%s\n", marker, name, cfg.Profiles[name].RepoPath)
+		}
+		return nil
+	}
+
+	name := strings.TrimSpace(positional[0])
+	if name == "" {
+		return fmt.Errorf("profile name must not be empty")
+	}
+	profile, exists := cfg.Profiles[name]
+	if *repoFlag != "" {
+		repoPath, err := a.validateAndNormalizeRepo(*repoFlag)
+		if err != nil {
+			return fmt.Errorf("--repo: %w", err)
+		}
+		profile.RepoPath = repoPath
+	} else if !exists {
+		return fmt.Errorf("profile %q is not defined (available: %s); pass --repo to create it", name, strings.Join(names, ", "))
+	}
+
+	// saveCfgsConfig stores the top-level fields into the active profile, so
+	// switching means loading the new profile's values into them.
+	if cfg.Profiles == nil {
+		cfg.Profiles = map[string]profileConfig{}
+	}
+	cfg.Profiles[name] = profile
+	cfg.Active = name
+	cfg.RepoPath = profile.RepoPath
+	cfg.IgnoreGlobs = profile.IgnoreGlobs
+	if err := saveCfgsConfig(cfg); err != nil {
+		return err
+	}
+	fmt.Fprintf(a.out, "profile: switched to %s (%s)\n", name, profile.RepoPath)
+	if strings.TrimSpace(os.Getenv("CFGS_REPO")) != "" {
+		fmt.Fprintln(a.errOut, "warning: CFGS_REPO is set and overrides the active profile's repo_path")
+	}
+	return nil
+}
+
+// configKeyFields maps each config key `cfgs config` can edit to its
+// cfgsConfig field index. Profiles and the active profile belong to
+// `cfgs profile`, and maps are left to the editor.
+func configKeyFields() (map[string]int, []string) {
+	fields := map[string]int{}
+	var keys []string
+	t := reflect.TypeOf(cfgsConfig{})
+	for i := 0; i < t.NumField(); i++ {
+		name, _, _ := strings.Cut(t.Field(i).Tag.Get("json"), ",")
+		if name == "" || name == "-" || name == "profiles" || name == "active" || t.Field(i).Type.Kind() == reflect.Map {
+			continue
+		}
+		fields[name] = i
+		keys = append(keys, name)
+	}
+	sort.Strings(keys)
+	return fields, keys
+}
+
+// cmdConfig reads and edits the config file. set appends to list values
+// such as ignore_globs, and every change is validated the way the config is
+// on load before it is saved.
+func (a *app) cmdConfig(ctx context.Context, args []string) error {
+	_ = ctx
+	flags := a.newFlagSet("config")
+	positional, err := parseFlags(flags, args)
+	if err != nil {
+		return err
+	}
+	if len(positional) == 0 {
+		return fmt.Errorf("config: expected get <key>, set <key> <value>, unset <key>, or list")
+	}
+	fields, keys := configKeyFields()
+	cfg, _, err := readCfgsConfig()
+	if err != nil {
+		return err
+	}
+	value := reflect.ValueOf(&cfg).Elem()
+
+	action, rest := positional[0], positional[1:]
+	wantArgs := map[string]int{"get": 1, "set": 2, "unset": 1, "list": 0}
+	n, ok := wantArgs[action]
+	if !ok {
+		return fmt.Errorf("config: unknown action %q (want get, set, unset, or list)", action)
+	}
+	if len(rest) != n {
+		return fmt.Errorf("config %s: expected %d argument(s), got %d", action, n, len(rest))
+	}
+	if action == "list" {
+		for _, key := range keys {
+			data, err := json.Marshal(value.Field(fields[key]).Interface())
+			if err != nil {
+				return err
+			}
+			fmt.Fprintf(a.out, "%-20s %s\n", key, data)
+		}
+		return nil
+	}
+
+	key := rest[0]
+	index, ok := fields[key]
+	if !ok {
+		if known := closestConfigField(key); known != "" && known != key {
+			return fmt.Errorf("config: unknown key %q (did you mean %q?)", key, known)
+		}
+		return fmt.Errorf("config: unknown key %q (known keys: %s)", key, strings.Join(keys, ", "))
+	}
+	field := value.Field(index)
+
+	switch action {
+	case "get":
+		switch field.Kind() {
+		case reflect.Slice:
+			for i := 0; i < field.Len(); i++ {
+				fmt.Fprintln(a.out, field.Index(i).Interface())
+			}
+		case reflect.Pointer:
+			if !field.IsNil() {
+				fmt.Fprintln(a.out, field.Elem().Interface())
+			}
+		default:
+			fmt.Fprintln(a.out, field.Interface())
+		}
+		return nil
+	case "unset":
+		field.Set(reflect.Zero(field.Type()))
+	case "set":
+		if err := a.setConfigField(field, key, rest[1]); err != nil {
+			return err
+		}
+	}
+	if err := normalizeCfgsConfig(&cfg); err != nil {
+		return fmt.Errorf("config %s %s: %w", action, key, err)
+	}
+	if err := saveCfgsConfig(cfg); err != nil {
+		return err
+	}
+	fmt.Fprintf(a.out, "config: %s %s\n", action, key)
+	return nil
+}
+
+// setConfigField parses raw into field, appending to lists. Keys that name
+// a repo or globs are checked more closely than normalizeCfgsConfig does.
+func (a *app) setConfigField(field reflect.Value, key string, raw string) error {
+	switch key {
+	case "repo_path":
+		repoPath, err := a.validateAndNormalizeRepo(raw)
+		if err != nil {
+			return fmt.Errorf("config set %s: %w", key, err)
+		}
+		raw = repoPath
+	case "ignore_globs", "secret_globs":
+		if _, err := compileGlobMatchers([]string{raw}); err != nil {
+			return fmt.Errorf("config set %s: %w", key, err)
+		}
+	}
+
+	switch field.Kind() {
+	case reflect.String:
+		field.SetString(raw)
+	case reflect.Bool:
+		b, err := strconv.ParseBool(raw)
+		if err != nil {
+			return fmt.Errorf("config set %s: want true or false, got %q", key, raw)
+		}
+		field.SetBool(b)
+	case reflect.Pointer:
+		n, err := strconv.Atoi(raw)
+		if err != nil {
+			return fmt.Errorf("config set %s: want an integer, got %q", key, raw)
+		}
+		field.Set(reflect.ValueOf(&n))
+	case reflect.Slice:
+		if slices.Contains(field.Interface().([]string), raw) {
+			return fmt.Errorf("config set %s: %q is already listed", key, raw)
+		}
+		field.Set(reflect.Append(field, reflect.ValueOf(raw)))
+	default:
+		return fmt.Errorf("config set %s: unsupported value type %s", key, field.Type())
+	}
+	return nil
+}
+
+// cmdOpen opens the repo directory, or a managed file through its live path,
+// with $VISUAL (files only) or the platform opener, and prints the path when
+// no opener is available.
+func (a *app) cmdOpen(ctx context.Context, args []string) error {
+	_ = ctx
+	flags := a.newFlagSet("open")
+	positional, err := parseFlags(flags, args)
+	if err != nil {
+		return err
+	}
+	if len(positional) > 1 {
+		return fmt.Errorf("open: expected at most one path, got %d", len(positional))
+	}
+	repoPath, err := a.resolveRepoPath()
+	if err != nil {
+		return err
+	}
+
+	target := repoPath
+	if len(positional) == 1 {
+		rel, err := normalizeManagedPath(positional[0])
+		if err != nil {
+			return fmt.Errorf("%s: %w", positional[0], err)
+		}
+		managed, err := a.loadManagedFiles(repoPath)
+		if err != nil {
+			return err
+		}
+		if _, ok := sliceToSet(managed)[rel]; !ok {
+			return fmt.Errorf("%s is not a tracked file", rel)
+		}
+		roots, err := configuredRoots()
+		if err != nil {
+			return err
+		}
+		target = liveFilePath(roots, rel)
+		if visual := strings.TrimSpace(os.Getenv("VISUAL")); visual != "" {
+			fields := strings.Fields(visual)
+			return a.runInteractiveCommand("", fields[0], append(fields[1:], target)...)
+		}
+	}
+
+	opener := platformOpener()
+	if opener == "" {
+		fmt.Fprintln(a.out, target)
+		return nil
+	}
+	_, err = a.runCommand("", opener, target)
+	return err
+}
+
+// cmdDiff shows the repo's uncommitted changes like check does, without the
+// commit and push prompts. With --against it instead prints unified diffs
+// between each live managed file and its repo copy at an earlier ref.
+func (a *app) cmdDiff(ctx context.Context, args []string) error {
+	flags := a.newFlagSet("diff")
+	against := flags.String("against", "", "compare live files with the repo at git `ref` instead of showing uncommitted changes")
+	if err := parseNoPositional(flags, args); err != nil {
+		return err
+	}
+	repoPath, err := a.resolveRepoPath()
+	if err != nil {
+		return err
+	}
+	if *against == "" {
+		return a.showUncommittedDiff(repoPath, "diff")
+	}
+	if err := requireCommands("diff"); err != nil {
+		return err
+	}
+	commit, err := a.runCommand(repoPath, "git", "rev-parse", "--verify", "--quiet", *against+"^{commit}")
+	if err != nil {
+		return fmt.Errorf("unknown ref %q", *against)
+	}
+	managed, err := a.loadManagedFiles(repoPath)
+	if err != nil {
+		return err
+	}
+	roots, err := configuredRoots()
+	if err != nil {
+		return err
+	}
+	cfg, _, err := loadCfgsConfig()
+	if err != nil {
+		return err
+	}
+	secrets, err := a.loadAgeSecrets(cfg)
+	if err != nil {
+		return err
+	}
+
+	tmpDir, err := os.MkdirTemp("", "cfgs-diff-")
+	if err != nil {
+		return err
+	}
+	defer os.RemoveAll(tmpDir)
+
+	differing := 0
+	for _, rel := range managed {
+		if err := ctx.Err(); err != nil {
+			return err
+		}
+		oldLabel := *against + ":" + rel
+		oldFile := filepath.Join(tmpDir, "old")
+		content, existed, err := a.gitShowFile(repoPath, commit, rel)
+		if err != nil {
+			return err
+		}
+		if secrets.isSecret(rel) {
+			changed, err := a.diffSecret(secrets, rel, *against, content, existed, liveFilePath(roots, rel), tmpDir)
+			if err != nil {
+				return fmt.Errorf("%s: %w", rel, err)
+			}
+			if changed {
+				differing++
+			}
+			continue
+		}
+		if existed {
+			if err := os.WriteFile(oldFile, content, 0o600); err != nil {
+				return err
+			}
+		} else {
+			oldLabel, oldFile = os.DevNull, os.DevNull
+		}
+
+		newLabel := liveFilePath(roots, rel)
+		newFile := newLabel
+		if _, err := os.Stat(newFile); errors.Is(err, fs.ErrNotExist) {
+			newLabel, newFile = os.DevNull, os.DevNull
+		}
+		if oldFile == os.DevNull && newFile == os.DevNull {
+			continue
+		}
+
+		changed, err := a.unifiedDiff(oldLabel, oldFile, newLabel, newFile)
+		if err != nil {
+			return fmt.Errorf("%s: %w", rel, err)
+		}
+		if changed {
+			differing++
+		}
+	}
+
+	if differing == 0 {
+		fmt.Fprintf(a.out, "diff: live files match %s.\n", *against)
+	} else {
+		fmt.Fprintf(a.out, "diff: %d file(s) differ from %s.\n", differing, *against)
+	}
+	return nil
+}
+
+// diffSecret compares a secret's live file with its decrypted content at ref
+// and says only whether they differ, so plaintext never reaches the output.
+func (a *app) diffSecret(secrets *ageSecrets, rel string, ref string, ciphertext []byte, existed bool, liveFile string, tmpDir string) (bool, error) {
+	_, err := os.Stat(liveFile)
+	liveExists := err == nil
+	switch {
+	case !existed && !liveExists:
+		return false, nil
+	case !existed:
+		fmt.Fprintf(a.out, "secret %s: not in %s (content not shown)\n", rel, ref)
+		return true, nil
+	case !liveExists:
+		fmt.Fprintf(a.out, "secret %s: live file missing (content not shown)\n", rel)
+		return true, nil
+	}
+	encrypted := filepath.Join(tmpDir, "old.age")
+	if err := os.WriteFile(encrypted, ciphertext, 0o600); err != nil {
+		return false, err
+	}
+	plaintext, err := secrets.decryptToTemp(encrypted, tmpDir)
+	if err != nil {
+		return false, err
+	}
+	defer os.Remove(plaintext)
+	same, err := filesEqual(plaintext, liveFile)
+	if err != nil {
+		return false, err
+	}
+	if !same {
+		fmt.Fprintf(a.out, "secret %s: live file differs from %s (content not shown)\n", rel, ref)
+	}
+	return !same, nil
+}
+
+// gitShowFile returns the content of rel at commit, reporting false when the
+// path did not exist there.
+func (a *app) gitShowFile(repoPath string, commit string, rel string) ([]byte, bool, error) {
+	if _, err := a.runCommand(repoPath, "git", "cat-file", "-e", commit+":"+rel); err != nil {
+		return nil, false, nil
+	}
+	cmd := exec.Command("git", "show", commit+":"+rel)
+	cmd.Dir = repoPath
+	var content bytes.Buffer
+	cmd.Stdout = &content
+	if err := a.runner.Run(cmd); err != nil {
+		return nil, false, fmt.Errorf("git show %s:%s failed: %w", shortHash(commit), rel, err)
+	}
+	return content.Bytes(), true, nil
+}
+
+// unifiedDiff writes `diff -u` output for the two files and reports whether
+// they differ.
+func (a *app) unifiedDiff(oldLabel string, oldFile string, newLabel string, newFile string) (bool, error) {
+	cmd := exec.Command("diff", "-u", "--label", oldLabel, "--label", newLabel, oldFile, newFile)
+	cmd.Stdout = a.out
+	cmd.Stderr = a.errOut
+	err := a.runner.Run(cmd)
+	var exitErr *exec.ExitError
+	switch {
+	case err == nil:
+		return false, nil
+	case errors.As(err, &exitErr) && exitErr.ExitCode() == 1:
+		return true, nil
+	default:
+		return false, fmt.Errorf("diff failed: %w", err)
+	}
+}
+
+// platformOpener returns the desktop opener command for this OS, or "" when
+// none is installed.
+func platformOpener() string {
+	name := "xdg-open"
+	if runtime.GOOS == "darwin" {
+		name = "open"
+	}
+	if _, err := exec.LookPath(name); err != nil {
+		return ""
+	}
+	return name
+}
+
+// cmdWatch polls the repo and the live roots, and after changes settle runs a
+// read-only drift check. With --auto-link, managed files whose live path is
+// missing are linked. It stops when ctx is cancelled (e.g. by SIGINT).
+func (a *app) cmdWatch(ctx context.Context, args []string) error {
+	flags := a.newFlagSet("watch")
+	interval := flags.Duration("interval", 2*time.Second, "how often to poll for changes")
+	debounce := flags.Duration("debounce", time.Second, "how long changes must settle before checking")
+	autoLink := flags.Bool("auto-link", false, "link managed files whose live path is missing")
+	if err := parseNoPositional(flags, args); err != nil {
+		return err
+	}
+	if *interval <= 0 {
+		return fmt.Errorf("--interval must be positive")
+	}
+
+	repoPath, err := a.resolveRepoPath()
+	if err != nil {
+		return err
+	}
+	roots, err := configuredRoots()
+	if err != nil {
+		return err
+	}
+	ignoreMatchers, err := a.configuredIgnoreMatchers()
+	if err != nil {
+		return err
+	}
+
+	fmt.Fprintf(a.out, "watch: watching %s (Ctrl-C to stop)\n", repoPath)
+	if err := a.reportDrift(repoPath, roots, *autoLink); err != nil {
+		return err
+	}
+
+	last := watchSnapshot(repoPath, roots, ignoreMatchers)
+	var changedAt time.Time
+	ticker := time.NewTicker(*interval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			fmt.Fprintln(a.out, "watch: stopped.")
+			return nil
+		case <-ticker.C:
+		}
+
+		current := watchSnapshot(repoPath, roots, ignoreMatchers)
+		if !maps.Equal(current, last) {
+			last = current
+			changedAt = time.Now()
+			continue
+		}
+		if changedAt.IsZero() || time.Since(changedAt) < *debounce {
+			continue
+		}
+		changedAt = time.Time{}
+		if err := a.reportDrift(repoPath, roots, *autoLink); err != nil {
+			fmt.Fprintf(a.errOut, "watch: %v\n", err)
+		}
+	}
+}
+
+// reportDrift prints every managed file that is not correctly linked,
+// optionally linking the ones whose live path is missing.
+func (a *app) reportDrift(repoPath string, roots []managedRoot, autoLink bool) error {
+	managed, err := a.loadManagedFiles(repoPath)
+	if err != nil {
+		return err
+	}
+	cfg, _, err := loadCfgsConfig()
+	if err != nil {
+		return err
+	}
+	filter, err := newHostFilter(cfg)
+	if err != nil {
+		return err
+	}
+	classifier, err := a.newFileClassifier(repoPath, cfg)
+	if err != nil {
+		return err
+	}
+	secrets := classifier.secrets
+
+	drift := 0
+	for _, rel := range managed {
+		// Files doctor would skip on this machine are not drift either, and
+		// must never be auto-linked.
+		if filter.skip(rel) != "" {
+			continue
+		}
+		repoFile := filepath.Join(repoPath, filepath.FromSlash(rel))
+		liveFile := liveFilePath(roots, rel)
+		status := classifier.classify(rel, repoFile, liveFile)
+		if status == linkStatusLinked {
+			continue
+		}
+		if status == linkStatusMissing && autoLink && secrets.isSecret(rel) {
+			// A symlink would expose the ciphertext; decrypt instead.
+			if _, err := secrets.materialize(repoFile, liveFile, false); err == nil {
+				fmt.Fprintf(a.out, "watch: decrypted %s\n", rel)
+				continue
+			}
+		} else if status == linkStatusMissing && autoLink {
+			if err := linkLiveFile(repoPath, rel, repoFile, liveFile, classifier.strategy, cfg.LinkMode); err == nil {
+				fmt.Fprintf(a.out, "watch: linked %s\n", rel)
+				continue
+			}
+		}
+		drift++
+		fmt.Fprintf(a.out, "watch: drift %s (%s)\n", rel, status)
+	}
+	dirs, err := loadManagedDirs(repoPath)
+	if err != nil {
+		return err
+	}
+	for _, rel := range dirs {
+		if filter.skip(rel) != "" {
+			continue
+		}
+		repoDir := filepath.Join(repoPath, filepath.FromSlash(rel))
+		liveDir := liveFilePath(roots, rel)
+		status := classifyManagedDir(repoDir, liveDir)
+		if status == linkStatusLinked {
+			continue
+		}
+		if status == linkStatusMissing && autoLink {
+			if err := linkManagedDir(repoDir, liveDir, cfg.LinkMode); err == nil {
+				fmt.Fprintf(a.out, "watch: linked %s/\n", rel)
+				continue
+			}
+		}
+		drift++
+		fmt.Fprintf(a.out, "watch: drift %s/ (%s)\n", rel, status)
+	}
+	if drift == 0 {
+		fmt.Fprintf(a.out, "watch: %s all %d tracked file(s) and %d dir(s) linked\n", time.Now().Format("15:04:05"), len(managed), len(dirs))
+	}
+	return nil
+}
+
+// cmdStatus is a read-only summary of the repo path, how far the branch is
+// ahead of or behind its upstream as of the last fetch, every managed file's
+// link state, any dangling symlinks into the repo, and whether the repo has
+// uncommitted changes. With --exit-code the state is also encoded in the
+// exit status.
+func (a *app) cmdStatus(ctx context.Context, args []string) error {
+	flags := a.newFlagSet("status")
+	exitCode := flags.Bool("exit-code", false, "exit 2 on drift, 3 on uncommitted repo changes, 4 on unresolved or dangling links")
+	if err := parseNoPositional(flags, args); err != nil {
+		return err
+	}
+	repoPath, err := a.resolveRepoPath()
+	if err != nil {
+		return err
+	}
+	managed, err := a.loadManagedFiles(repoPath)
+	if err != nil {
+		return err
+	}
+	dirs, err := loadManagedDirs(repoPath)
+	if err != nil {
+		return err
+	}
+	roots, err := configuredRoots()
+	if err != nil {
+		return err
+	}
+	cfg, _, err := loadCfgsConfig()
+	if err != nil {
+		return err
+	}
+	filter, err := newHostFilter(cfg)
+	if err != nil {
+		return err
+	}
+	classifier, err := a.newFileClassifier(repoPath, cfg)
+	if err != nil {
+		return err
+	}
+
+	fmt.Fprintf(a.out, "repo: %s\n", repoPath)
+	ahead, behind, hasUpstream, err := a.gitAheadBehind(repoPath)
+	if err != nil {
+		return err
+	}
+	if hasUpstream {
+		fmt.Fprintf(a.out, "upstream: %d ahead, %d behind\n", ahead, behind)
+	} else {
+		fmt.Fprintln(a.out, "upstream: none (branch has no tracking branch)")
+	}
+	if len(dirs) > 0 {
+		fmt.Fprintf(a.out, "tracked: %d file(s), %d dir(s)\n", len(managed), len(dirs))
+	} else {
+		fmt.Fprintf(a.out, "tracked: %d file(s)\n", len(managed))
+	}
+
+	linked, drift, unresolved := 0, 0, 0
+	for _, rel := range managed {
+		if err := ctx.Err(); err != nil {
+			return err
+		}
+		// Like doctor, leave out files that do not apply to this machine,
+		// so they never count as drift.
+		if filter.skip(rel) != "" {
+			continue
+		}
+		repoFile := filepath.Join(repoPath, filepath.FromSlash(rel))
+		status := classifier.classify(rel, repoFile, liveFilePath(roots, rel))
+		switch status {
+		case linkStatusLinked:
+			linked++
+			continue
+		case linkStatusMissing, linkStatusCopy, linkStatusDiverged:
+			drift++
+		default:
+			unresolved++
+		}
+		fmt.Fprintf(a.out, "  %-16s %s\n", status, rel)
+	}
+	for _, rel := range dirs {
+		if filter.skip(rel) != "" {
+			continue
+		}
+		repoDir := filepath.Join(repoPath, filepath.FromSlash(rel))
+		status := classifyManagedDir(repoDir, liveFilePath(roots, rel))
+		switch status {
+		case linkStatusLinked:
+			linked++
+			continue
+		case linkStatusMissing:
+			// The only state doctor links without asking.
+			drift++
+		default:
+			unresolved++
+		}
+		fmt.Fprintf(a.out, "  %-16s %s/\n", status, rel)
+	}
+
+	ignoreMatchers, err := a.configuredIgnoreMatchers()
+	if err != nil {
+		return err
+	}
+	dangling, err := danglingRepoSymlinks(repoPath, roots, sliceToSet(managed), ignoreMatchers)
+	if err != nil {
+		return err
+	}
+	for _, rel := range dangling {
+		fmt.Fprintf(a.out, "  %-16s %s\n", "dangling", rel)
+	}
+	unresolved += len(dangling)
+
+	dirty, err := a.gitIsDirty(repoPath)
+	if err != nil {
+		return err
+	}
+	repoState := "clean"
+	if dirty {
+		repoState = "has uncommitted changes"
+	}
+	fmt.Fprintf(a.out, "status: %d linked, %d drifted, %d unresolved; repo %s\n", linked, drift, unresolved, repoState)
+
+	if !*exitCode {
+		return nil
+	}
+	switch {
+	case unresolved > 0:
+		return exitError{code: statusExitUnresolved}
+	case drift > 0:
+		return exitError{code: statusExitDrift}
+	case dirty:
+		return exitError{code: statusExitUncommitted}
+	}
+	return nil
+}
+
+// cmdAdopt is the opposite of `doctor --repo-wins`: for live regular files
+// that differ from the repo, it copies the live content over the repo file,
+// relinks the live path, and stages the change. A tracked directory whose
+// live path is a real directory is adopted whole.
+func (a *app) cmdAdopt(ctx context.Context, args []string) error {
+	flags := a.newFlagSet("adopt")
+	a.addYesFlags(flags)
+	a.addCommitMessageFlags(flags)
+	flags.BoolVar(&a.jsonOutput, "json", false, "print the report as JSON")
+	flags.BoolVar(&a.quiet, "quiet", false, "print the report only when something fails")
+	explicit, err := parseFlags(flags, args)
+	if err != nil {
+		return err
+	}
+	repoPath, err := a.resolveRepoPath()
+	if err != nil {
+		return err
+	}
+	managed, err := a.loadManagedFiles(repoPath)
+	if err != nil {
+		return err
+	}
+	roots, err := configuredRoots()
+	if err != nil {
+		return err
+	}
+
+	cfg, _, err := loadCfgsConfig()
+	if err != nil {
+		return err
+	}
+	classifier, err := a.newFileClassifier(repoPath, cfg)
+	if err != nil {
+		return err
+	}
+
+	var diverged []string
+	for _, rel := range managed {
+		repoFile := filepath.Join(repoPath, filepath.FromSlash(rel))
+		if classifier.classify(rel, repoFile, liveFilePath(roots, rel)) == linkStatusDiverged {
+			diverged = append(diverged, rel)
+		}
+	}
+	dirs, err := loadManagedDirs(repoPath)
+	if err != nil {
+		return err
+	}
+	for _, rel := range dirs {
+		repoDir := filepath.Join(repoPath, filepath.FromSlash(rel))
+		if classifyManagedDir(repoDir, liveFilePath(roots, rel)) == linkStatusDiverged {
+			diverged = append(diverged, rel)
+		}
+	}
+	if len(diverged) == 0 && len(explicit) == 0 {
+		fmt.Fprintln(a.out, "No diverged live files to adopt.")
+		return nil
+	}
+
+	selected, err := a.selectFiles(diverged, explicit, "adopt> ")
+	if err != nil {
+		return err
+	}
+	if len(selected) == 0 {
+		fmt.Fprintln(a.out, "No files selected.")
+		return nil
+	}
+
+	report := operationReport{}
+	for _, rel := range selected {
+		repoFile := filepath.Join(repoPath, filepath.FromSlash(rel))
+		liveFile := liveFilePath(roots, rel)
+
+		if slices.Contains(dirs, rel) {
+			// A real directory where the link should be: it replaces the
+			// repo directory whole.
+			if err := adoptLiveDir(repoFile, liveFile, cfg.LinkMode); err != nil {
+				report.failed = append(report.failed, fmt.Sprintf("%s/: %v", rel, err))
+				continue
+			}
+			report.changed = true
+			report.succeeded = append(report.succeeded, rel+"/")
+			continue
+		}
+		if err := a.adoptLiveFile(repoPath, rel, repoFile, liveFile, cfg.ReadonlyRepoFiles, classifier.secrets); err != nil {
+			report.failed = append(report.failed, fmt.Sprintf("%s: %v", rel, err))
+			continue
+		}
+		if classifier.secrets.isSecret(rel) {
+			// The live file stays the decrypted copy; only the repo holds
+			// ciphertext.
+			report.changed = true
+			report.succeeded = append(report.succeeded, rel+" (re-encrypted)")
+			continue
+		}
+		if err := os.Remove(liveFile); err != nil {
+			report.failed = append(report.failed, fmt.Sprintf("%s: repo updated but removing live file failed: %v", rel, err))
+			continue
+		}
+		if err := linkLiveFile(repoPath, rel, repoFile, liveFile, classifier.strategy, cfg.LinkMode); err != nil {
+			report.failed = append(report.failed, fmt.Sprintf("%s: repo updated but relinking the live path failed: %v", rel, err))
+			continue
+		}
+		report.changed = true
+		report.succeeded = append(report.succeeded, rel)
+	}
+
+	if err := a.emitOperationReport("adopt", report); err != nil {
+		return err
+	}
+	if report.changed {
+		return a.commitAndAskPush(ctx, repoPath, "adopt", len(report.succeeded))
+	}
+	return nil
+}
+
+// adoptLiveFile copies liveFile over repoFile, keeping readonly_repo_files
+// in effect, and stages the change. A secret is encrypted into the repo
+// instead, so plaintext never reaches git. The live file is left for the
+// caller to relink.
+func (a *app) adoptLiveFile(repoPath string, rel string, repoFile string, liveFile string, readonly bool, secrets *ageSecrets) error {
+	if readonly {
+		if err := addOwnerWriteBit(repoFile); err != nil {
+			return fmt.Errorf("make repo file writable: %v", err)
+		}
+	}
+	if secrets.isSecret(rel) {
+		if err := secrets.encrypt(liveFile, repoFile); err != nil {
+			return fmt.Errorf("encrypt live file into repo: %v", err)
+		}
+	} else if err := copyFile(liveFile, repoFile); err != nil {
+		return fmt.Errorf("copy live file into repo: %v", err)
+	}
+	if readonly {
+		if err := clearWriteBits(repoFile); err != nil {
+			return fmt.Errorf("make repo file read-only: %v", err)
+		}
+	}
+	if _, err := a.runCommand(repoPath, "git", "add", "--", rel); err != nil {
+		return fmt.Errorf("stage change: %v", err)
+	}
+	return nil
+}
+
+// cmdLog shows the repo's recent history, optionally scoped to one path.
+func (a *app) cmdLog(ctx context.Context, args []string) error {
+	_ = ctx
+	flags := a.newFlagSet("log")
+	count := flags.Int("n", 20, "show at most `count` commits")
+	positional, err := parseFlags(flags, args)
+	if err != nil {
+		return err
+	}
+	if len(positional) > 1 {
+		return fmt.Errorf("log: expected at most one path, got %d", len(positional))
+	}
+	if *count <= 0 {
+		return fmt.Errorf("-n must be positive")
+	}
+	repoPath, err := a.resolveRepoPath()
+	if err != nil {
+		return err
+	}
+
+	logArgs := []string{"--no-pager", "log", "--oneline", "-n", strconv.Itoa(*count)}
+	if len(positional) == 1 {
+		rel, err := normalizeManagedPath(positional[0])
+		if err != nil {
+			return fmt.Errorf("%s: %w", positional[0], err)
+		}
+		logArgs = append(logArgs, "--", rel)
+	}
+	return a.runInteractiveCommand(repoPath, "git", logArgs...)
+}
+
+// cmdRestore links every tracked file into place for a first run on a new
+// machine. Unlike doctor it is all-or-nothing: when any live path holds
+// different content, a foreign symlink, or something else unexpected, it
+// lists the conflicts and changes nothing.
+func (a *app) cmdRestore(ctx context.Context, args []string) error {
+	_ = ctx
+	flags := a.newFlagSet("restore")
+	flags.BoolVar(&a.jsonOutput, "json", false, "print the report as JSON")
+	flags.BoolVar(&a.quiet, "quiet", false, "print the report only when something fails")
+	if err := parseNoPositional(flags, args); err != nil {
+		return err
+	}
+	repoPath, err := a.resolveRepoPath()
+	if err != nil {
+		return err
+	}
+	managed, err := a.loadManagedFiles(repoPath)
+	if err != nil {
+		return err
+	}
+	dirs, err := loadManagedDirs(repoPath)
+	if err != nil {
+		return err
+	}
+	roots, err := configuredRoots()
+	if err != nil {
+		return err
+	}
+	cfg, _, err := loadCfgsConfig()
+	if err != nil {
+		return err
+	}
+	filter, err := newHostFilter(cfg)
+	if err != nil {
+		return err
+	}
+	classifier, err := a.newFileClassifier(repoPath, cfg)
+	if err != nil {
+		return err
+	}
+
+	statuses := map[string]linkStatus{}
+	var conflicts []string
+	for _, rel := range managed {
+		if filter.isExcluded(rel) {
+			continue
+		}
+		repoFile := filepath.Join(repoPath, filepath.FromSlash(rel))
+		status := classifier.classify(rel, repoFile, liveFilePath(roots, rel))
+		switch status {
+		case linkStatusLinked, linkStatusMissing, linkStatusCopy:
+			statuses[rel] = status
+		default:
+			conflicts = append(conflicts, fmt.Sprintf("%s (%s)", rel, status))
+		}
+	}
+	dirStatuses := map[string]linkStatus{}
+	for _, rel := range dirs {
+		if filter.isExcluded(rel) {
+			continue
+		}
+		repoDir := filepath.Join(repoPath, filepath.FromSlash(rel))
+		status := classifyManagedDir(repoDir, liveFilePath(roots, rel))
+		switch status {
+		case linkStatusLinked, linkStatusMissing:
+			dirStatuses[rel] = status
+		default:
+			conflicts = append(conflicts, fmt.Sprintf("%s/ (%s)", rel, status))
+		}
+	}
+	if len(conflicts) > 0 {
+		printReportBucket(a.errOut, "", "restore conflicts", conflicts)
+		return fmt.Errorf("restore refused: %d live path(s) conflict with the repo; resolve them or use `cfgs doctor`", len(conflicts))
+	}
+
+	report := operationReport{}
+	for _, rel := range managed {
+		status, ok := statuses[rel]
+		if !ok {
+			continue
+		}
+		repoFile := filepath.Join(repoPath, filepath.FromSlash(rel))
+		liveFile := liveFilePath(roots, rel)
+		if status == linkStatusLinked {
+			report.skipped = append(report.skipped, rel+": already linked")
+			continue
+		}
+		if classifier.secrets.isSecret(rel) {
+			// Decrypt rather than link the ciphertext into place.
+			if _, err := classifier.secrets.materialize(repoFile, liveFile, false); err != nil {
+				report.failed = append(report.failed, fmt.Sprintf("%s: %v", rel, err))
+				continue
+			}
+			report.changed = true
+			report.succeeded = append(report.succeeded, rel+" (decrypted secret)")
+			continue
+		}
+		if status == linkStatusCopy {
+			if err := os.Remove(liveFile); err != nil {
+				report.failed = append(report.failed, fmt.Sprintf("%s: remove live copy: %v", rel, err))
+				continue
+			}
+		}
+		if err := linkLiveFile(repoPath, rel, repoFile, liveFile, classifier.strategy, cfg.LinkMode); err != nil {
+			report.failed = append(report.failed, fmt.Sprintf("%s: link live path: %v", rel, err))
+			continue
+		}
+		report.changed = true
+		report.succeeded = append(report.succeeded, rel)
+	}
+	for _, rel := range dirs {
+		status, ok := dirStatuses[rel]
+		if !ok {
+			continue
+		}
+		if status == linkStatusLinked {
+			report.skipped = append(report.skipped, rel+"/: already linked")
+			continue
+		}
+		repoDir := filepath.Join(repoPath, filepath.FromSlash(rel))
+		if err := linkManagedDir(repoDir, liveFilePath(roots, rel), cfg.LinkMode); err != nil {
+			report.failed = append(report.failed, fmt.Sprintf("%s/: %v", rel, err))
+			continue
+		}
+		report.changed = true
+		report.succeeded = append(report.succeeded, rel+"/")
+	}
+
+	if err := a.emitOperationReport("restore", report); err != nil {
+		return err
+	}
+	if len(report.failed) > 0 {
+		return fmt.Errorf("restore failed for %d file(s)", len(report.failed))
+	}
+	return nil
+}
+
+// cmdList prints one line per managed file with its link status, followed by
+// the tracked directories with a trailing slash.
+func (a *app) cmdList(ctx context.Context, args []string) error {
+	_ = ctx
+	flags := a.newFlagSet("list")
+	statusOnly := flags.String("status-only", "", "only list files whose status is in the comma-separated `statuses`")
+	if err := parseNoPositional(flags, args); err != nil {
+		return err
+	}
+	var wanted map[linkStatus]bool
+	if *statusOnly != "" {
+		wanted = map[linkStatus]bool{}
+		for _, raw := range strings.Split(*statusOnly, ",") {
+			status := linkStatus(strings.TrimSpace(raw))
+			if !slices.Contains(allLinkStatuses, status) {
+				return fmt.Errorf("unknown status %q (want one of %s)", raw, joinLinkStatuses(allLinkStatuses))
+			}
+			wanted[status] = true
+		}
+	}
+
+	repoPath, err := a.resolveRepoPath()
+	if err != nil {
+		return err
+	}
+	managed, err := a.loadManagedFiles(repoPath)
+	if err != nil {
+		return err
+	}
+	roots, err := configuredRoots()
+	if err != nil {
+		return err
+	}
+	cfg, _, err := loadCfgsConfig()
+	if err != nil {
+		return err
+	}
+	classifier, err := a.newFileClassifier(repoPath, cfg)
+	if err != nil {
+		return err
+	}
+	for _, rel := range managed {
+		repoFile := filepath.Join(repoPath, filepath.FromSlash(rel))
+		status := classifier.classify(rel, repoFile, liveFilePath(roots, rel))
+		if wanted != nil && !wanted[status] {
+			continue
+		}
+		fmt.Fprintf(a.out, "%-16s %s\n", status, rel)
+	}
+	dirs, err := loadManagedDirs(repoPath)
+	if err != nil {
+		return err
+	}
+	for _, rel := range dirs {
+		repoDir := filepath.Join(repoPath, filepath.FromSlash(rel))
+		status := classifyManagedDir(repoDir, liveFilePath(roots, rel))
+		if wanted != nil && !wanted[status] {
+			continue
+		}
+		fmt.Fprintf(a.out, "%-16s %s/\n", status, rel)
+	}
+	return nil
+}
+
+func joinLinkStatuses(statuses []linkStatus) string {
+	names := make([]string, len(statuses))
+	for i, s := range statuses {
+		names[i] = string(s)
+	}
+	return strings.Join(names, ", ")
+}
+
+// danglingRepoSymlinks lists unmanaged live symlinks into the repo whose
+// target no longer exists, without removing them.
+func danglingRepoSymlinks(repoPath string, roots []managedRoot, managed map[string]struct{}, ignoreMatchers []globMatcher) ([]string, error) {
+	repoPath = filepath.Clean(repoPath)
+	roots, _ = nestedRepoRoots(roots, repoPath)
+	liveManaged := map[string]struct{}{}
+	for rel := range managed {
+		liveManaged[managedLiveRel(rel)] = struct{}{}
+	}
+	var dangling []string
+	err := walkRoots(roots, ignoreMatchers, func(fullPath string, rel string, d fs.DirEntry) error {
+		if d.Type()&os.ModeSymlink == 0 {
+			return nil
+		}
+		if _, ok := liveManaged[rel]; ok {
+			return nil
+		}
+		target, inRepo, err := symlinkRepoTarget(fullPath, repoPath)
+		if err != nil || !inRepo {
+			return nil
+		}
+		if _, err := os.Stat(target); errors.Is(err, fs.ErrNotExist) {
+			dangling = append(dangling, rel)
+		}
+		return nil
+	})
+	sort.Strings(dangling)
+	return dangling, err
+}
+
+// watchSnapshot fingerprints the repo working tree and the live roots so
+// polling can tell when something changed.
+func watchSnapshot(repoPath string, roots []managedRoot, ignoreMatchers []globMatcher) map[string]string {
+	snapshot := map[string]string{}
+	fingerprint := func(fullPath string) {
+		info, err := os.Lstat(fullPath)
+		if err != nil {
+			return
+		}
+		snapshot[fullPath] = fmt.Sprintf("%d:%d:%s", info.Size(), info.ModTime().UnixNano(), info.Mode())
+	}
+	_ = filepath.WalkDir(repoPath, func(fullPath string, d fs.DirEntry, walkErr error) error {
+		if walkErr != nil {
+			return nil
+		}
+		if d.IsDir() && d.Name() == ".git" {
+			return filepath.SkipDir
+		}
+		fingerprint(fullPath)
+		return nil
+	})
+	_ = walkRoots(roots, ignoreMatchers, func(fullPath string, rel string, d fs.DirEntry) error {
+		fingerprint(fullPath)
+		return nil
+	})
+	return snapshot
+}
+
+// classifyManagedFile reports the state of a managed file's live path
+// relative to its repo file. It never modifies anything.
+func classifyManagedFile(repoFile string, liveFile string) linkStatus {
+	repoInfo, err := os.Stat(repoFile)
+	if err != nil {
+		return linkStatusRepoMissing
+	}
+	if !repoInfo.Mode().IsRegular() {
+		return linkStatusUnsupported
+	}
+
+	liveInfo, err := os.Lstat(liveFile)
+	if err != nil {
+		if errors.Is(err, fs.ErrNotExist) {
+			return linkStatusMissing
+		}
+		return linkStatusUnsupported
+	}
+	if liveInfo.Mode()&os.ModeSymlink != 0 {
+		if ok, err := symlinkPointsTo(liveFile, repoFile); err == nil && ok {
+			return linkStatusLinked
+		}
+		return linkStatusForeignLink
+	}
+	if !liveInfo.Mode().IsRegular() {
+		return linkStatusUnsupported
+	}
+	same, err := filesEqual(repoFile, liveFile)
+	if err != nil {
+		return linkStatusUnsupported
+	}
+	if same {
+		return linkStatusCopy
+	}
+	return linkStatusDiverged
+}
+
+// fileClassifier is classifyManagedFile with the rules doctor reconciles by:
+// a secret is compared with its decrypted content rather than the
+// ciphertext, and under the hardlink and copy strategies an identical live
+// file is the correct placement. Every command that reports or acts on link
+// state classifies through it.
+type fileClassifier struct {
+	secrets  *ageSecrets
+	strategy string
+	// placed holds the repo hash each live copy was placed from, so a copy
+	// left behind by a repo update is not mistaken for a local edit.
+	placed map[string]string
+}
+
+func (a *app) newFileClassifier(repoPath string, cfg cfgsConfig) (*fileClassifier, error) {
+	secrets, err := a.loadAgeSecrets(cfg)
+	if err != nil {
+		return nil, err
+	}
+	c := &fileClassifier{secrets: secrets, strategy: cfg.linkStrategy()}
+	if c.strategy != linkStrategySymlink {
+		c.placed = loadPlacedFiles(repoPath)
+	}
+	return c, nil
+}
+
+// classify returns rel's status. linkStatusLinked means the live path is what
+// doctor would leave alone, and linkStatusCopy that doctor can replace it
+// without losing local edits: a duplicate of the repo file, a symlink to a
+// secret's ciphertext, or a placed copy the repo has since moved past.
+func (c *fileClassifier) classify(rel string, repoFile string, liveFile string) linkStatus {
+	if c.secrets.isSecret(rel) {
+		return c.classifySecret(repoFile, liveFile)
+	}
+	status := classifyManagedFile(repoFile, liveFile)
+	if c.strategy == linkStrategySymlink {
+		return status
+	}
+	switch status {
+	case linkStatusCopy:
+		return linkStatusLinked
+	case linkStatusDiverged:
+		if sum, err := fileSHA256(liveFile); err == nil && sum == c.placed[rel] {
+			return linkStatusCopy
+		}
+	}
+	return status
+}
+
+func (c *fileClassifier) classifySecret(repoFile string, liveFile string) linkStatus {
+	status := classifyManagedFile(repoFile, liveFile)
+	switch status {
+	case linkStatusLinked:
+		// A symlink exposes the ciphertext; doctor decrypts over it.
+		return linkStatusCopy
+	case linkStatusCopy, linkStatusDiverged:
+	default:
+		return status
+	}
+	plaintext, err := c.secrets.decryptToTemp(repoFile, filepath.Dir(liveFile))
+	if err != nil {
+		return linkStatusUnsupported
+	}
+	defer os.Remove(plaintext)
+	same, err := filesEqual(plaintext, liveFile)
+	switch {
+	case err != nil:
+		return linkStatusUnsupported
+	case same:
+		return linkStatusLinked
+	default:
+		return linkStatusDiverged
+	}
+}
+
+func (a *app) resolveRepoPath() (string, error) {
+	if override := strings.TrimSpace(a.repoOverride); override != "" {
+		repoPath, err := a.validateAndNormalizeRepo(expandPath(override))
+		if err != nil {
+			return "", fmt.Errorf("--repo: %w", err)
+		}
+		a.warnTrackedPathProblems(repoPath)
+		return repoPath, nil
+	}
+	if fromEnv := strings.TrimSpace(os.Getenv("CFGS_REPO")); fromEnv != "" {
+		repoPath, err := a.validateAndNormalizeRepo(expandPath(fromEnv))
+		if err != nil {
+			return "", fmt.Errorf("CFGS_REPO: %w", err)
+		}
+		a.warnTrackedPathProblems(repoPath)
+		return repoPath, nil
+	}
+
+	if cfg, ok, err := loadCfgsConfig(); err != nil {
+		return "", fmt.Errorf("read cfgs config: %w", err)
+	} else if ok {
+		repoPath, err := a.validateAndNormalizeRepo(cfg.RepoPath)
+		if err != nil {
+			return "", fmt.Errorf("cfgs config repo_path: %w", err)
+		}
+		a.warnTrackedPathProblems(repoPath)
+		return repoPath, nil
+	}
+
+	return "", fmt.Errorf("could not resolve repository (run `cfgs init`, pass --repo, set CFGS_REPO, or create $XDG_CONFIG_HOME/cfgs/config.json or the file named by CFGS_CONFIG)")
+}
+
+// warnTrackedPathProblems tells the user about index entries such as
+// "nvim/./init.lua" or "nvim//init.lua", which cfgs treats as their cleaned
+// form but should be renamed in the repo, and about entries that land on the
+// same path on this filesystem, which doctor would link and unlink in turn.
+func (a *app) warnTrackedPathProblems(repoPath string) {
+	entries, err := a.gitTrackedEntries(repoPath)
+	if err != nil {
+		return
+	}
+	_, nonCanonical := parseTrackedFiles(entries)
+	for _, raw := range nonCanonical {
+		fmt.Fprintf(a.errOut, "warning: repo tracks non-canonical path %q; rename it with `git mv`\n", raw)
+	}
+	for _, group := range collidingTrackedPaths(entries, caseInsensitiveFS()) {
+		fmt.Fprintf(a.errOut, "warning: tracked paths %s collide on this filesystem; rename all but one with `git mv`\n", strings.Join(group, ", "))
+	}
+}
+
+// commitAndAskPush commits all pending changes after action touched count
+// files. The message comes from -m/--message, then the commit_template
+// config, and otherwise from the editor. With --no-commit the changes are
+// only staged, so later commands already see the new files as tracked.
+func (a *app) commitAndAskPush(ctx context.Context, repoPath string, action string, count int) error {
+	if a.noCommit {
+		_, err := a.runCommand(repoPath, "git", "add", "-A")
+		return err
+	}
+	dirty, err := a.gitIsDirty(repoPath)
+	if err != nil {
+		return err
+	}
+	if !dirty {
+		return nil
+	}
+
+	message := a.commitMessage
+	if message == "" {
+		cfg, _, err := loadCfgsConfig()
+		if err != nil {
+			return err
+		}
+		message = expandCommitTemplate(cfg.CommitTemplate, action, count)
+	}
+
+	if _, err := a.runCommand(repoPath, "git", "add", "-A"); err != nil {
+		return err
+	}
+	if err := a.commitChanges(repoPath, message); err != nil {
+		return err
+	}
+
+	return a.askPush(ctx, repoPath)
+}
+
+func (a *app) askPush(ctx context.Context, repoPath string) error {
+	if !a.pushWithoutAsking {
+		pushNow, err := a.promptYesNo("Push commit now?", false)
+		if err != nil {
+			return err
+		}
+		if !pushNow {
+			return nil
+		}
+	}
+	remote, err := a.resolveRemote(repoPath)
+	if err != nil {
+		return err
+	}
+	return a.pushWithRetry(ctx, repoPath, remote, a.gitBranchOrDetached(repoPath))
+}
+
+// pushWithRetry pushes branch to remote, retrying transient network failures.
+// An empty branch means a detached HEAD, which is left to a plain git push.
+func (a *app) pushWithRetry(ctx context.Context, repoPath string, remote string, branch string) error {
+	cfg, _, err := loadCfgsConfig()
+	if err != nil {
+		return err
+	}
+	return a.runGitWithRetry(ctx, repoPath, cfg.networkRetries(), append([]string{"push"}, remoteRefArgs(remote, branch)...)...)
+}
+
+// remoteRefArgs names remote and branch explicitly for pull and push. On a
+// detached HEAD (empty branch) it returns nothing so git falls back to its
+// own defaults, as cfgs did before remotes were configurable.
+func remoteRefArgs(remote string, branch string) []string {
+	if branch == "" {
+		return nil
+	}
+	return []string{remote, branch}
 }
 
-func (a *app) resolveRepoPath() (string, error) {
-	if fromEnv := strings.TrimSpace(os.Getenv("CFGS_REPO")); fromEnv != "" {
-		repoPath, err := validateAndNormalizeRepo(expandPath(fromEnv))
-		if err != nil {
-			return "", fmt.Errorf("CFGS_REPO: %w", err)
+// gitRetryBaseDelay is the default app.gitRetryDelay.
+const gitRetryBaseDelay = time.Second
+
+// transientGitErrors are fragments of git output that mean the transport
+// failed, as opposed to a conflict or a rejected push, which retrying cannot
+// fix.
+var transientGitErrors = []string{
+	"could not resolve host",
+	"temporary failure in name resolution",
+	"connection timed out",
+	"operation timed out",
+	"connection refused",
+	"connection reset",
+	"network is unreachable",
+	"the remote end hung up unexpectedly",
+	"early eof",
+	"rpc failed",
+	"ssh: connect to host",
+	"gnutls_handshake() failed",
+	"ssl_connect",
+}
+
+func isTransientGitError(err error) bool {
+	text := strings.ToLower(err.Error())
+	for _, fragment := range transientGitErrors {
+		if strings.Contains(text, fragment) {
+			return true
 		}
-		return repoPath, nil
 	}
+	return false
+}
 
-	if cfg, ok, err := loadCfgsConfig(); err != nil {
-		return "", fmt.Errorf("read cfgs config: %w", err)
-	} else if ok {
-		repoPath, err := validateAndNormalizeRepo(cfg.RepoPath)
-		if err != nil {
-			return "", fmt.Errorf("cfgs config repo_path: %w", err)
+// runGitWithRetry runs a network-touching git command, retrying transient
+// failures up to retries more times with exponential backoff. Any other
+// failure is returned at once. git runs attached to the terminal so SSH
+// host-key and credential prompts reach the user.
+func (a *app) runGitWithRetry(ctx context.Context, repoPath string, retries int, args ...string) error {
+	delay := a.gitRetryDelay
+	for attempt := 1; ; attempt++ {
+		err := a.runGitInteractive(repoPath, args...)
+		if err == nil || attempt > retries || !isTransientGitError(err) {
+			return err
 		}
-		return repoPath, nil
+		fmt.Fprintf(a.errOut, "warning: git %s hit a network error; retrying in %s (%d/%d)\n", args[0], delay, attempt, retries)
+		timer := time.NewTimer(delay)
+		select {
+		case <-ctx.Done():
+			timer.Stop()
+			return ctx.Err()
+		case <-timer.C:
+		}
+		delay *= 2
 	}
+}
 
-	return "", fmt.Errorf("could not resolve repository (run `cfgs init`, set CFGS_REPO, or create $XDG_CONFIG_HOME/cfgs/config.json)")
+// runGitInteractive runs git in repoPath attached to the terminal. stderr is
+// also captured into the returned error so callers can classify failures.
+func (a *app) runGitInteractive(repoPath string, args ...string) error {
+	a.noteCommand("git", args)
+	var stderr bytes.Buffer
+	cmd := exec.Command("git", args...)
+	cmd.Dir = repoPath
+	cmd.Stdin = os.Stdin
+	cmd.Stdout = os.Stdout
+	cmd.Stderr = io.MultiWriter(os.Stderr, &stderr)
+	if err := a.runner.Run(cmd); err != nil {
+		return fmt.Errorf("git %s failed: %w\n%s", strings.Join(args, " "), err, strings.TrimSpace(stderr.String()))
+	}
+	return nil
 }
 
-func (a *app) commitAndAskPush(repoPath string) error {
-	dirty, err := gitIsDirty(repoPath)
+// resolveRemote picks the remote used for pull and push: the --remote flag,
+// then the configured remote, then the only remote. With several remotes and
+// nothing configured the user is asked to choose.
+func (a *app) resolveRemote(repoPath string) (string, error) {
+	remotes, err := a.gitRemotes(repoPath)
 	if err != nil {
-		return err
+		return "", err
 	}
-	if !dirty {
-		return nil
+	if len(remotes) == 0 {
+		return "", fmt.Errorf("repository has no remote configured")
 	}
 
-	if _, err := runCommand(repoPath, "git", "add", "-A"); err != nil {
-		return err
+	requested := strings.TrimSpace(a.remote)
+	if requested == "" {
+		cfg, _, err := loadCfgsConfig()
+		if err != nil {
+			return "", err
+		}
+		requested = strings.TrimSpace(cfg.Remote)
 	}
-	if err := commitWithEditor(repoPath); err != nil {
-		return err
+	if requested != "" {
+		if _, ok := sliceToSet(remotes)[requested]; !ok {
+			return "", fmt.Errorf("remote %q not found (available: %s)", requested, strings.Join(remotes, ", "))
+		}
+		return requested, nil
+	}
+	if len(remotes) == 1 {
+		return remotes[0], nil
 	}
 
-	pushNow, err := a.promptYesNo("Push commit now?", false)
+	choice, err := a.promptLine(fmt.Sprintf("Multiple remotes found (%s); remote to use", strings.Join(remotes, ", ")), "")
 	if err != nil {
-		return err
+		return "", err
 	}
-	if pushNow {
-		if _, err := runCommand(repoPath, "git", "push"); err != nil {
-			return err
-		}
+	if _, ok := sliceToSet(remotes)[choice]; !ok {
+		return "", fmt.Errorf("multiple remotes configured; set \"remote\" in cfgs config or pass --remote (available: %s)", strings.Join(remotes, ", "))
+	}
+	return choice, nil
+}
+
+// syncDiffOptions controls how sync displays what a pull brought in. The
+// zero value shows the full patch.
+type syncDiffOptions struct {
+	// stat shows git's diffstat instead of the patch.
+	stat bool
+	// noDiff prints only the hash range.
+	noDiff bool
+}
+
+// diffArgs returns the extra git diff/show flags for the chosen display.
+func (o syncDiffOptions) diffArgs() []string {
+	if o.stat {
+		return []string{"--stat"}
 	}
 	return nil
 }
 
-func (a *app) showSyncDiff(repoPath string, beforeHead string, beforeExists bool, afterHead string, afterExists bool) error {
+func (a *app) showSyncDiff(repoPath string, beforeHead string, beforeExists bool, afterHead string, afterExists bool, opts syncDiffOptions) error {
 	switch {
 	case beforeExists && afterExists && beforeHead == afterHead:
 		fmt.Fprintln(a.out, "sync: already up to date.")
 		return nil
 	case beforeExists && afterExists:
 		fmt.Fprintf(a.out, "sync: pulled updates (%s..%s)\n", shortHash(beforeHead), shortHash(afterHead))
-		return runInteractiveCommand(repoPath, "git", "--no-pager", "diff", beforeHead+".."+afterHead)
+		if opts.noDiff {
+			return nil
+		}
+		args := append([]string{"--no-pager", "diff"}, opts.diffArgs()...)
+		return a.runInteractiveCommand(repoPath, "git", append(args, beforeHead+".."+afterHead)...)
 	case !beforeExists && afterExists:
+		if opts.noDiff {
+			fmt.Fprintf(a.out, "sync: repository now has commits (%s)\n", shortHash(afterHead))
+			return nil
+		}
 		fmt.Fprintf(a.out, "sync: repository now has commits; showing latest commit (%s)\n", shortHash(afterHead))
-		return runInteractiveCommand(repoPath, "git", "--no-pager", "show", afterHead)
+		args := append([]string{"--no-pager", "show"}, opts.diffArgs()...)
+		return a.runInteractiveCommand(repoPath, "git", append(args, afterHead)...)
 	default:
 		fmt.Fprintln(a.out, "sync: no commits found.")
 		return nil
 	}
 }
 
-func (a *app) showCheckDiff(repoPath string) error {
-	fmt.Fprintln(a.out, "check: git status --short")
-	status, err := runCommand(repoPath, "git", "--no-pager", "status", "--short")
+// reportAheadBehind prints how the branch compares with its upstream after a
+// sync, so unpushed local commits are not forgotten.
+func (a *app) reportAheadBehind(repoPath string) error {
+	ahead, behind, hasUpstream, err := a.gitAheadBehind(repoPath)
+	if err != nil {
+		return err
+	}
+	switch {
+	case !hasUpstream:
+		fmt.Fprintln(a.out, "sync: branch has no upstream; cannot tell whether local commits are pushed.")
+	case ahead == 0 && behind == 0:
+		fmt.Fprintln(a.out, "sync: branch is even with its upstream.")
+	default:
+		fmt.Fprintf(a.out, "sync: branch is %d ahead, %d behind its upstream.\n", ahead, behind)
+	}
+	return nil
+}
+
+// showUncommittedDiff prints the repo's short status and its diff against
+// HEAD, labelling each section with command.
+func (a *app) showUncommittedDiff(repoPath string, command string) error {
+	fmt.Fprintf(a.out, "%s: git status --short\n", command)
+	status, err := a.runCommand(repoPath, "git", "--no-pager", "status", "--short")
 	if err != nil {
 		return err
 	}
@@ -684,25 +5037,25 @@ func (a *app) showCheckDiff(repoPath string) error {
 		fmt.Fprintln(a.out, status)
 	}
 
-	hasHead, err := repoHasHead(repoPath)
+	hasHead, err := a.repoHasHead(repoPath)
 	if err != nil {
 		return err
 	}
 
 	if hasHead {
-		fmt.Fprintln(a.out, "check: git diff HEAD")
-		return runInteractiveCommand(repoPath, "git", "--no-pager", "diff", "HEAD")
+		fmt.Fprintf(a.out, "%s: git diff HEAD\n", command)
+		return a.runInteractiveCommand(repoPath, "git", "--no-pager", "diff", "HEAD")
 	}
 
-	fmt.Fprintln(a.out, "check: git diff")
-	return runInteractiveCommand(repoPath, "git", "--no-pager", "diff")
+	fmt.Fprintf(a.out, "%s: git diff\n", command)
+	return a.runInteractiveCommand(repoPath, "git", "--no-pager", "diff")
 }
 
-func trackSelections(repoPath string, managed []string, selections []string) (operationReport, map[string]struct{}) {
-	xdg, err := xdgConfigHome()
+func (a *app) trackSelections(repoPath string, managed []string, selections []string, opts trackOptions) (operationReport, map[string]struct{}) {
+	roots, err := configuredRoots()
 	if err != nil {
 		return operationReport{
-			failed: []string{fmt.Sprintf("resolve XDG_CONFIG_HOME: %v", err)},
+			failed: []string{fmt.Sprintf("resolve roots: %v", err)},
 		}, sliceToSet(managed)
 	}
 
@@ -716,24 +5069,37 @@ func trackSelections(repoPath string, managed []string, selections []string) (op
 			continue
 		}
 		if _, exists := managedSet[rel]; exists {
+			a.tracef("%s: skip, already tracked", rel)
 			report.skipped = append(report.skipped, fmt.Sprintf("%s: already tracked", rel))
 			continue
 		}
 
-		liveFile := filepath.Join(xdg, filepath.FromSlash(rel))
+		liveFile := liveFilePath(roots, rel)
 		repoFile := filepath.Join(repoPath, filepath.FromSlash(rel))
 
 		liveInfo, err := os.Lstat(liveFile)
+		a.tracef("%s: lstat %s: %s", rel, liveFile, describeStat(liveInfo, err))
 		if err != nil {
 			report.failed = append(report.failed, fmt.Sprintf("%s: source file missing", rel))
 			continue
 		}
+		if liveInfo.Mode()&os.ModeSymlink != 0 {
+			report.skipped = append(report.skipped, describeSymlinkSource(rel, liveFile))
+			continue
+		}
 		if !liveInfo.Mode().IsRegular() {
 			report.skipped = append(report.skipped, fmt.Sprintf("%s: source is not a regular file", rel))
 			continue
 		}
+		if root, liveRel := rootForManagedPath(roots, rel); root.name == "config" {
+			for _, shadowed := range systemConfigCopies(liveRel) {
+				fmt.Fprintf(a.errOut, "warning: %s also exists as %s; the tracked %s takes precedence over it for programs that follow the XDG base directory spec\n", rel, shadowed, liveFile)
+			}
+		}
 
-		if _, err := os.Stat(repoFile); err == nil {
+		repoInfo, err := os.Stat(repoFile)
+		a.tracef("%s: stat %s: %s", rel, repoFile, describeStat(repoInfo, err))
+		if err == nil {
 			report.failed = append(report.failed, fmt.Sprintf("%s: repo file already exists", rel))
 			continue
 		} else if !errors.Is(err, fs.ErrNotExist) {
@@ -746,32 +5112,277 @@ func trackSelections(repoPath string, managed []string, selections []string) (op
 			continue
 		}
 
-		if err := moveFile(liveFile, repoFile); err != nil {
-			report.failed = append(report.failed, fmt.Sprintf("%s: move file: %v", rel, err))
+		if opts.secrets.isSecret(rel) {
+			a.tracef("%s: secret, encrypt %s -> %s and keep the live file", rel, liveFile, repoFile)
+			if err := opts.secrets.encrypt(liveFile, repoFile); err != nil {
+				_ = os.Remove(repoFile)
+				report.failed = append(report.failed, fmt.Sprintf("%s: encrypt: %v", rel, err))
+				continue
+			}
+		} else {
+			a.tracef("%s: move %s -> %s", rel, liveFile, repoFile)
+			if err := moveFile(liveFile, repoFile); err != nil {
+				report.failed = append(report.failed, fmt.Sprintf("%s: move file: %v", rel, err))
+				continue
+			}
+
+			if err := os.MkdirAll(filepath.Dir(liveFile), 0o755); err != nil {
+				_ = moveFile(repoFile, liveFile)
+				report.failed = append(report.failed, fmt.Sprintf("%s: restore after mkdir failure: %v", rel, err))
+				continue
+			}
+
+			if opts.linkStrategy == "" || opts.linkStrategy == linkStrategySymlink {
+				a.tracef("%s: symlink %s -> %s", rel, liveFile, repoFile)
+				if err := createSymlink(repoFile, liveFile, opts.linkMode); err != nil {
+					_ = moveFile(repoFile, liveFile)
+					report.failed = append(report.failed, fmt.Sprintf("%s: create symlink: %v", rel, err))
+					continue
+				}
+			} else {
+				a.tracef("%s: %s %s -> %s", rel, opts.linkStrategy, repoFile, liveFile)
+				if err := placeLiveFile(repoFile, liveFile, opts.linkStrategy); err != nil {
+					_ = os.Remove(liveFile)
+					_ = moveFile(repoFile, liveFile)
+					report.failed = append(report.failed, fmt.Sprintf("%s: place %s: %v", rel, opts.linkStrategy, err))
+					continue
+				}
+				if err := recordPlacedFile(repoPath, rel, repoFile); err != nil {
+					report.succeeded = append(report.succeeded, fmt.Sprintf("%s (could not record in %s manifest: %v)", rel, opts.linkStrategy, err))
+					managedSet[rel] = struct{}{}
+					report.changed = true
+					continue
+				}
+			}
+		}
+
+		managedSet[rel] = struct{}{}
+		report.changed = true
+		if opts.mode != 0 {
+			a.tracef("%s: chmod %04o %s and %s", rel, opts.mode, repoFile, liveFile)
+			if err := chmodTrackedFile(repoFile, liveFile, opts.mode); err != nil {
+				report.failed = append(report.failed, fmt.Sprintf("%s: tracked, but could not set mode %04o: %v", rel, opts.mode, err))
+				continue
+			}
+		}
+		if opts.readonlyRepoFiles {
+			if err := clearWriteBits(repoFile); err != nil {
+				report.succeeded = append(report.succeeded, fmt.Sprintf("%s (could not make repo file read-only: %v)", rel, err))
+				continue
+			}
+		}
+		report.succeeded = append(report.succeeded, rel)
+	}
+
+	return report, managedSet
+}
+
+// chmodTrackedFile applies mode to a newly tracked repo file and, unless the
+// live path is a symlink to it, to the live file as well: a copy or a
+// decrypted secret is a separate file that keeps its own permissions.
+func chmodTrackedFile(repoFile string, liveFile string, mode fs.FileMode) error {
+	if err := os.Chmod(repoFile, mode); err != nil {
+		return err
+	}
+	liveInfo, err := os.Lstat(liveFile)
+	if err != nil {
+		return err
+	}
+	if !liveInfo.Mode().IsRegular() {
+		return nil
+	}
+	return os.Chmod(liveFile, mode)
+}
+
+// trackDirectories moves each selected live directory into the repo whole and
+// replaces it with one directory symlink. It refuses directories that overlap
+// an already tracked directory or contain individually tracked files, ones
+// that are or contain ignored paths, since the whole tree would be committed,
+// and every directory under the hardlink and copy strategies, which only
+// place files. It returns the updated directory list.
+func trackDirectories(repoPath string, managed []string, dirs []string, selections []string, ignoreMatchers []globMatcher, strategy string, linkMode string) (operationReport, []string) {
+	roots, err := configuredRoots()
+	if err != nil {
+		return operationReport{
+			failed: []string{fmt.Sprintf("resolve roots: %v", err)},
+		}, dirs
+	}
+
+	report := operationReport{}
+	for _, raw := range selections {
+		rel, err := normalizeManagedPath(raw)
+		if err != nil {
+			report.failed = append(report.failed, fmt.Sprintf("%s: invalid path", raw))
+			continue
+		}
+		if slices.Contains(dirs, rel) {
+			report.skipped = append(report.skipped, fmt.Sprintf("%s: already tracked", rel))
+			continue
+		}
+		if parent, ok := managedDirFor(dirs, rel); ok {
+			report.failed = append(report.failed, fmt.Sprintf("%s: inside tracked directory %s", rel, parent))
+			continue
+		}
+		if containsManagedUnder(dirs, rel) {
+			report.failed = append(report.failed, fmt.Sprintf("%s: contains a tracked directory", rel))
+			continue
+		}
+		if containsManagedUnder(managed, rel) {
+			report.failed = append(report.failed, fmt.Sprintf("%s: contains individually tracked files; unlink or remove them first", rel))
+			continue
+		}
+		if strategy != "" && strategy != linkStrategySymlink {
+			report.failed = append(report.failed, fmt.Sprintf("%s: link_strategy %s cannot place a directory; add its files instead", rel, strategy))
+			continue
+		}
+
+		liveDir := liveFilePath(roots, rel)
+		repoDir := filepath.Join(repoPath, filepath.FromSlash(rel))
+
+		liveInfo, err := os.Lstat(liveDir)
+		if err != nil {
+			report.failed = append(report.failed, fmt.Sprintf("%s: source directory missing", rel))
+			continue
+		}
+		if liveInfo.Mode()&os.ModeSymlink != 0 {
+			report.skipped = append(report.skipped, describeSymlinkSource(rel, liveDir))
+			continue
+		}
+		if !liveInfo.IsDir() {
+			report.failed = append(report.failed, fmt.Sprintf("%s: source is not a directory", rel))
+			continue
+		}
+		_, liveRel := rootForManagedPath(roots, rel)
+		if ignored := firstIgnoredPath(liveDir, liveRel, ignoreMatchers); ignored == liveRel {
+			report.skipped = append(report.skipped, fmt.Sprintf("%s: matches ignore_globs", rel))
+			continue
+		} else if ignored != "" {
+			report.failed = append(report.failed, fmt.Sprintf("%s: contains ignored path %s; add its files instead", rel, ignored))
 			continue
 		}
 
-		if err := os.MkdirAll(filepath.Dir(liveFile), 0o755); err != nil {
-			_ = moveFile(repoFile, liveFile)
-			report.failed = append(report.failed, fmt.Sprintf("%s: restore after mkdir failure: %v", rel, err))
+		if _, err := os.Lstat(repoDir); err == nil {
+			report.failed = append(report.failed, fmt.Sprintf("%s: repo path already exists", rel))
+			continue
+		} else if !errors.Is(err, fs.ErrNotExist) {
+			report.failed = append(report.failed, fmt.Sprintf("%s: repo path check failed: %v", rel, err))
 			continue
 		}
 
-		if err := os.Symlink(repoFile, liveFile); err != nil {
-			_ = moveFile(repoFile, liveFile)
+		if err := os.MkdirAll(filepath.Dir(repoDir), 0o755); err != nil {
+			report.failed = append(report.failed, fmt.Sprintf("%s: create repo dir: %v", rel, err))
+			continue
+		}
+		if err := os.Rename(liveDir, repoDir); err != nil {
+			report.failed = append(report.failed, fmt.Sprintf("%s: move directory: %v", rel, err))
+			continue
+		}
+		if err := createSymlink(repoDir, liveDir, linkMode); err != nil {
+			_ = os.Rename(repoDir, liveDir)
 			report.failed = append(report.failed, fmt.Sprintf("%s: create symlink: %v", rel, err))
 			continue
 		}
 
-		managedSet[rel] = struct{}{}
+		dirs = append(dirs, rel)
 		report.changed = true
-		report.succeeded = append(report.succeeded, rel)
+		report.succeeded = append(report.succeeded, rel+"/")
 	}
 
-	return report, managedSet
+	sort.Strings(dirs)
+	return report, dirs
+}
+
+// firstIgnoredPath returns the first path at or below liveDir that the
+// ignore globs match, named relative to its root like liveRel, or "".
+func firstIgnoredPath(liveDir string, liveRel string, ignoreMatchers []globMatcher) string {
+	var found string
+	_ = filepath.WalkDir(liveDir, func(fullPath string, d fs.DirEntry, walkErr error) error {
+		if walkErr != nil {
+			return nil
+		}
+		sub, err := filepath.Rel(liveDir, fullPath)
+		if err != nil {
+			return nil
+		}
+		entryRel := path.Join(liveRel, filepath.ToSlash(sub))
+		if shouldIgnorePath(entryRel, d.IsDir(), ignoreMatchers) {
+			found = entryRel
+			return filepath.SkipAll
+		}
+		return nil
+	})
+	return found
+}
+
+// containsManagedUnder reports whether any managed path lives below dir.
+func containsManagedUnder(managed []string, dir string) bool {
+	for _, rel := range managed {
+		if strings.HasPrefix(managedLiveRel(rel), dir+"/") {
+			return true
+		}
+	}
+	return false
+}
+
+// describeSymlinkSource explains why a symlinked source was skipped and where
+// it points, so the user can decide whether to track the target instead.
+func describeSymlinkSource(rel string, liveFile string) string {
+	target, err := os.Readlink(liveFile)
+	if err != nil {
+		return fmt.Sprintf("%s: source is a symlink (unreadable: %v)", rel, err)
+	}
+	resolved := target
+	if !filepath.IsAbs(resolved) {
+		resolved = filepath.Join(filepath.Dir(liveFile), resolved)
+	}
+	info, err := os.Stat(resolved)
+	switch {
+	case err != nil:
+		return fmt.Sprintf("%s: source is a dangling symlink -> %s", rel, target)
+	case info.Mode().IsRegular():
+		return fmt.Sprintf("%s: source is a symlink -> %s", rel, target)
+	default:
+		return fmt.Sprintf("%s: source is a symlink to a non-regular file -> %s", rel, target)
+	}
+}
+
+// removeTrackedFile replaces liveFile with a standalone copy and deletes
+// repoFile with remove. When remove fails the live path is rolled back, so a
+// file is never left both copied out and still tracked.
+func removeTrackedFile(repoFile string, liveFile string, restoreWrite bool, remove func(string) error) error {
+	prior := captureLiveState(liveFile)
+	if err := ensureLiveCopyForRemove(repoFile, liveFile, restoreWrite); err != nil {
+		return err
+	}
+	if err := remove(repoFile); err != nil {
+		if rbErr := prior.restore(liveFile); rbErr != nil {
+			return fmt.Errorf("remove repo file: %v; INCONSISTENT STATE: live copy and repo file both exist and the previous live state could not be restored: %v", err, rbErr)
+		}
+		return fmt.Errorf("remove repo file: %w (live path restored to its previous state)", err)
+	}
+	return nil
+}
+
+// removeTrackedDir is removeTrackedFile for a tracked directory: the live
+// symlink becomes a copy of the repo directory, a real live directory is
+// kept as it is, and the repo directory is deleted.
+func removeTrackedDir(repoDir string, liveDir string, linkMode string) error {
+	switch status := classifyManagedDir(repoDir, liveDir); status {
+	case linkStatusLinked, linkStatusMissing:
+		if err := unlinkManagedDir(repoDir, liveDir, linkMode); err != nil {
+			return err
+		}
+	case linkStatusDiverged:
+	default:
+		return fmt.Errorf("live path is %s", status)
+	}
+	if err := os.RemoveAll(repoDir); err != nil {
+		return fmt.Errorf("remove repo directory: %w", err)
+	}
+	return nil
 }
 
-func ensureLiveCopyForRemove(repoFile string, liveFile string) error {
+func ensureLiveCopyForRemove(repoFile string, liveFile string, restoreWrite bool) error {
 	liveInfo, err := os.Lstat(liveFile)
 	if err != nil {
 		if !errors.Is(err, fs.ErrNotExist) {
@@ -780,10 +5391,10 @@ func ensureLiveCopyForRemove(repoFile string, liveFile string) error {
 		if err := os.MkdirAll(filepath.Dir(liveFile), 0o755); err != nil {
 			return fmt.Errorf("create live dir: %w", err)
 		}
-		if err := copyFile(repoFile, liveFile); err != nil {
+		if err := copyFileWith(repoFile, liveFile, copyFileOptions{preserveTimes: true}); err != nil {
 			return fmt.Errorf("copy repo file to live location: %w", err)
 		}
-		return nil
+		return restoreLiveWriteBit(liveFile, restoreWrite)
 	}
 
 	if liveInfo.Mode()&os.ModeSymlink != 0 {
@@ -797,120 +5408,284 @@ func ensureLiveCopyForRemove(repoFile string, liveFile string) error {
 		if err := os.Remove(liveFile); err != nil {
 			return fmt.Errorf("remove live symlink: %w", err)
 		}
-		if err := copyFile(repoFile, liveFile); err != nil {
+		if err := copyFileWith(repoFile, liveFile, copyFileOptions{preserveTimes: true}); err != nil {
 			return fmt.Errorf("copy repo file to live location: %w", err)
 		}
+		return restoreLiveWriteBit(liveFile, restoreWrite)
+	}
+
+	if !liveInfo.Mode().IsRegular() {
+		return fmt.Errorf("live path is not a regular file")
+	}
+	return nil
+}
+
+// liveState remembers what a live path looked like before remove touched it,
+// so a failed remove can be rolled back.
+type liveState struct {
+	missing    bool
+	linkTarget string
+}
+
+func captureLiveState(liveFile string) liveState {
+	info, err := os.Lstat(liveFile)
+	if err != nil {
+		return liveState{missing: errors.Is(err, fs.ErrNotExist)}
+	}
+	if info.Mode()&os.ModeSymlink == 0 {
+		return liveState{}
+	}
+	target, err := os.Readlink(liveFile)
+	if err != nil {
+		return liveState{}
+	}
+	return liveState{linkTarget: target}
+}
+
+// restore puts back a symlink or absence that ensureLiveCopyForRemove replaced
+// with a copy. A live regular file was never modified, so it is left alone.
+func (s liveState) restore(liveFile string) error {
+	if !s.missing && s.linkTarget == "" {
+		return nil
+	}
+	if err := os.Remove(liveFile); err != nil && !errors.Is(err, fs.ErrNotExist) {
+		return err
+	}
+	if s.linkTarget != "" {
+		return os.Symlink(s.linkTarget, liveFile)
+	}
+	return nil
+}
+
+func restoreLiveWriteBit(liveFile string, enabled bool) error {
+	if !enabled {
+		return nil
+	}
+	if err := addOwnerWriteBit(liveFile); err != nil {
+		return fmt.Errorf("restore write permission: %w", err)
+	}
+	return nil
+}
+
+type operationReportJSON struct {
+	Action    string   `json:"action"`
+	Changed   bool     `json:"changed"`
+	Succeeded []string `json:"succeeded"`
+	Skipped   []string `json:"skipped"`
+	Failed    []string `json:"failed"`
+}
+
+// emitOperationReport prints report as text, or as JSON with --json.
+func (a *app) emitOperationReport(action string, report operationReport) error {
+	if !a.jsonOutput {
+		printOperationReport(a.out, action, report, a.quiet)
 		return nil
 	}
+	data, err := json.MarshalIndent(operationReportJSON{
+		Action:    action,
+		Changed:   report.changed,
+		Succeeded: sortedBucket(report.succeeded),
+		Skipped:   sortedBucket(report.skipped),
+		Failed:    sortedBucket(report.failed),
+	}, "", "  ")
+	if err != nil {
+		return err
+	}
+	_, err = fmt.Fprintln(a.out, string(data))
+	return err
+}
+
+// printOperationReport prints every bucket, or with quiet only the failures,
+// printing nothing at all when none failed.
+func printOperationReport(w io.Writer, action string, report operationReport, quiet bool) {
+	if quiet {
+		if len(report.failed) > 0 {
+			fmt.Fprintf(w, "%s summary:\n", action)
+			printReportBucket(w, "  ", "failed", report.failed)
+		}
+		return
+	}
+	fmt.Fprintf(w, "%s summary:\n", action)
+	printReportBucket(w, "  ", "succeeded", report.succeeded)
+	printReportBucket(w, "  ", "skipped", report.skipped)
+	printReportBucket(w, "  ", "failed", report.failed)
+}
+
+// printDoctorReport prints the doctor buckets. With quiet only files needing
+// manual reconcile are listed, and nothing is printed when there are none.
+func printDoctorReport(w io.Writer, report doctorReport, summaryOnly bool, quiet bool) {
+	if quiet {
+		if len(report.requireManualResolve) > 0 {
+			printReportBucket(w, "", "require manual reconcile", manualResolveLines(report.requireManualResolve))
+		}
+		return
+	}
+	if summaryOnly {
+		fmt.Fprintf(w, "did not touch: %d\n", len(report.didNotTouch))
+		if len(report.skipped) > 0 {
+			fmt.Fprintf(w, "skipped: %d\n", len(report.skipped))
+		}
+		fmt.Fprintf(w, "replaced with symlink: %d\n", len(report.replacedWithSymlink))
+		fmt.Fprintf(w, "unlinked orphan symlink: %d\n", len(report.unlinkedOrphanSymlink))
+		if len(report.fixedPerms) > 0 {
+			fmt.Fprintf(w, "fixed permissions: %d\n", len(report.fixedPerms))
+		}
+		printReportBucket(w, "", "require manual reconcile", manualResolveLines(report.requireManualResolve))
+		return
+	}
+	printReportBucket(w, "", "did not touch", report.didNotTouch)
+	if len(report.skipped) > 0 {
+		printReportBucket(w, "", "skipped", report.skipped)
+	}
+	printReportBucket(w, "", "replaced with symlink", report.replacedWithSymlink)
+	printReportBucket(w, "", "unlinked orphan symlink", report.unlinkedOrphanSymlink)
+	if len(report.fixedPerms) > 0 {
+		printReportBucket(w, "", "fixed permissions", report.fixedPerms)
+	}
+	printReportBucket(w, "", "require manual reconcile", manualResolveLines(report.requireManualResolve))
+}
+
+// writeDivergedDiffs writes `git diff --no-index` output from the repo file to
+// the live file for every entry that differs from the repo.
+func (a *app) writeDivergedDiffs(w io.Writer, repoPath string, items []manualResolve) error {
+	roots, err := configuredRoots()
+	if err != nil {
+		return err
+	}
+	sorted := append([]manualResolve{}, items...)
+	sortManualResolves(sorted)
+	for _, item := range sorted {
+		if item.reason != reasonLiveDiffers {
+			continue
+		}
+		repoFile := filepath.Join(repoPath, filepath.FromSlash(item.path))
+		if err := a.writeFileDiff(w, item.path, repoFile, liveFilePath(roots, item.path)); err != nil {
+			return err
+		}
+	}
+	return nil
+}
 
-	if !liveInfo.Mode().IsRegular() {
-		return fmt.Errorf("live path is not a regular file")
+// writeFileDiff writes `git diff --no-index` output from repoFile to
+// liveFile.
+func (a *app) writeFileDiff(w io.Writer, rel string, repoFile string, liveFile string) error {
+	cmd := exec.Command("git", "--no-pager", "diff", "--no-index", "--", repoFile, liveFile)
+	cmd.Stdout = w
+	var stderr bytes.Buffer
+	cmd.Stderr = &stderr
+	var exitErr *exec.ExitError
+	if err := a.runner.Run(cmd); err != nil && !(errors.As(err, &exitErr) && exitErr.ExitCode() == 1) {
+		return fmt.Errorf("diff %s: %w\n%s", rel, err, strings.TrimSpace(stderr.String()))
 	}
 	return nil
 }
 
-func printOperationReport(w io.Writer, action string, report operationReport) {
-	fmt.Fprintf(w, "%s summary:\n", action)
+type doctorReportJSON struct {
+	Host                  string              `json:"host"`
+	Timestamp             string              `json:"timestamp"`
+	RepoPath              string              `json:"repo_path"`
+	DidNotTouch           []string            `json:"did_not_touch"`
+	Skipped               []string            `json:"skipped"`
+	ReplacedWithSymlink   []string            `json:"replaced_with_symlink"`
+	UnlinkedOrphanSymlink []string            `json:"unlinked_orphan_symlink"`
+	FixedPerms            []string            `json:"fixed_perms,omitempty"`
+	RequireManualResolve  []manualResolveJSON `json:"require_manual_resolve"`
+}
 
-	fmt.Fprintln(w, "  succeeded:")
-	if len(report.succeeded) == 0 {
-		fmt.Fprintln(w, "    (none)")
-	} else {
-		for _, item := range report.succeeded {
-			fmt.Fprintf(w, "    - %s\n", item)
-		}
-	}
+type manualResolveJSON struct {
+	Path   string `json:"path"`
+	Reason string `json:"reason"`
+}
 
-	fmt.Fprintln(w, "  skipped:")
-	if len(report.skipped) == 0 {
-		fmt.Fprintln(w, "    (none)")
-	} else {
-		for _, item := range report.skipped {
-			fmt.Fprintf(w, "    - %s\n", item)
-		}
+func writeDoctorReportJSON(w io.Writer, host string, repoPath string, report doctorReport) error {
+	data, err := json.MarshalIndent(doctorReportJSON{
+		Host:                  host,
+		Timestamp:             time.Now().UTC().Format(time.RFC3339),
+		RepoPath:              repoPath,
+		DidNotTouch:           sortedBucket(report.didNotTouch),
+		Skipped:               sortedBucket(report.skipped),
+		ReplacedWithSymlink:   sortedBucket(report.replacedWithSymlink),
+		UnlinkedOrphanSymlink: sortedBucket(report.unlinkedOrphanSymlink),
+		FixedPerms:            sortedBucket(report.fixedPerms),
+		RequireManualResolve:  manualResolveJSONItems(report.requireManualResolve),
+	}, "", "  ")
+	if err != nil {
+		return err
 	}
+	_, err = fmt.Fprintln(w, string(data))
+	return err
+}
 
-	fmt.Fprintln(w, "  failed:")
-	if len(report.failed) == 0 {
-		fmt.Fprintln(w, "    (none)")
-	} else {
-		for _, item := range report.failed {
-			fmt.Fprintf(w, "    - %s\n", item)
-		}
+func manualResolveJSONItems(items []manualResolve) []manualResolveJSON {
+	sorted := append([]manualResolve{}, items...)
+	sortManualResolves(sorted)
+	out := make([]manualResolveJSON, len(sorted))
+	for i, item := range sorted {
+		out[i] = manualResolveJSON{Path: item.path, Reason: item.reason}
 	}
+	return out
 }
 
-func printDoctorReport(w io.Writer, report doctorReport) {
-	fmt.Fprintln(w, "did not touch:")
-	if len(report.didNotTouch) == 0 {
-		fmt.Fprintln(w, "  (none)")
-	} else {
-		for _, item := range report.didNotTouch {
-			fmt.Fprintf(w, "  - %s\n", item)
-		}
+// manualResolveLines formats each entry as "path: reason" for text reports.
+func manualResolveLines(items []manualResolve) []string {
+	lines := make([]string, len(items))
+	for i, item := range items {
+		lines[i] = item.path + ": " + item.reason
 	}
+	return lines
+}
 
-	fmt.Fprintln(w, "replaced with symlink:")
-	if len(report.replacedWithSymlink) == 0 {
-		fmt.Fprintln(w, "  (none)")
-	} else {
-		for _, item := range report.replacedWithSymlink {
-			fmt.Fprintf(w, "  - %s\n", item)
+// sortManualResolves orders items by path, then reason, so reports built by
+// concurrent workers come out the same on every run.
+func sortManualResolves(items []manualResolve) {
+	sort.Slice(items, func(i, j int) bool {
+		if items[i].path != items[j].path {
+			return items[i].path < items[j].path
 		}
-	}
+		return items[i].reason < items[j].reason
+	})
+}
 
-	fmt.Fprintln(w, "unlinked orphan symlink:")
-	if len(report.unlinkedOrphanSymlink) == 0 {
-		fmt.Fprintln(w, "  (none)")
-	} else {
-		for _, item := range report.unlinkedOrphanSymlink {
-			fmt.Fprintf(w, "  - %s\n", item)
-		}
-	}
+// sortedBucket returns a sorted copy of items that encodes as [] when empty.
+func sortedBucket(items []string) []string {
+	sorted := append([]string{}, items...)
+	sort.Strings(sorted)
+	return sorted
+}
 
-	fmt.Fprintln(w, "require manual reconcile:")
-	if len(report.requireManualResolve) == 0 {
-		fmt.Fprintln(w, "  (none)")
-	} else {
-		for _, item := range report.requireManualResolve {
-			fmt.Fprintf(w, "  - %s\n", item)
-		}
+// printReportBucket prints one titled section of a report. Items are sorted by
+// their displayed text so repeated runs produce identical output.
+func printReportBucket(w io.Writer, indent string, title string, items []string) {
+	fmt.Fprintf(w, "%s%s:\n", indent, title)
+	if len(items) == 0 {
+		fmt.Fprintf(w, "%s  (none)\n", indent)
+		return
+	}
+	for _, item := range sortedBucket(items) {
+		fmt.Fprintf(w, "%s  - %s\n", indent, item)
 	}
 }
 
-func reconcileOrphanRepoSymlinks(repoPath string, xdg string, managed map[string]struct{}, ignoreMatchers []globMatcher) (doctorReport, error) {
+// reconcileOrphanRepoSymlinks handles live symlinks into the repo that no
+// longer belong to a managed file: dangling ones are removed and the rest are
+// replaced by a copy of their target, unless danglingOnly is set.
+func reconcileOrphanRepoSymlinks(repoPath string, roots []managedRoot, managed map[string]struct{}, ignoreMatchers []globMatcher, danglingOnly bool) (doctorReport, error) {
 	report := doctorReport{}
 	repoPath = filepath.Clean(repoPath)
+	roots, _ = nestedRepoRoots(roots, repoPath)
+	liveManaged := map[string]struct{}{}
+	for rel := range managed {
+		liveManaged[managedLiveRel(rel)] = struct{}{}
+	}
 
-	err := filepath.WalkDir(xdg, func(fullPath string, d fs.DirEntry, walkErr error) error {
-		if walkErr != nil {
-			return nil
-		}
-
-		rel, err := filepath.Rel(xdg, fullPath)
-		if err != nil {
-			return nil
-		}
-		rel = filepath.ToSlash(rel)
-
-		if d.IsDir() {
-			if shouldIgnorePath(rel, true, ignoreMatchers) {
-				return filepath.SkipDir
-			}
-			return nil
-		}
-		if shouldIgnorePath(rel, false, ignoreMatchers) {
-			return nil
-		}
+	onWalkError := func(rel string, err error) {
+		report.requireManualResolve = append(report.requireManualResolve, manualResolve{path: rel, reason: walkProblem(err)})
+	}
+	err := walkRootsReporting(roots, ignoreMatchers, onWalkError, func(fullPath string, rel string, d fs.DirEntry) error {
 		if d.Type()&os.ModeSymlink == 0 {
 			return nil
 		}
-
-		rel, err = normalizeManagedPath(rel)
-		if err != nil {
-			return nil
-		}
-		if _, ok := managed[rel]; ok {
+		if _, ok := liveManaged[rel]; ok {
 			return nil
 		}
 
@@ -919,31 +5694,39 @@ func reconcileOrphanRepoSymlinks(repoPath string, xdg string, managed map[string
 			return nil
 		}
 
+		if _, err := followSymlinks(target); symlinkProblem(err) != "" {
+			report.requireManualResolve = append(report.requireManualResolve, manualResolve{path: rel, reason: symlinkProblem(err)})
+			return nil
+		}
+
 		targetInfo, err := os.Stat(target)
 		if err != nil {
 			if errors.Is(err, fs.ErrNotExist) {
 				// Repo file is gone; remove dangling link as unlink behavior.
 				if err := os.Remove(fullPath); err != nil {
-					report.requireManualResolve = append(report.requireManualResolve, rel)
+					report.requireManualResolve = append(report.requireManualResolve, manualResolve{path: rel, reason: fmt.Sprintf("remove dangling symlink: %v", err)})
 					return nil
 				}
 				report.unlinkedOrphanSymlink = append(report.unlinkedOrphanSymlink, rel+" (removed dangling symlink)")
 				return nil
 			}
-			report.requireManualResolve = append(report.requireManualResolve, rel)
+			report.requireManualResolve = append(report.requireManualResolve, manualResolve{path: rel, reason: fmt.Sprintf("inspect orphan symlink target: %v", err)})
+			return nil
+		}
+		if danglingOnly {
 			return nil
 		}
 		if !targetInfo.Mode().IsRegular() {
-			report.requireManualResolve = append(report.requireManualResolve, rel)
+			report.requireManualResolve = append(report.requireManualResolve, manualResolve{path: rel, reason: "orphan symlink target is not a regular file"})
 			return nil
 		}
 
 		if err := os.Remove(fullPath); err != nil {
-			report.requireManualResolve = append(report.requireManualResolve, rel)
+			report.requireManualResolve = append(report.requireManualResolve, manualResolve{path: rel, reason: fmt.Sprintf("remove orphan symlink: %v", err)})
 			return nil
 		}
 		if err := copyFile(target, fullPath); err != nil {
-			report.requireManualResolve = append(report.requireManualResolve, rel)
+			report.requireManualResolve = append(report.requireManualResolve, manualResolve{path: rel, reason: fmt.Sprintf("copy orphan symlink target: %v", err)})
 			return nil
 		}
 		report.unlinkedOrphanSymlink = append(report.unlinkedOrphanSymlink, rel)
@@ -954,10 +5737,277 @@ func reconcileOrphanRepoSymlinks(repoPath string, xdg string, managed map[string
 	}
 
 	sort.Strings(report.unlinkedOrphanSymlink)
-	sort.Strings(report.requireManualResolve)
+	sortManualResolves(report.requireManualResolve)
 	return report, nil
 }
 
+// reconcileManagedDirs links each tracked directory with a single directory
+// symlink, relinking in relinkMode when it is set. A real live directory is
+// left for the user, since merging two trees is not something to guess at.
+func reconcileManagedDirs(repoPath string, roots []managedRoot, dirs []string, linkMode string, relinkMode string) doctorReport {
+	report := doctorReport{}
+	for _, rel := range dirs {
+		label := rel + "/"
+		repoDir := filepath.Join(repoPath, filepath.FromSlash(rel))
+		liveDir := liveFilePath(roots, rel)
+
+		switch classifyManagedDir(repoDir, liveDir) {
+		case linkStatusRepoMissing:
+			report.requireManualResolve = append(report.requireManualResolve, manualResolve{path: label, reason: "repo directory is missing or not a directory"})
+			continue
+		case linkStatusMissing:
+			if err := linkManagedDir(repoDir, liveDir, linkMode); err != nil {
+				report.requireManualResolve = append(report.requireManualResolve, manualResolve{path: label, reason: err.Error()})
+				continue
+			}
+			report.replacedWithSymlink = append(report.replacedWithSymlink, label)
+			continue
+		case linkStatusForeignLink:
+			report.requireManualResolve = append(report.requireManualResolve, manualResolve{path: label, reason: "live symlink does not point to the repo directory"})
+			continue
+		case linkStatusLinked:
+		default:
+			report.requireManualResolve = append(report.requireManualResolve, manualResolve{path: label, reason: "live path exists and is not linked to the repo"})
+			continue
+		}
+		if relinkMode == "" || symlinkStyle(liveDir) == relinkMode {
+			report.didNotTouch = append(report.didNotTouch, label)
+			continue
+		}
+		if err := os.Remove(liveDir); err != nil {
+			report.requireManualResolve = append(report.requireManualResolve, manualResolve{path: label, reason: fmt.Sprintf("remove symlink before relinking: %v", err)})
+			continue
+		}
+		if err := createSymlink(repoDir, liveDir, relinkMode); err != nil {
+			report.requireManualResolve = append(report.requireManualResolve, manualResolve{path: label, reason: fmt.Sprintf("create symlink: %v", err)})
+			continue
+		}
+		report.replacedWithSymlink = append(report.replacedWithSymlink, label+" (relinked as "+relinkMode+")")
+	}
+	return report
+}
+
+// classifyManagedDir is classifyManagedFile for a tracked directory, whose
+// live path should be one symlink to the repo directory. A real live
+// directory is linkStatusDiverged.
+func classifyManagedDir(repoDir string, liveDir string) linkStatus {
+	repoInfo, err := os.Stat(repoDir)
+	if err != nil || !repoInfo.IsDir() {
+		return linkStatusRepoMissing
+	}
+	liveInfo, err := os.Lstat(liveDir)
+	switch {
+	case errors.Is(err, fs.ErrNotExist):
+		return linkStatusMissing
+	case err != nil:
+		return linkStatusUnsupported
+	case liveInfo.Mode()&os.ModeSymlink != 0:
+		if ok, err := symlinkPointsTo(liveDir, repoDir); err == nil && ok {
+			return linkStatusLinked
+		}
+		return linkStatusForeignLink
+	case liveInfo.IsDir():
+		return linkStatusDiverged
+	default:
+		return linkStatusUnsupported
+	}
+}
+
+// linkManagedDir creates the directory symlink for a tracked directory whose
+// live path is missing.
+func linkManagedDir(repoDir string, liveDir string, linkMode string) error {
+	if err := os.MkdirAll(filepath.Dir(liveDir), 0o755); err != nil {
+		return fmt.Errorf("create parent directory: %v", err)
+	}
+	if err := createSymlink(repoDir, liveDir, linkMode); err != nil {
+		return fmt.Errorf("create symlink: %v", err)
+	}
+	return nil
+}
+
+// unlinkManagedDir replaces the live symlink of a tracked directory, or a
+// missing live path, with a copy of the repo directory. On failure the
+// symlink is put back.
+func unlinkManagedDir(repoDir string, liveDir string, linkMode string) error {
+	switch status := classifyManagedDir(repoDir, liveDir); status {
+	case linkStatusLinked:
+		if err := os.Remove(liveDir); err != nil {
+			return fmt.Errorf("remove live symlink: %w", err)
+		}
+	case linkStatusMissing:
+		if err := os.MkdirAll(filepath.Dir(liveDir), 0o755); err != nil {
+			return fmt.Errorf("create live dir: %w", err)
+		}
+		linkMode = ""
+	default:
+		return fmt.Errorf("live path is %s", status)
+	}
+	if err := copyTree(repoDir, liveDir); err != nil {
+		_ = os.RemoveAll(liveDir)
+		if linkMode != "" {
+			_ = createSymlink(repoDir, liveDir, linkMode)
+		}
+		return fmt.Errorf("copy repo directory to live location: %w", err)
+	}
+	return nil
+}
+
+// adoptLiveDir is adopt for a tracked directory: a real live directory
+// replaces the repo directory and is linked back. The old repo directory is
+// kept aside until the new link is in place.
+func adoptLiveDir(repoDir string, liveDir string, linkMode string) error {
+	aside, err := os.MkdirTemp(filepath.Dir(repoDir), ".cfgs-adopt-*")
+	if err != nil {
+		return fmt.Errorf("make room for the live directory: %v", err)
+	}
+	defer os.RemoveAll(aside)
+	old := filepath.Join(aside, filepath.Base(repoDir))
+	if err := os.Rename(repoDir, old); err != nil {
+		return fmt.Errorf("move repo directory aside: %v", err)
+	}
+	if err := os.Rename(liveDir, repoDir); err != nil {
+		_ = os.Rename(old, repoDir)
+		return fmt.Errorf("move live directory into repo: %v", err)
+	}
+	if err := createSymlink(repoDir, liveDir, linkMode); err != nil {
+		_ = os.Rename(repoDir, liveDir)
+		_ = os.Rename(old, repoDir)
+		return fmt.Errorf("create symlink: %v", err)
+	}
+	return nil
+}
+
+// copyTree copies the directory src to dst, which must not exist, keeping
+// file modes and times and recreating symlinks as they are.
+func copyTree(src string, dst string) error {
+	return filepath.WalkDir(src, func(p string, d fs.DirEntry, walkErr error) error {
+		if walkErr != nil {
+			return walkErr
+		}
+		rel, err := filepath.Rel(src, p)
+		if err != nil {
+			return err
+		}
+		target := filepath.Join(dst, rel)
+		switch {
+		case d.IsDir():
+			info, err := d.Info()
+			if err != nil {
+				return err
+			}
+			return os.Mkdir(target, info.Mode().Perm()|0o700)
+		case d.Type()&os.ModeSymlink != 0:
+			link, err := os.Readlink(p)
+			if err != nil {
+				return err
+			}
+			return os.Symlink(link, target)
+		default:
+			return copyFileWith(p, target, copyFileOptions{preserveTimes: true})
+		}
+	})
+}
+
+var (
+	errSymlinkCycle        = errors.New("symlink cycle")
+	errSymlinkChainTooLong = errors.New("symlink chain too long")
+)
+
+// maxSymlinkHops bounds how many links followSymlinks resolves, matching the
+// usual kernel limit.
+const maxSymlinkHops = 40
+
+// followSymlinks resolves the chain of symlinks starting at p one hop at a
+// time, returning errSymlinkCycle when a link repeats, or a parent directory
+// loops, and errSymlinkChainTooLong past maxSymlinkHops, instead of relying on
+// opaque ELOOP errors.
+func followSymlinks(p string) (string, error) {
+	seen := map[string]struct{}{}
+	for hops := 0; ; hops++ {
+		if hops > maxSymlinkHops {
+			return "", errSymlinkChainTooLong
+		}
+		if _, ok := seen[p]; ok {
+			return "", errSymlinkCycle
+		}
+		seen[p] = struct{}{}
+
+		info, err := os.Lstat(p)
+		if errors.Is(err, syscall.ELOOP) {
+			return "", errSymlinkCycle
+		}
+		if err != nil {
+			if hops > 0 && errors.Is(err, syscall.ENOTDIR) {
+				return p, fmt.Errorf("%w: a parent of %s is not a directory", errBrokenIntermediateLink, p)
+			}
+			return p, err
+		}
+		if info.Mode()&os.ModeSymlink == 0 {
+			return p, nil
+		}
+		target, err := os.Readlink(p)
+		if err != nil {
+			return "", err
+		}
+		if !filepath.IsAbs(target) {
+			target = filepath.Join(filepath.Dir(p), target)
+		}
+		p = filepath.Clean(target)
+	}
+}
+
+// errBrokenIntermediateLink marks a chain whose next hop runs through a path
+// component that is not a directory.
+var errBrokenIntermediateLink = errors.New("broken intermediate link")
+
+// symlinkProblem describes why a symlink chain cannot be resolved, or returns
+// "" when err is nil or not about the chain itself (e.g. a dangling target).
+func symlinkProblem(err error) string {
+	switch {
+	case errors.Is(err, errSymlinkCycle), errors.Is(err, syscall.ELOOP):
+		return "symlink cycle"
+	case errors.Is(err, errSymlinkChainTooLong):
+		return fmt.Sprintf("symlink chain longer than %d hops", maxSymlinkHops)
+	case errors.Is(err, errBrokenIntermediateLink):
+		return err.Error()
+	}
+	return ""
+}
+
+// walkProblem describes an entry the root walk could not read.
+func walkProblem(err error) string {
+	if problem := symlinkProblem(err); problem != "" {
+		return problem
+	}
+	return fmt.Sprintf("cannot read: %v", err)
+}
+
+// pruneVanishedRepoLink removes liveFile when it is a symlink into repoPath
+// whose target no longer exists. It reports false, leaving the path alone,
+// for anything else.
+func pruneVanishedRepoLink(liveFile string, repoPath string) (bool, error) {
+	liveInfo, err := os.Lstat(liveFile)
+	if err != nil || liveInfo.Mode()&os.ModeSymlink == 0 {
+		return false, nil
+	}
+	target, inRepo, err := symlinkRepoTarget(liveFile, filepath.Clean(repoPath))
+	if err != nil || !inRepo {
+		return false, nil
+	}
+	if _, err := os.Lstat(target); !errors.Is(err, fs.ErrNotExist) {
+		return false, nil
+	}
+	if err := os.Remove(liveFile); err != nil {
+		return false, err
+	}
+	return true, nil
+}
+
+// symlinkRepoTarget reports whether linkPath points into repoPath and returns
+// the target spelled under repoPath. Directory links on either side, such as
+// /tmp -> /private/tmp on macOS, are resolved the same way symlinkPointsTo
+// resolves them; the target's last component is left alone so dangling links
+// and link chains can still be inspected.
 func symlinkRepoTarget(linkPath string, repoPath string) (string, bool, error) {
 	rawTarget, err := os.Readlink(linkPath)
 	if err != nil {
@@ -970,11 +6020,45 @@ func symlinkRepoTarget(linkPath string, repoPath string) (string, bool, error) {
 	}
 	targetPath = filepath.Clean(targetPath)
 
-	withinRepo, err := pathWithin(repoPath, targetPath)
-	if err != nil || !withinRepo {
+	targetDir, err := resolvePath(filepath.Dir(targetPath))
+	if err != nil {
+		return "", false, err
+	}
+	resolvedRepo, err := resolvePath(repoPath)
+	if err != nil {
+		return "", false, err
+	}
+	rel, err := filepath.Rel(resolvedRepo, filepath.Join(targetDir, filepath.Base(targetPath)))
+	if err != nil || rel == ".." || strings.HasPrefix(rel, ".."+string(filepath.Separator)) {
 		return "", false, err
 	}
-	return targetPath, true, nil
+	return filepath.Join(filepath.Clean(repoPath), rel), true, nil
+}
+
+// resolvePath makes p absolute and resolves symlinks in its longest existing
+// prefix, so two spellings of the same location compare equal even when the
+// tail of the path does not exist yet.
+func resolvePath(p string) (string, error) {
+	abs, err := filepath.Abs(p)
+	if err != nil {
+		return "", err
+	}
+	var missing []string
+	for current := abs; ; {
+		resolved, err := filepath.EvalSymlinks(current)
+		if err == nil {
+			return filepath.Join(append([]string{resolved}, missing...)...), nil
+		}
+		if !errors.Is(err, fs.ErrNotExist) {
+			return "", err
+		}
+		parent := filepath.Dir(current)
+		if parent == current {
+			return abs, nil
+		}
+		missing = append([]string{filepath.Base(current)}, missing...)
+		current = parent
+	}
 }
 
 func pathWithin(base string, candidate string) (bool, error) {
@@ -1004,7 +6088,144 @@ func requireCommands(commands ...string) error {
 	return nil
 }
 
-func runCommand(dir string, name string, args ...string) (string, error) {
+// ageSecrets encrypts and decrypts the managed files matched by secret_globs.
+// A nil *ageSecrets treats no file as secret.
+type ageSecrets struct {
+	runner    commandRunner
+	matchers  []globMatcher
+	recipient string
+	identity  string
+}
+
+// loadAgeSecrets returns nil when cfg declares no secret_globs; otherwise age
+// must be installed.
+func (a *app) loadAgeSecrets(cfg cfgsConfig) (*ageSecrets, error) {
+	if len(cfg.SecretGlobs) == 0 {
+		return nil, nil
+	}
+	if err := requireCommands("age"); err != nil {
+		return nil, err
+	}
+	matchers, err := compileGlobMatchers(cfg.SecretGlobs)
+	if err != nil {
+		return nil, fmt.Errorf("secret_globs: %w", err)
+	}
+	return &ageSecrets{
+		runner:    a.runner,
+		matchers:  matchers,
+		recipient: strings.TrimSpace(cfg.AgeRecipient),
+		identity:  expandPath(cfg.AgeIdentity),
+	}, nil
+}
+
+// isSecret matches secret_globs against the live path, so host overlays and
+// host variants of a secret are secrets too.
+func (s *ageSecrets) isSecret(rel string) bool {
+	return s != nil && shouldIgnorePath(managedLiveRel(rel), false, s.matchers)
+}
+
+// encrypt writes src, armored and encrypted to the configured recipient, to
+// dst.
+func (s *ageSecrets) encrypt(src string, dst string) error {
+	if s.recipient == "" {
+		return fmt.Errorf("age_recipient is not configured")
+	}
+	_, err := s.runner.Capture("", "age", "--encrypt", "--armor", "--recipient", s.recipient, "--output", dst, src)
+	return err
+}
+
+// decrypt writes the plaintext of src to dst using the configured identity.
+func (s *ageSecrets) decrypt(src string, dst string) error {
+	if s.identity == "" {
+		return fmt.Errorf("age_identity is not configured")
+	}
+	_, err := s.runner.Capture("", "age", "--decrypt", "--identity", s.identity, "--output", dst, src)
+	return err
+}
+
+// materialize decrypts repoFile to liveFile with mode 0600. A live copy that
+// already matches is left alone and reported with an empty note; a differing
+// one is only replaced when overwrite is set. A live symlink is replaced only
+// when it points at the ciphertext.
+func (s *ageSecrets) materialize(repoFile string, liveFile string, overwrite bool) (string, error) {
+	if err := os.MkdirAll(filepath.Dir(liveFile), 0o755); err != nil {
+		return "", fmt.Errorf("create parent directory: %v", err)
+	}
+	tmpPath, err := s.decryptToTemp(repoFile, filepath.Dir(liveFile))
+	if err != nil {
+		return "", err
+	}
+	defer os.Remove(tmpPath)
+
+	note := " (decrypted secret)"
+	liveInfo, err := os.Lstat(liveFile)
+	switch {
+	case errors.Is(err, fs.ErrNotExist):
+	case err != nil:
+		return "", fmt.Errorf("inspect live path: %v", err)
+	case liveInfo.Mode()&os.ModeSymlink != 0:
+		if ok, err := symlinkPointsTo(liveFile, repoFile); err != nil || !ok {
+			return "", fmt.Errorf("live symlink does not point to the repo file")
+		}
+		note = " (replaced ciphertext link with decrypted secret)"
+	case !liveInfo.Mode().IsRegular():
+		return "", fmt.Errorf("live path is neither a regular file nor a symlink")
+	default:
+		same, err := filesEqual(tmpPath, liveFile)
+		if err != nil {
+			return "", fmt.Errorf("compare with decrypted repo copy: %v", err)
+		}
+		if same {
+			return "", nil
+		}
+		if !overwrite {
+			return "", errors.New(reasonSecretDiffers)
+		}
+		note = " (overwrote diverged live secret)"
+	}
+	if err := os.Rename(tmpPath, liveFile); err != nil {
+		return "", fmt.Errorf("install decrypted copy: %v", err)
+	}
+	return note, nil
+}
+
+// decryptToTemp decrypts repoFile into a new owner-only file in dir and
+// returns its path. The caller removes it.
+func (s *ageSecrets) decryptToTemp(repoFile string, dir string) (string, error) {
+	tmp, err := os.CreateTemp(dir, ".cfgs-secret-*")
+	if err != nil {
+		return "", fmt.Errorf("create decrypted copy: %v", err)
+	}
+	tmpPath := tmp.Name()
+	tmp.Close()
+	if err := s.decrypt(repoFile, tmpPath); err != nil {
+		os.Remove(tmpPath)
+		return "", fmt.Errorf("decrypt: %v", err)
+	}
+	if err := os.Chmod(tmpPath, 0o600); err != nil {
+		os.Remove(tmpPath)
+		return "", fmt.Errorf("restrict decrypted copy: %v", err)
+	}
+	return tmpPath, nil
+}
+
+// commandRunner starts every external program cfgs uses, so git-dependent
+// logic can run against a fake instead of real binaries.
+type commandRunner interface {
+	// Capture runs name in dir and returns its trimmed combined output. On
+	// failure the error includes that output.
+	Capture(dir string, name string, args ...string) (string, error)
+	// Interactive runs name in dir attached to the terminal.
+	Interactive(dir string, name string, args ...string) error
+	// Run runs a prepared command whose stdio or environment the caller
+	// wired up itself.
+	Run(cmd *exec.Cmd) error
+}
+
+// execRunner is the commandRunner that runs real processes.
+type execRunner struct{}
+
+func (execRunner) Capture(dir string, name string, args ...string) (string, error) {
 	cmd := exec.Command(name, args...)
 	if dir != "" {
 		cmd.Dir = dir
@@ -1016,7 +6237,7 @@ func runCommand(dir string, name string, args ...string) (string, error) {
 	return strings.TrimSpace(string(output)), nil
 }
 
-func runInteractiveCommand(dir string, name string, args ...string) error {
+func (execRunner) Interactive(dir string, name string, args ...string) error {
 	cmd := exec.Command(name, args...)
 	if dir != "" {
 		cmd.Dir = dir
@@ -1030,30 +6251,159 @@ func runInteractiveCommand(dir string, name string, args ...string) error {
 	return nil
 }
 
-func commitWithEditor(repoPath string) error {
+func (execRunner) Run(cmd *exec.Cmd) error {
+	return cmd.Run()
+}
+
+func (a *app) runCommand(dir string, name string, args ...string) (string, error) {
+	a.noteCommand(name, args)
+	return a.runner.Capture(dir, name, args...)
+}
+
+func (a *app) runInteractiveCommand(dir string, name string, args ...string) error {
+	a.noteCommand(name, args)
+	return a.runner.Interactive(dir, name, args...)
+}
+
+// indexChangingGitCommands are the git subcommands after which the cached
+// tracked-file list may be stale.
+var indexChangingGitCommands = []string{
+	"add", "am", "apply", "checkout", "cherry-pick", "clone", "commit", "init", "merge",
+	"mv", "pull", "rebase", "reset", "restore", "revert", "rm", "stash", "switch",
+}
+
+// noteCommand drops the tracked-file cache before a git command that can
+// change the index or move HEAD.
+func (a *app) noteCommand(name string, args []string) {
+	if name != "git" || a.trackedCache == nil {
+		return
+	}
+	if slices.Contains(indexChangingGitCommands, gitSubcommand(args)) {
+		a.trackedCache = nil
+	}
+}
+
+// gitSubcommand returns the subcommand in git's args, skipping global
+// options such as -c key=value and -C dir.
+func gitSubcommand(args []string) string {
+	for i := 0; i < len(args); i++ {
+		switch arg := args[i]; {
+		case arg == "-c" || arg == "-C":
+			i++
+		case strings.HasPrefix(arg, "-"):
+		default:
+			return arg
+		}
+	}
+	return ""
+}
+
+// expandCommitTemplate fills {{action}} and {{count}} in template; an empty
+// template yields an empty message.
+func expandCommitTemplate(template string, action string, count int) string {
+	return strings.NewReplacer("{{action}}", action, "{{count}}", strconv.Itoa(count)).Replace(strings.TrimSpace(template))
+}
+
+// commitChanges commits with message, or through the editor when message is
+// empty. extraArgs go last so they may end with a "--" pathspec.
+func (a *app) commitChanges(repoPath string, message string, extraArgs ...string) error {
+	if message == "" {
+		return a.commitWithEditor(repoPath, extraArgs...)
+	}
+	commitArgs, err := gitCommitArgs("commit", append([]string{"-m", message}, extraArgs...)...)
+	if err != nil {
+		return err
+	}
+	return a.runInteractiveCommand(repoPath, "git", commitArgs...)
+}
+
+func (a *app) commitWithEditor(repoPath string, extraArgs ...string) error {
 	fmt.Println("Opening editor for commit message...")
-	return runInteractiveCommand(repoPath, "git", "commit")
+	commitArgs, err := gitCommitArgs("commit", extraArgs...)
+	if err != nil {
+		return err
+	}
+	return a.runInteractiveCommand(repoPath, "git", commitArgs...)
+}
+
+// gitCommitArgs builds the git arguments for a commit-creating subcommand,
+// adding commitSigningArgs when sign_commits is configured.
+func gitCommitArgs(subcommand string, args ...string) ([]string, error) {
+	cfg, _, err := loadCfgsConfig()
+	if err != nil {
+		return nil, err
+	}
+	out := append([]string{subcommand}, commitSigningArgs(cfg)...)
+	return append(out, args...), nil
+}
+
+// commitSigningArgs is the signing option shared by commit, revert and pull:
+// -S, or --gpg-sign with signing_key when one is set.
+func commitSigningArgs(cfg cfgsConfig) []string {
+	if !cfg.SignCommits {
+		return nil
+	}
+	if key := strings.TrimSpace(cfg.SigningKey); key != "" {
+		return []string{"--gpg-sign=" + key}
+	}
+	return []string{"-S"}
 }
 
-func gitRepoRoot(path string) (string, error) {
-	return runCommand(path, "git", "rev-parse", "--show-toplevel")
+func (a *app) gitRepoRoot(path string) (string, error) {
+	return a.runCommand(path, "git", "rev-parse", "--show-toplevel")
 }
 
-func gitHead(repoPath string) (string, bool, error) {
-	hasHead, err := repoHasHead(repoPath)
+func (a *app) gitHead(repoPath string) (string, bool, error) {
+	hasHead, err := a.repoHasHead(repoPath)
 	if err != nil {
 		return "", false, err
 	}
 	if !hasHead {
 		return "", false, nil
 	}
-	head, err := runCommand(repoPath, "git", "rev-parse", "HEAD")
+	head, err := a.runCommand(repoPath, "git", "rev-parse", "HEAD")
 	if err != nil {
 		return "", false, err
 	}
 	return strings.TrimSpace(head), true, nil
 }
 
+// gitAheadBehind counts the commits HEAD has that its upstream lacks, and the
+// reverse. A branch without an upstream, or a repo without commits, reports
+// zeros and false rather than an error.
+func (a *app) gitAheadBehind(repoPath string) (int, int, bool, error) {
+	if _, err := a.runCommand(repoPath, "git", "rev-parse", "--abbrev-ref", "--symbolic-full-name", "@{u}"); err != nil {
+		return 0, 0, false, nil
+	}
+	out, err := a.runCommand(repoPath, "git", "rev-list", "--left-right", "--count", "@{u}...HEAD")
+	if err != nil {
+		return 0, 0, false, err
+	}
+	fields := strings.Fields(out)
+	if len(fields) != 2 {
+		return 0, 0, false, fmt.Errorf("unexpected rev-list output %q", out)
+	}
+	behind, err := strconv.Atoi(fields[0])
+	if err != nil {
+		return 0, 0, false, fmt.Errorf("unexpected rev-list output %q", out)
+	}
+	ahead, err := strconv.Atoi(fields[1])
+	if err != nil {
+		return 0, 0, false, fmt.Errorf("unexpected rev-list output %q", out)
+	}
+	return ahead, behind, true, nil
+}
+
+// gitHeadIsPushed reports whether HEAD is reachable from any remote-tracking
+// branch.
+func (a *app) gitHeadIsPushed(repoPath string) (bool, error) {
+	out, err := a.runCommand(repoPath, "git", "branch", "-r", "--contains", "HEAD")
+	if err != nil {
+		return false, err
+	}
+	return strings.TrimSpace(out) != "", nil
+}
+
 func shortHash(commit string) string {
 	if len(commit) <= 12 {
 		return commit
@@ -1061,7 +6411,7 @@ func shortHash(commit string) string {
 	return commit[:12]
 }
 
-func validateAndNormalizeRepo(repoPath string) (string, error) {
+func (a *app) validateAndNormalizeRepo(repoPath string) (string, error) {
 	repoPath = expandPath(repoPath)
 	info, err := os.Stat(repoPath)
 	if err != nil {
@@ -1071,29 +6421,61 @@ func validateAndNormalizeRepo(repoPath string) (string, error) {
 		return "", fmt.Errorf("repository path is not a directory")
 	}
 
-	root, err := gitRepoRoot(repoPath)
+	root, err := a.gitRepoRoot(repoPath)
 	if err != nil {
 		return "", fmt.Errorf("path is not a git repository: %w", err)
 	}
-	if err := requireRepoRemote(root); err != nil {
+	if err := a.requireRepoRemote(root); err != nil {
 		return "", err
 	}
 	return root, nil
 }
 
-func requireRepoRemote(repoPath string) error {
-	remotes, err := runCommand(repoPath, "git", "remote")
+func (a *app) requireRepoRemote(repoPath string) error {
+	remotes, err := a.gitRemotes(repoPath)
 	if err != nil {
 		return err
 	}
-	if strings.TrimSpace(remotes) == "" {
+	if len(remotes) == 0 {
 		return fmt.Errorf("repository has no remote configured")
 	}
 	return nil
 }
 
-func repoIsEmpty(repoPath string) (bool, error) {
-	hasHead, err := repoHasHead(repoPath)
+func (a *app) gitRemotes(repoPath string) ([]string, error) {
+	out, err := a.runCommand(repoPath, "git", "remote")
+	if err != nil {
+		return nil, err
+	}
+	var remotes []string
+	for _, line := range strings.Split(out, "\n") {
+		if line = strings.TrimSpace(line); line != "" {
+			remotes = append(remotes, line)
+		}
+	}
+	sort.Strings(remotes)
+	return remotes, nil
+}
+
+func (a *app) gitCurrentBranch(repoPath string) (string, error) {
+	branch, err := a.runCommand(repoPath, "git", "symbolic-ref", "--short", "HEAD")
+	if err != nil {
+		return "", fmt.Errorf("resolve current branch (detached HEAD?): %w", err)
+	}
+	return branch, nil
+}
+
+// gitBranchOrDetached returns the current branch, or "" when HEAD is detached.
+func (a *app) gitBranchOrDetached(repoPath string) string {
+	branch, err := a.gitCurrentBranch(repoPath)
+	if err != nil {
+		return ""
+	}
+	return branch
+}
+
+func (a *app) repoIsEmpty(repoPath string) (bool, error) {
+	hasHead, err := a.repoHasHead(repoPath)
 	if err != nil {
 		return false, err
 	}
@@ -1101,7 +6483,7 @@ func repoIsEmpty(repoPath string) (bool, error) {
 		return true, nil
 	}
 
-	tracked, err := gitTrackedFiles(repoPath)
+	tracked, err := a.gitTrackedFiles(repoPath)
 	if err != nil {
 		return false, err
 	}
@@ -1113,10 +6495,10 @@ func repoIsEmpty(repoPath string) (bool, error) {
 	return true, nil
 }
 
-func repoHasHead(repoPath string) (bool, error) {
+func (a *app) repoHasHead(repoPath string) (bool, error) {
 	cmd := exec.Command("git", "rev-parse", "--verify", "HEAD")
 	cmd.Dir = repoPath
-	if err := cmd.Run(); err != nil {
+	if err := a.runner.Run(cmd); err != nil {
 		var exitErr *exec.ExitError
 		if errors.As(err, &exitErr) {
 			return false, nil
@@ -1126,77 +6508,317 @@ func repoHasHead(repoPath string) (bool, error) {
 	return true, nil
 }
 
-func gitTrackedFiles(repoPath string) ([]string, error) {
-	out, err := runCommand(repoPath, "git", "ls-files")
+func (a *app) gitTrackedFiles(repoPath string) ([]string, error) {
+	files, _, err := a.gitTrackedFileListing(repoPath)
+	return files, err
+}
+
+// gitTrackedFileListing returns the canonical, deduplicated tracked paths
+// along with the raw index entries that were not already canonical.
+func (a *app) gitTrackedFileListing(repoPath string) ([]string, []string, error) {
+	entries, err := a.gitTrackedEntries(repoPath)
+	if err != nil {
+		return nil, nil, err
+	}
+	files, nonCanonical := parseTrackedFiles(entries)
+	return files, nonCanonical, nil
+}
+
+// trackedCacheEntry is a cached ls-files result and the stamp of the git
+// index it was read from.
+type trackedCacheEntry struct {
+	stamp   indexStamp
+	entries []string
+}
+
+// indexStamp identifies one version of a repo's .git/index file.
+type indexStamp struct {
+	size    int64
+	modTime int64
+}
+
+// gitIndexStamp stats the repo's index. It reports false for layouts where
+// .git is not a directory, such as worktrees, which are then never cached.
+func gitIndexStamp(repoPath string) (indexStamp, bool) {
+	info, err := os.Stat(filepath.Join(repoPath, ".git", "index"))
+	if err != nil {
+		return indexStamp{}, false
+	}
+	return indexStamp{size: info.Size(), modTime: info.ModTime().UnixNano()}, true
+}
+
+// gitTrackedEntries returns the index paths exactly as git stores them.
+// Results are cached per repo while the index file is unchanged, which also
+// covers commits, checkouts and pulls since those rewrite the index; git
+// commands cfgs starts itself drop the cache up front through noteCommand.
+func (a *app) gitTrackedEntries(repoPath string) ([]string, error) {
+	stamp, cacheable := gitIndexStamp(repoPath)
+	if cached, ok := a.trackedCache[repoPath]; ok && cacheable && cached.stamp == stamp {
+		return slices.Clone(cached.entries), nil
+	}
+	out, err := a.runCommand(repoPath, "git", "ls-files", "-z")
 	if err != nil {
 		return nil, err
 	}
-	if strings.TrimSpace(out) == "" {
-		return nil, nil
+	var entries []string
+	for _, entry := range strings.Split(out, "\x00") {
+		if entry != "" {
+			entries = append(entries, entry)
+		}
 	}
-	lines := strings.Split(out, "\n")
-	var files []string
-	for _, line := range lines {
-		line = strings.TrimSpace(line)
-		if line == "" {
+	if cacheable {
+		if a.trackedCache == nil {
+			a.trackedCache = map[string]trackedCacheEntry{}
+		}
+		a.trackedCache[repoPath] = trackedCacheEntry{stamp: stamp, entries: entries}
+	}
+	return slices.Clone(entries), nil
+}
+
+// collidingTrackedPaths groups index entries that name the same file on disk:
+// entries that only differ by "." or "//" cleanup, and with foldCase also
+// entries that only differ by letter case. Groups are sorted.
+func collidingTrackedPaths(entries []string, foldCase bool) [][]string {
+	groups := map[string][]string{}
+	for _, entry := range entries {
+		key, err := normalizeManagedPath(entry)
+		if err != nil {
 			continue
 		}
-		rel, err := normalizeManagedPath(line)
+		if foldCase {
+			key = strings.ToLower(key)
+		}
+		groups[key] = append(groups[key], entry)
+	}
+	var collisions [][]string
+	for _, group := range groups {
+		if len(group) > 1 {
+			sort.Strings(group)
+			collisions = append(collisions, group)
+		}
+	}
+	sort.Slice(collisions, func(i, j int) bool { return collisions[i][0] < collisions[j][0] })
+	return collisions
+}
+
+// caseInsensitiveFS reports whether this OS's default filesystem folds case,
+// as APFS and NTFS do.
+func caseInsensitiveFS() bool {
+	return runtime.GOOS == "darwin" || runtime.GOOS == "windows"
+}
+
+// parseTrackedFiles canonicalizes ls-files entries so that "a/./b", "a//b"
+// and "a/b" collapse to a single "a/b".
+func parseTrackedFiles(entries []string) ([]string, []string) {
+	var files, nonCanonical []string
+	for _, entry := range entries {
+		if entry == "" {
+			continue
+		}
+		rel, err := normalizeManagedPath(entry)
 		if err != nil {
 			continue
 		}
+		if rel != entry {
+			nonCanonical = append(nonCanonical, entry)
+		}
 		files = append(files, rel)
 	}
-	sort.Strings(files)
-	return unique(files), nil
+	return unique(files), nonCanonical
 }
 
-func gitIsDirty(repoPath string) (bool, error) {
-	out, err := runCommand(repoPath, "git", "status", "--porcelain")
+func (a *app) gitIsDirty(repoPath string) (bool, error) {
+	out, err := a.runCommand(repoPath, "git", "status", "--porcelain")
 	if err != nil {
 		return false, err
 	}
 	return strings.TrimSpace(out) != "", nil
 }
 
-func selectWithFzf(items []string, prompt string) ([]string, error) {
+// gitChangedPaths parses `git status --porcelain=v2 -z` into the changed
+// paths, listing untracked files individually. The original side of a
+// rename is already staged, so only the new path is returned.
+func (a *app) gitChangedPaths(repoPath string) ([]string, error) {
+	// v2 rather than v1 because its records never start with a space, which
+	// the trimmed command output would otherwise eat from the first one.
+	out, err := a.runCommand(repoPath, "git", "status", "--porcelain=v2", "-z", "--untracked-files=all")
+	if err != nil {
+		return nil, err
+	}
+	var paths []string
+	records := strings.Split(out, "\x00")
+	for i := 0; i < len(records); i++ {
+		switch fields := strings.Fields(records[i]); {
+		case len(fields) == 0:
+		case fields[0] == "?":
+			paths = append(paths, strings.TrimPrefix(records[i], "? "))
+		case fields[0] == "1" && len(fields) >= 9:
+			paths = append(paths, strings.SplitN(records[i], " ", 9)[8])
+		case fields[0] == "2" && len(fields) >= 10:
+			paths = append(paths, strings.SplitN(records[i], " ", 10)[9])
+			i++ // skip the original path
+		case fields[0] == "u" && len(fields) >= 11:
+			paths = append(paths, strings.SplitN(records[i], " ", 11)[10])
+		}
+	}
+	return unique(paths), nil
+}
+
+// selectChangedPaths lets the user pick which changed files to stage. An
+// empty result means nothing was picked and everything should be committed.
+func (a *app) selectChangedPaths(repoPath string) ([]string, error) {
+	changed, err := a.gitChangedPaths(repoPath)
+	if err != nil {
+		return nil, err
+	}
+	if len(changed) < 2 {
+		return nil, nil
+	}
+	fmt.Fprintln(a.out, "Select the files to commit, or none to commit everything.")
+	return a.pickWithFzf(changed, "commit> ")
+}
+
+// pathArguments returns the paths named on the command line or, with
+// --stdin, the non-blank lines read from standard input until EOF.
+func (a *app) pathArguments(positional []string, fromStdin bool) ([]string, error) {
+	if !fromStdin {
+		return positional, nil
+	}
+	if len(positional) > 0 {
+		return nil, fmt.Errorf("--stdin cannot be combined with path arguments")
+	}
+	var paths []string
+	for {
+		line, err := a.in.ReadString('\n')
+		if line = strings.TrimSpace(line); line != "" {
+			paths = append(paths, line)
+		}
+		if errors.Is(err, io.EOF) {
+			break
+		}
+		if err != nil {
+			return nil, fmt.Errorf("read standard input: %w", err)
+		}
+	}
+	if len(paths) == 0 {
+		return nil, fmt.Errorf("no paths on standard input")
+	}
+	return paths, nil
+}
+
+// selectFiles returns explicit when paths were given on the command line,
+// after checking that each one is among candidates, and otherwise lets the
+// user pick candidates with fzf, or in an editor when fzf is not installed.
+func (a *app) selectFiles(candidates []string, explicit []string, prompt string) ([]string, error) {
+	if len(explicit) == 0 {
+		return a.selectWithFzf(candidates, prompt)
+	}
+	candidateSet := sliceToSet(candidates)
+	var selected, invalid []string
+	for _, raw := range explicit {
+		rel, err := normalizeManagedPath(raw)
+		if err != nil {
+			invalid = append(invalid, fmt.Sprintf("%s (%v)", raw, err))
+			continue
+		}
+		if _, ok := candidateSet[rel]; !ok {
+			invalid = append(invalid, fmt.Sprintf("%s (not a candidate for %s)", rel, strings.TrimSuffix(prompt, "> ")))
+			continue
+		}
+		selected = append(selected, rel)
+	}
+	if len(invalid) > 0 {
+		return nil, fmt.Errorf("invalid paths: %s", strings.Join(invalid, ", "))
+	}
+	return unique(selected), nil
+}
+
+// errSelectionCancelled reports that the user quit fzf with Esc or Ctrl-C
+// rather than accepting a (possibly empty) selection.
+var errSelectionCancelled = errors.New("selection cancelled")
+
+// selectWithFzf is pickWithFzf for callers where cancelling and selecting
+// nothing both mean doing nothing.
+func (a *app) selectWithFzf(items []string, prompt string) ([]string, error) {
+	selected, err := a.pickWithFzf(items, prompt)
+	if errors.Is(err, errSelectionCancelled) {
+		return nil, nil
+	}
+	return selected, err
+}
+
+// pickWithFzf lets the user choose among items with fzf, or in an editor
+// when fzf is not installed, and returns errSelectionCancelled when fzf was
+// aborted.
+func (a *app) pickWithFzf(items []string, prompt string) ([]string, error) {
 	if len(items) == 0 {
 		return nil, nil
 	}
+	if _, err := exec.LookPath("fzf"); err != nil {
+		return a.selectWithEditor(items, prompt)
+	}
 
-	xdg, err := xdgConfigHome()
+	roots, err := configuredRoots()
+	if err != nil {
+		return nil, err
+	}
+	cfg, _, err := loadCfgsConfig()
 	if err != nil {
 		return nil, err
 	}
 
+	// Live paths go to a side file, one per line in item order, so the
+	// preview looks them up by fzf's numeric {n} index and a crafted file
+	// name is never spliced into the shell command.
+	paths, err := os.CreateTemp("", "cfgs-preview-*.txt")
+	if err != nil {
+		return nil, err
+	}
+	defer os.Remove(paths.Name())
 	var input bytes.Buffer
+	var pathList strings.Builder
 	for _, item := range items {
 		input.WriteString(item)
 		input.WriteByte('\n')
+		pathList.WriteString(liveFilePath(roots, item))
+		pathList.WriteByte('\n')
+	}
+	if _, err := paths.WriteString(pathList.String()); err != nil {
+		paths.Close()
+		return nil, err
+	}
+	if err := paths.Close(); err != nil {
+		return nil, err
 	}
 
-	preview := `p="$XDG_CONFIG_HOME"/{}; if [ -f "$p" ]; then (bat --style=plain --color=always --line-range=:200 "$p" 2>/dev/null || sed -n "1,200p" "$p"); else echo "No preview: $p"; fi`
+	preview := defaultFzfPreview
+	if strings.TrimSpace(cfg.FzfPreview) != "" {
+		preview = cfg.FzfPreview
+	}
 	cmd := exec.Command(
 		"fzf",
 		"--multi",
 		"--prompt", prompt,
-		"--preview", preview,
+		"--preview", fzfPreviewPathLookup+preview,
 		"--preview-window", "right,60%,border-left,wrap",
 	)
+	cmd.Env = append(os.Environ(), "CFGS_PREVIEW_LIST="+paths.Name())
 	cmd.Stdin = &input
-	cmd.Env = append(os.Environ(), "XDG_CONFIG_HOME="+xdg)
 
 	var stdout bytes.Buffer
 	var stderr bytes.Buffer
 	cmd.Stdout = &stdout
 	cmd.Stderr = &stderr
 
-	err = cmd.Run()
+	err = a.runner.Run(cmd)
 	if err != nil {
 		var exitErr *exec.ExitError
 		if errors.As(err, &exitErr) {
 			code := exitErr.ExitCode()
-			if code == 1 || code == 130 {
+			if code == 130 {
+				return nil, errSelectionCancelled
+			}
+			if code == 1 {
 				return nil, nil
 			}
 		}
@@ -1225,70 +6847,450 @@ func selectWithFzf(items []string, prompt string) ([]string, error) {
 	return unique(selected), nil
 }
 
-func scanXDGRegularFiles() ([]string, error) {
-	xdg, err := xdgConfigHome()
+// fzfPreviewPathLookup sets $CFGS_PREVIEW_PATH to the live path of the
+// highlighted item before the preview body runs. {n} is always a number, and
+// the path is only ever expanded inside double quotes.
+const fzfPreviewPathLookup = `CFGS_PREVIEW_PATH=$(sed -n "$(({n} + 1))p" "$CFGS_PREVIEW_LIST"); export CFGS_PREVIEW_PATH; `
+
+// defaultFzfPreview shows the file with bat when available, else sed.
+const defaultFzfPreview = `p=$CFGS_PREVIEW_PATH; if [ -f "$p" ]; then (bat --style=plain --color=always --line-range=:200 -- "$p" 2>/dev/null || sed -n "1,200p" -- "$p"); else printf 'No preview: %s\n' "$p"; fi`
+
+// selectWithEditor is the selection fallback when fzf is missing: it writes
+// items to a temp file, opens $VISUAL or $EDITOR (default vi) on it, and
+// keeps every line that is still present and not commented out.
+func (a *app) selectWithEditor(items []string, prompt string) ([]string, error) {
+	if len(items) == 0 {
+		return nil, nil
+	}
+	editor := os.Getenv("VISUAL")
+	if editor == "" {
+		editor = os.Getenv("EDITOR")
+	}
+	if editor == "" {
+		editor = "vi"
+	}
+
+	tmp, err := os.CreateTemp("", "cfgs-select-*.txt")
+	if err != nil {
+		return nil, err
+	}
+	defer os.Remove(tmp.Name())
+
+	var b strings.Builder
+	fmt.Fprintf(&b, "# cfgs %s: fzf is not installed; delete or comment out the lines you do not want,\n", strings.TrimSuffix(prompt, "> "))
+	fmt.Fprintln(&b, "# then save and quit. Lines starting with # are ignored.")
+	for _, item := range items {
+		fmt.Fprintln(&b, item)
+	}
+	if _, err := tmp.WriteString(b.String()); err != nil {
+		tmp.Close()
+		return nil, err
+	}
+	if err := tmp.Close(); err != nil {
+		return nil, err
+	}
+
+	// The editor may carry arguments (e.g. "code --wait"), so let the shell
+	// split it.
+	if err := a.runInteractiveCommand("", "sh", "-c", editor+` "$1"`, "sh", tmp.Name()); err != nil {
+		return nil, err
+	}
+	data, err := os.ReadFile(tmp.Name())
+	if err != nil {
+		return nil, err
+	}
+
+	offered := sliceToSet(items)
+	var selected []string
+	for _, line := range strings.Split(string(data), "\n") {
+		line = strings.TrimSpace(line)
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+		if _, ok := offered[line]; !ok {
+			return nil, fmt.Errorf("selection contains %q, which was not offered", line)
+		}
+		selected = append(selected, line)
+	}
+	return unique(selected), nil
+}
+
+// scanXDGRegularFiles lists the regular files under every configured root as
+// repo-relative paths, so files from non-default roots carry their repo_dir.
+// Symlink cycles, over-long link chains, and entries the walk could not read
+// are returned as problems rather than silently dropped. exclude holds extra
+// ignore patterns for this scan only, such as those from --exclude.
+func (a *app) scanXDGRegularFiles(repoPath string, exclude []globMatcher) ([]string, []manualResolve, error) {
+	roots, err := configuredRoots()
+	if err != nil {
+		return nil, nil, err
+	}
+	roots = a.excludeNestedRepo(roots, repoPath, true)
+	ignoreMatchers, err := a.configuredIgnoreMatchers()
+	if err != nil {
+		return nil, nil, err
+	}
+	ignoreMatchers = append(ignoreMatchers, exclude...)
+
+	// The walk stays sequential so ignored directories are still pruned
+	// with SkipDir; the per-entry inspection, which resolves symlinks and
+	// stats odd entries, runs on a bounded pool.
+	var mu sync.Mutex
+	var files []string
+	var problems []manualResolve
+	addProblem := func(problem manualResolve) {
+		mu.Lock()
+		defer mu.Unlock()
+		problems = append(problems, problem)
+	}
+
+	entries := make(chan scanEntry, 4*scanWorkers)
+	var workers sync.WaitGroup
+	for i := 0; i < scanWorkers; i++ {
+		workers.Add(1)
+		go func() {
+			defer workers.Done()
+			for entry := range entries {
+				regular, problem := inspectScanEntry(entry)
+				if problem != "" {
+					addProblem(manualResolve{path: entry.rel, reason: problem})
+				}
+				if regular {
+					mu.Lock()
+					files = append(files, entry.rel)
+					mu.Unlock()
+				}
+			}
+		}()
+	}
+
+	onWalkError := func(rel string, err error) {
+		addProblem(manualResolve{path: rel, reason: walkProblem(err)})
+	}
+	err = walkRootsReporting(roots, ignoreMatchers, onWalkError, func(fullPath string, rel string, d fs.DirEntry) error {
+		entries <- scanEntry{fullPath: fullPath, rel: rel, d: d}
+		return nil
+	})
+	close(entries)
+	workers.Wait()
+	if err != nil {
+		return nil, nil, err
+	}
+
+	sort.Strings(files)
+	sortManualResolves(problems)
+	return unique(files), problems, nil
+}
+
+// scanWorkers bounds how many walked entries scanXDGRegularFiles inspects
+// concurrently.
+var scanWorkers = runtime.GOMAXPROCS(0)
+
+// scanEntry is one non-directory entry found by the XDG scan.
+type scanEntry struct {
+	fullPath string
+	rel      string
+	d        fs.DirEntry
+}
+
+// inspectScanEntry reports whether entry is a regular file worth offering
+// and, for a symlink that cannot be resolved, why it was skipped.
+func inspectScanEntry(entry scanEntry) (bool, string) {
+	mode := entry.d.Type()
+	if mode&os.ModeSymlink != 0 {
+		_, err := followSymlinks(entry.fullPath)
+		return false, symlinkProblem(err)
+	}
+	if mode.IsRegular() {
+		return true, ""
+	}
+	info, err := entry.d.Info()
+	return err == nil && info.Mode().IsRegular(), ""
+}
+
+// warnScanProblems prints the entries scanXDGRegularFiles had to skip.
+func (a *app) warnScanProblems(problems []manualResolve) {
+	for _, problem := range problems {
+		fmt.Fprintf(a.errOut, "warning: skipped %s: %s\n", problem.path, problem.reason)
+	}
+}
+
+// walkRoots walks the live side of every root and calls fn for each
+// non-directory entry with its repo-relative path. Ignored paths, the bases of
+// other roots nested inside a root, and paths that would be stored under
+// another root's repo_dir are skipped.
+func walkRoots(roots []managedRoot, ignoreMatchers []globMatcher, fn func(fullPath string, rel string, d fs.DirEntry) error) error {
+	return walkRootsReporting(roots, ignoreMatchers, nil, fn)
+}
+
+// walkRootsReporting is walkRoots that also hands entries the walk could not
+// read to onWalkError, keyed like fn's rel (or the full path when no repo path
+// can be formed). A missing root base is not an error.
+func walkRootsReporting(roots []managedRoot, ignoreMatchers []globMatcher, onWalkError func(rel string, err error), fn func(fullPath string, rel string, d fs.DirEntry) error) error {
+	roots = canonicalRoots(roots)
+	for _, root := range roots {
+		root := root
+		err := filepath.WalkDir(root.base, func(fullPath string, d fs.DirEntry, walkErr error) error {
+			liveRel, err := filepath.Rel(root.base, fullPath)
+			if err != nil {
+				return nil
+			}
+			liveRel = filepath.ToSlash(liveRel)
+
+			if walkErr != nil {
+				if onWalkError == nil || shouldIgnorePath(liveRel, false, ignoreMatchers) {
+					return nil
+				}
+				if liveRel == "." && errors.Is(walkErr, fs.ErrNotExist) {
+					return nil
+				}
+				rel, err := normalizeManagedPath(path.Join(root.repoDir, liveRel))
+				if err != nil {
+					rel = fullPath
+				}
+				onWalkError(rel, walkErr)
+				return nil
+			}
+
+			if d.IsDir() {
+				if root.skipDir != "" && fullPath == root.skipDir {
+					return filepath.SkipDir
+				}
+				if liveRel != "." && isOtherRootBase(roots, root, fullPath) {
+					return filepath.SkipDir
+				}
+				if shouldIgnorePath(liveRel, true, ignoreMatchers) && !mayReincludeUnder(liveRel, ignoreMatchers) {
+					return filepath.SkipDir
+				}
+				if liveRel != "." && root.maxDepth > 0 && strings.Count(liveRel, "/")+1 >= root.maxDepth {
+					return filepath.SkipDir
+				}
+				return nil
+			}
+			if shouldIgnorePath(liveRel, false, ignoreMatchers) {
+				return nil
+			}
+
+			rel, err := normalizeManagedPath(path.Join(root.repoDir, liveRel))
+			if err != nil {
+				return nil
+			}
+			if owner, _ := rootForManagedPath(roots, rel); owner.name != root.name {
+				return nil
+			}
+			return fn(fullPath, rel, d)
+		})
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// canonicalRoots returns a copy of roots with each base resolved through
+// symlinks. WalkDir does not descend into a symlinked start directory, e.g.
+// an XDG_CONFIG_HOME that links elsewhere, and the paths it yields must share
+// one spelling with the base for filepath.Rel to be meaningful. Bases that
+// cannot be resolved, such as missing ones, are kept as configured.
+func canonicalRoots(roots []managedRoot) []managedRoot {
+	out := make([]managedRoot, len(roots))
+	for i, root := range roots {
+		if resolved, err := filepath.EvalSymlinks(root.base); err == nil {
+			root.base = resolved
+		}
+		out[i] = root
+	}
+	return out
+}
+
+// nestedRepoRoots returns roots with skipDir set on every root whose base
+// contains repoPath, e.g. a repo at ~/.config/dotfiles, so walks never list
+// the repo's own files as live ones. It also returns those roots' names.
+// Both sides are resolved through symlinks to match canonicalRoots.
+func nestedRepoRoots(roots []managedRoot, repoPath string) ([]managedRoot, []string) {
+	repo := filepath.Clean(repoPath)
+	if resolved, err := filepath.EvalSymlinks(repo); err == nil {
+		repo = resolved
+	}
+	out := make([]managedRoot, len(roots))
+	var nested []string
+	for i, root := range roots {
+		base := root.base
+		if resolved, err := filepath.EvalSymlinks(base); err == nil {
+			base = resolved
+		}
+		if within, err := pathWithin(base, repo); err == nil && within {
+			root.skipDir = repo
+			nested = append(nested, root.name)
+		}
+		out[i] = root
+	}
+	return out, nested
+}
+
+// excludeNestedRepo is nestedRepoRoots that, with warn set, also says which
+// roots contain the repo.
+func (a *app) excludeNestedRepo(roots []managedRoot, repoPath string, warn bool) []managedRoot {
+	roots, nested := nestedRepoRoots(roots, repoPath)
+	if warn && len(nested) > 0 {
+		fmt.Fprintf(a.errOut, "warning: repo %s is inside the %s root(s); skipping it when walking live files\n", repoPath, strings.Join(nested, ", "))
+	}
+	return roots
+}
+
+func isOtherRootBase(roots []managedRoot, current managedRoot, dir string) bool {
+	for _, other := range roots {
+		if other.name == current.name {
+			continue
+		}
+		if filepath.Clean(other.base) == filepath.Clean(dir) {
+			return true
+		}
+	}
+	return false
+}
+
+// configuredRoots resolves the default XDG_CONFIG_HOME root followed by any
+// roots declared in the cfgs config.
+func configuredRoots() ([]managedRoot, error) {
+	cfg, _, err := loadCfgsConfig()
 	if err != nil {
 		return nil, err
 	}
-	ignoreMatchers, err := configuredIgnoreMatchers()
+	return resolveRoots(cfg.Roots)
+}
+
+func resolveRoots(configs []rootConfig) ([]managedRoot, error) {
+	xdg, err := xdgConfigHome()
 	if err != nil {
 		return nil, err
 	}
-
-	var files []string
-	err = filepath.WalkDir(xdg, func(fullPath string, d fs.DirEntry, walkErr error) error {
-		if walkErr != nil {
-			return nil
+	roots := []managedRoot{{name: "config", base: xdg}}
+	for _, rc := range configs {
+		name := strings.TrimSpace(rc.Name)
+		if name == "" {
+			return nil, fmt.Errorf("root is missing a name")
 		}
-
-		rel, err := filepath.Rel(xdg, fullPath)
+		repoDir, err := normalizeManagedPath(rc.RepoDir)
 		if err != nil {
-			return nil
+			return nil, fmt.Errorf("root %q: repo_dir: %w", name, err)
 		}
-		rel = filepath.ToSlash(rel)
-
-		if d.IsDir() {
-			if shouldIgnorePath(rel, true, ignoreMatchers) {
-				return filepath.SkipDir
-			}
-			return nil
+		base, err := xdgBaseDir(strings.TrimSpace(rc.Base))
+		if err != nil {
+			return nil, fmt.Errorf("root %q: %w", name, err)
 		}
-		if shouldIgnorePath(rel, false, ignoreMatchers) {
-			return nil
+		maxDepth := rc.MaxDepth
+		if maxDepth < 0 {
+			return nil, fmt.Errorf("root %q: max_depth must not be negative", name)
 		}
-
-		mode := d.Type()
-		if !mode.IsRegular() {
-			info, err := d.Info()
-			if err != nil || !info.Mode().IsRegular() {
-				return nil
+		if maxDepth == 0 && strings.TrimSpace(rc.Base) == "home" {
+			maxDepth = 1
+		}
+		for _, existing := range roots {
+			if existing.name == name {
+				return nil, fmt.Errorf("root %q is declared more than once", name)
+			}
+			if existing.repoDir == "" {
+				continue
+			}
+			if repoDir == existing.repoDir ||
+				strings.HasPrefix(repoDir, existing.repoDir+"/") ||
+				strings.HasPrefix(existing.repoDir, repoDir+"/") {
+				return nil, fmt.Errorf("root %q: repo_dir %q overlaps root %q", name, repoDir, existing.name)
 			}
 		}
+		roots = append(roots, managedRoot{name: name, base: base, repoDir: repoDir, maxDepth: maxDepth})
+	}
+	return roots, nil
+}
 
-		normalized, err := normalizeManagedPath(rel)
-		if err != nil {
-			return nil
+// rootForManagedPath returns the root owning a repo-relative path and the
+// path relative to that root's base. Paths outside every repo_dir belong to
+// the default root.
+func rootForManagedPath(roots []managedRoot, rel string) (managedRoot, string) {
+	rel = managedLiveRel(rel)
+	for _, root := range roots {
+		if root.repoDir != "" && strings.HasPrefix(rel, root.repoDir+"/") {
+			return root, strings.TrimPrefix(rel, root.repoDir+"/")
 		}
-		files = append(files, normalized)
-		return nil
-	})
-	if err != nil {
-		return nil, err
 	}
+	return roots[0], rel
+}
 
-	sort.Strings(files)
-	return unique(files), nil
+func liveFilePath(roots []managedRoot, rel string) string {
+	root, liveRel := rootForManagedPath(roots, rel)
+	return filepath.Join(root.base, filepath.FromSlash(liveRel))
 }
 
+func xdgBaseDir(base string) (string, error) {
+	switch base {
+	case "config":
+		return xdgConfigHome()
+	case "data":
+		return xdgDir("XDG_DATA_HOME", filepath.Join(".local", "share"))
+	case "state":
+		return xdgDir("XDG_STATE_HOME", filepath.Join(".local", "state"))
+	case "home":
+		return os.UserHomeDir()
+	default:
+		return "", fmt.Errorf("unknown base %q (want config, data, state, or home)", base)
+	}
+}
+
+// xdgConfigHome returns XDG_CONFIG_HOME, falling back to %APPDATA% on
+// Windows and ~/.config elsewhere.
 func xdgConfigHome() (string, error) {
-	if configured := strings.TrimSpace(os.Getenv("XDG_CONFIG_HOME")); configured != "" {
+	if runtime.GOOS == "windows" && strings.TrimSpace(os.Getenv("XDG_CONFIG_HOME")) == "" {
+		if appData := strings.TrimSpace(os.Getenv("APPDATA")); appData != "" {
+			return appData, nil
+		}
+	}
+	return xdgDir("XDG_CONFIG_HOME", ".config")
+}
+
+func xdgDir(envName string, homeRel string) (string, error) {
+	if configured := strings.TrimSpace(os.Getenv(envName)); configured != "" {
 		return configured, nil
 	}
 	home, err := os.UserHomeDir()
 	if err != nil {
 		return "", fmt.Errorf("resolve home directory: %w", err)
 	}
-	return filepath.Join(home, ".config"), nil
+	return filepath.Join(home, homeRel), nil
+}
+
+// xdgConfigDirs returns the system config directories from XDG_CONFIG_DIRS,
+// or the spec's default /etc/xdg when it is unset. Relative entries are
+// ignored as the spec requires.
+func xdgConfigDirs() []string {
+	configured := strings.TrimSpace(os.Getenv("XDG_CONFIG_DIRS"))
+	if configured == "" {
+		if runtime.GOOS == "windows" {
+			return nil
+		}
+		return []string{"/etc/xdg"}
+	}
+	var dirs []string
+	for _, dir := range filepath.SplitList(configured) {
+		if dir = strings.TrimSpace(dir); filepath.IsAbs(dir) {
+			dirs = append(dirs, filepath.Clean(dir))
+		}
+	}
+	return dirs
+}
+
+// systemConfigCopies lists the paths under XDG_CONFIG_DIRS where liveRel,
+// relative to the config home, also exists. cfgs never manages those; they
+// are only reported so the precedence is clear.
+func systemConfigCopies(liveRel string) []string {
+	var found []string
+	for _, dir := range xdgConfigDirs() {
+		candidate := filepath.Join(dir, filepath.FromSlash(liveRel))
+		if _, err := os.Stat(candidate); err == nil {
+			found = append(found, candidate)
+		}
+	}
+	return found
 }
 
 func looksLikeRemote(input string) bool {
@@ -1344,7 +7346,17 @@ func ensureEmptyOrMissingDir(path string) error {
 	return nil
 }
 
+// cfgsConfigPath returns CFGS_CONFIG when set, else the config.json under
+// $XDG_CONFIG_HOME/cfgs. The manifests and ignore file cfgs keeps beside the
+// config follow it into the same directory.
 func cfgsConfigPath() (string, error) {
+	if configured := strings.TrimSpace(os.Getenv("CFGS_CONFIG")); configured != "" {
+		configPath, err := filepath.Abs(expandPath(configured))
+		if err != nil {
+			return "", fmt.Errorf("CFGS_CONFIG: %w", err)
+		}
+		return configPath, nil
+	}
 	xdg, err := xdgConfigHome()
 	if err != nil {
 		return "", err
@@ -1353,6 +7365,20 @@ func cfgsConfigPath() (string, error) {
 }
 
 func loadCfgsConfig() (cfgsConfig, bool, error) {
+	cfg, _, err := readCfgsConfig()
+	if err != nil {
+		return cfgsConfig{}, false, err
+	}
+	if cfg.RepoPath == "" {
+		return cfgsConfig{}, false, nil
+	}
+	return cfg, true, nil
+}
+
+// readCfgsConfig is loadCfgsConfig without the requirement that a repo is
+// configured, for callers that edit the file. The bool reports whether the
+// config file exists.
+func readCfgsConfig() (cfgsConfig, bool, error) {
 	configPath, err := cfgsConfigPath()
 	if err != nil {
 		return cfgsConfig{}, false, err
@@ -1365,16 +7391,123 @@ func loadCfgsConfig() (cfgsConfig, bool, error) {
 		return cfgsConfig{}, false, err
 	}
 
-	var cfg cfgsConfig
-	if err := json.Unmarshal(data, &cfg); err != nil {
-		return cfgsConfig{}, false, err
+	cfg, err := decodeCfgsConfig(data)
+	if err != nil {
+		return cfgsConfig{}, false, fmt.Errorf("%s: %w", configPath, err)
+	}
+	if len(cfg.Profiles) == 0 && strings.TrimSpace(cfg.RepoPath) != "" {
+		cfg.Profiles = map[string]profileConfig{
+			defaultProfileName: {RepoPath: cfg.RepoPath, IgnoreGlobs: cfg.IgnoreGlobs},
+		}
+		cfg.Active = defaultProfileName
+	}
+	if cfg.Active != "" {
+		profile, ok := cfg.Profiles[cfg.Active]
+		if !ok {
+			return cfgsConfig{}, false, fmt.Errorf("active profile %q is not defined in profiles", cfg.Active)
+		}
+		cfg.RepoPath = profile.RepoPath
+		cfg.IgnoreGlobs = profile.IgnoreGlobs
+	}
+	if err := normalizeCfgsConfig(&cfg); err != nil {
+		return cfgsConfig{}, false, fmt.Errorf("%s: %w", configPath, err)
 	}
+	return cfg, true, nil
+}
+
+// normalizeCfgsConfig trims and lowercases fields the way they are compared
+// and rejects values no command could use.
+func normalizeCfgsConfig(cfg *cfgsConfig) error {
 	cfg.RepoPath = strings.TrimSpace(cfg.RepoPath)
 	cfg.IgnoreGlobs = sanitizeIgnoreGlobs(cfg.IgnoreGlobs)
-	if cfg.RepoPath == "" {
-		return cfgsConfig{}, false, nil
+	if _, err := compileGlobMatchers(cfg.IgnoreGlobs); err != nil {
+		return fmt.Errorf("ignore_globs: %w", err)
 	}
-	return cfg, true, nil
+	cfg.ExcludedPaths = sanitizeManagedPaths(cfg.ExcludedPaths)
+	cfg.LinkMode = strings.ToLower(strings.TrimSpace(cfg.LinkMode))
+	switch cfg.LinkMode {
+	case "", linkModeAbsolute, linkModeRelative:
+	default:
+		return fmt.Errorf("invalid link_mode %q (want %s or %s)", cfg.LinkMode, linkModeAbsolute, linkModeRelative)
+	}
+	cfg.LinkStrategy = strings.ToLower(strings.TrimSpace(cfg.LinkStrategy))
+	switch cfg.LinkStrategy {
+	case "", linkStrategySymlink, linkStrategyHardlink, linkStrategyCopy:
+	default:
+		return fmt.Errorf("invalid link_strategy %q (want %s, %s, or %s)", cfg.LinkStrategy, linkStrategySymlink, linkStrategyHardlink, linkStrategyCopy)
+	}
+	if _, err := syncPullArgs(*cfg, cfg.SyncStrategy); err != nil {
+		return fmt.Errorf("sync_strategy: %w", err)
+	}
+	if cfg.NetworkRetries != nil && *cfg.NetworkRetries < 0 {
+		return fmt.Errorf("invalid network_retries %d (want 0 or more)", *cfg.NetworkRetries)
+	}
+	return nil
+}
+
+// decodeCfgsConfig parses the config strictly: unknown fields, values of
+// the wrong type, and trailing data are errors that name the offending
+// field, so a typo cannot silently leave a setting at its zero value.
+func decodeCfgsConfig(data []byte) (cfgsConfig, error) {
+	var cfg cfgsConfig
+	dec := json.NewDecoder(bytes.NewReader(data))
+	dec.DisallowUnknownFields()
+	if err := dec.Decode(&cfg); err != nil {
+		var syntaxErr *json.SyntaxError
+		var typeErr *json.UnmarshalTypeError
+		switch {
+		case errors.As(err, &syntaxErr):
+			line := bytes.Count(data[:min(int(syntaxErr.Offset), len(data))], []byte("\n")) + 1
+			return cfgsConfig{}, fmt.Errorf("invalid JSON on line %d: %v", line, syntaxErr)
+		case errors.As(err, &typeErr) && typeErr.Field != "":
+			return cfgsConfig{}, fmt.Errorf("field %q must be %s, not a JSON %s", typeErr.Field, jsonTypeName(typeErr.Type), typeErr.Value)
+		case errors.As(err, &typeErr):
+			return cfgsConfig{}, fmt.Errorf("config must be a JSON object, not a JSON %s", typeErr.Value)
+		}
+		if field, ok := strings.CutPrefix(err.Error(), "json: unknown field "); ok {
+			field = strings.Trim(field, `"`)
+			if known := closestConfigField(field); known != "" {
+				return cfgsConfig{}, fmt.Errorf("unknown field %q (did you mean %q?)", field, known)
+			}
+			return cfgsConfig{}, fmt.Errorf("unknown field %q", field)
+		}
+		return cfgsConfig{}, err
+	}
+	if dec.More() {
+		return cfgsConfig{}, fmt.Errorf("unexpected data after the config object")
+	}
+	return cfg, nil
+}
+
+// jsonTypeName describes a Go type the way the JSON Schema for it would.
+func jsonTypeName(t reflect.Type) string {
+	schemaType, _ := jsonSchemaFor(t)["type"].(string)
+	switch schemaType {
+	case "":
+		return "a " + t.String()
+	case "array", "object", "integer":
+		return "an " + schemaType
+	default:
+		return "a " + schemaType
+	}
+}
+
+// closestConfigField returns the config field that field most likely
+// misspells, ignoring case and '-' versus '_', or "" when none is close.
+func closestConfigField(field string) string {
+	normalize := func(s string) string {
+		return strings.ReplaceAll(strings.ToLower(s), "-", "_")
+	}
+	want := normalize(field)
+	for _, t := range []reflect.Type{reflect.TypeOf(cfgsConfig{}), reflect.TypeOf(profileConfig{})} {
+		for i := 0; i < t.NumField(); i++ {
+			name, _, _ := strings.Cut(t.Field(i).Tag.Get("json"), ",")
+			if name != "" && name != "-" && normalize(name) == want {
+				return name
+			}
+		}
+	}
+	return ""
 }
 
 func saveCfgsConfig(cfg cfgsConfig) error {
@@ -1387,45 +7520,439 @@ func saveCfgsConfig(cfg cfgsConfig) error {
 	}
 	cfg.RepoPath = strings.TrimSpace(cfg.RepoPath)
 	cfg.IgnoreGlobs = sanitizeIgnoreGlobs(cfg.IgnoreGlobs)
+	cfg.ExcludedPaths = sanitizeManagedPaths(cfg.ExcludedPaths)
+	if cfg.Active != "" {
+		profiles := make(map[string]profileConfig, len(cfg.Profiles)+1)
+		maps.Copy(profiles, cfg.Profiles)
+		profiles[cfg.Active] = profileConfig{RepoPath: cfg.RepoPath, IgnoreGlobs: cfg.IgnoreGlobs}
+		cfg.Profiles = profiles
+		cfg.RepoPath = ""
+		cfg.IgnoreGlobs = nil
+	}
 	data, err := json.Marshal(cfg)
 	if err != nil {
 		return err
 	}
-	return os.WriteFile(configPath, append(data, '\n'), 0o644)
+	return writeFileAtomic(configPath, append(data, '\n'), 0o644)
+}
+
+// writeFileAtomic replaces name with data so that a crash leaves either the
+// old or the new content, never a truncated file: it writes and fsyncs a
+// temp file in the same directory, then renames it over name.
+func writeFileAtomic(name string, data []byte, perm fs.FileMode) error {
+	return writeFileAtomicWith(name, perm, func(w io.Writer) error {
+		_, err := w.Write(data)
+		return err
+	})
+}
+
+// writeFileAtomicWith is writeFileAtomic with the content produced by write.
+// When write fails, name is left as it was.
+func writeFileAtomicWith(name string, perm fs.FileMode, write func(io.Writer) error) error {
+	dir := filepath.Dir(name)
+	tmp, err := os.CreateTemp(dir, "."+filepath.Base(name)+".tmp-*")
+	if err != nil {
+		return err
+	}
+	tmpPath := tmp.Name()
+	defer os.Remove(tmpPath)
+	if err := write(tmp); err != nil {
+		tmp.Close()
+		return err
+	}
+	if err := tmp.Chmod(perm); err != nil {
+		tmp.Close()
+		return err
+	}
+	if err := tmp.Sync(); err != nil {
+		tmp.Close()
+		return err
+	}
+	if err := tmp.Close(); err != nil {
+		return err
+	}
+	if err := os.Rename(tmpPath, name); err != nil {
+		return err
+	}
+	// Persist the rename itself; not every platform can fsync a directory,
+	// so this is best effort.
+	if d, err := os.Open(dir); err == nil {
+		_ = d.Sync()
+		d.Close()
+	}
+	return nil
 }
 
-func configuredIgnoreMatchers() ([]globMatcher, error) {
+func (a *app) configuredIgnoreMatchers() ([]globMatcher, error) {
 	cfg, ok, err := loadCfgsConfig()
 	if err != nil {
 		return nil, err
 	}
-	patterns := defaultIgnoreGlobs
-	if ok && len(cfg.IgnoreGlobs) > 0 {
-		patterns = cfg.IgnoreGlobs
+	patterns := append([]string(nil), defaultIgnoreGlobs...)
+	if ok && len(cfg.IgnoreGlobs) > 0 {
+		patterns = append([]string(nil), cfg.IgnoreGlobs...)
+	}
+
+	var ignoreFiles []string
+	if configPath, err := cfgsConfigPath(); err == nil {
+		ignoreFiles = append(ignoreFiles, filepath.Join(filepath.Dir(configPath), "ignore"))
+	}
+	repoPath := strings.TrimSpace(a.repoOverride)
+	if repoPath == "" {
+		repoPath = strings.TrimSpace(os.Getenv("CFGS_REPO"))
+	}
+	if repoPath == "" && ok {
+		repoPath = cfg.RepoPath
+	}
+	if repoPath != "" {
+		ignoreFiles = append(ignoreFiles, filepath.Join(expandPath(repoPath), cfgsIgnoreFile))
+	}
+	for _, ignoreFile := range ignoreFiles {
+		filePatterns, err := readIgnoreFile(ignoreFile)
+		if err != nil {
+			return nil, err
+		}
+		patterns = append(patterns, filePatterns...)
+	}
+	return compileGlobMatchers(patterns)
+}
+
+// cfgsIgnoreFile is the repo-level ignore file; $XDG_CONFIG_HOME/cfgs/ignore
+// uses the same format for machine-local rules.
+const cfgsIgnoreFile = ".cfgsignore"
+
+// readIgnoreFile returns the glob lines of an ignore file, skipping blank
+// lines and # comments. A missing file has no patterns.
+func readIgnoreFile(ignorePath string) ([]string, error) {
+	data, err := os.ReadFile(ignorePath)
+	if errors.Is(err, fs.ErrNotExist) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, fmt.Errorf("read %s: %w", ignorePath, err)
+	}
+	var patterns []string
+	for _, line := range strings.Split(string(data), "\n") {
+		line = strings.TrimSpace(line)
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+		patterns = append(patterns, line)
+	}
+	return patterns, nil
+}
+
+func compileCommandRequirements(required map[string]string) ([]commandRequirement, error) {
+	patterns := make([]string, 0, len(required))
+	for pattern := range required {
+		patterns = append(patterns, pattern)
+	}
+	sort.Strings(patterns)
+
+	var requirements []commandRequirement
+	for _, pattern := range patterns {
+		command := strings.TrimSpace(required[pattern])
+		if command == "" {
+			continue
+		}
+		matchers, err := compileGlobMatchers([]string{pattern})
+		if err != nil {
+			return nil, fmt.Errorf("required_commands: %w", err)
+		}
+		for _, matcher := range matchers {
+			requirements = append(requirements, commandRequirement{matcher: matcher, command: command})
+		}
+	}
+	return requirements, nil
+}
+
+// hostFilter holds the per-machine rules that take a managed file out of
+// reconciliation: excluded_paths and required_commands.
+type hostFilter struct {
+	excluded     map[string]struct{}
+	requirements []commandRequirement
+	installed    map[string]bool
+}
+
+func newHostFilter(cfg cfgsConfig) (*hostFilter, error) {
+	requirements, err := compileCommandRequirements(cfg.RequiredCommands)
+	if err != nil {
+		return nil, err
+	}
+	return &hostFilter{
+		excluded:     sliceToSet(cfg.ExcludedPaths),
+		requirements: requirements,
+		installed:    map[string]bool{},
+	}, nil
+}
+
+// isExcluded reports whether rel is listed in excluded_paths. A host overlay
+// or host variant is excluded by the live path it stands in for.
+func (f *hostFilter) isExcluded(rel string) bool {
+	_, ok := f.excluded[managedLiveRel(rel)]
+	return ok
+}
+
+// skip returns why rel is left alone on this machine, or "" when it should be
+// reconciled.
+func (f *hostFilter) skip(rel string) string {
+	if f.isExcluded(rel) {
+		return "excluded on this machine"
+	}
+	if missing := missingRequiredCommand(managedLiveRel(rel), f.requirements, f.installed); missing != "" {
+		return "tool not installed: " + missing
+	}
+	return ""
+}
+
+// missingRequiredCommand returns the first command required for rel that is
+// not on PATH. Lookups are memoized in installed.
+func missingRequiredCommand(rel string, requirements []commandRequirement, installed map[string]bool) string {
+	for _, req := range requirements {
+		if !shouldIgnorePath(rel, false, []globMatcher{req.matcher}) {
+			continue
+		}
+		ok, seen := installed[req.command]
+		if !seen {
+			_, err := exec.LookPath(req.command)
+			ok = err == nil
+			installed[req.command] = ok
+		}
+		if !ok {
+			return req.command
+		}
+	}
+	return ""
+}
+
+// compileModeRules orders the file_modes globs most specific first, since a
+// JSON object keeps no order the user could rely on.
+func compileModeRules(modes map[string]string) ([]modeRule, error) {
+	patterns := make([]string, 0, len(modes))
+	for pattern := range modes {
+		patterns = append(patterns, pattern)
+	}
+	sort.Slice(patterns, func(i, j int) bool {
+		si, sj := globSpecificity(patterns[i]), globSpecificity(patterns[j])
+		if si != sj {
+			return si > sj
+		}
+		return patterns[i] < patterns[j]
+	})
+
+	var rules []modeRule
+	for _, pattern := range patterns {
+		mode, err := parseFileMode(strings.TrimSpace(modes[pattern]))
+		if err != nil {
+			return nil, fmt.Errorf("file_modes[%q]: %w", pattern, err)
+		}
+		matchers, err := compileGlobMatchers([]string{pattern})
+		if err != nil {
+			return nil, fmt.Errorf("file_modes: %w", err)
+		}
+		for _, matcher := range matchers {
+			rules = append(rules, modeRule{matcher: matcher, mode: mode})
+		}
+	}
+	return rules, nil
+}
+
+// globSpecificity ranks a glob by its literal characters, so "private/**"
+// outranks "**" and "private/ssh/*" outranks "private/**".
+func globSpecificity(pattern string) int {
+	n := 0
+	for _, r := range pattern {
+		if !strings.ContainsRune("*?[]{},!", r) {
+			n++
+		}
+	}
+	return n
+}
+
+// matchModeRule returns the mode of the first rule whose glob matches rel;
+// compileModeRules puts the most specific rule first.
+func matchModeRule(rel string, rules []modeRule) (fs.FileMode, bool) {
+	for _, rule := range rules {
+		if shouldIgnorePath(rel, false, []globMatcher{rule.matcher}) {
+			return rule.mode, true
+		}
+	}
+	return 0, false
+}
+
+// fixRepoFilePerms chmods every managed repo file whose permissions differ
+// from its wanted mode: the `add --mode` entry if there is one, else the
+// most specific file_modes glob that matches. Changed files are returned as
+// "path (old -> new)".
+func fixRepoFilePerms(repoPath string, managed []string, fileModes map[string]string) ([]string, []manualResolve, error) {
+	rules, err := compileModeRules(fileModes)
+	if err != nil {
+		return nil, nil, err
+	}
+	recorded, err := loadFileModes(repoPath)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	var fixed []string
+	var problems []manualResolve
+	for _, rel := range managed {
+		want, ok := recorded[rel]
+		if !ok {
+			want, ok = matchModeRule(rel, rules)
+		}
+		if !ok {
+			continue
+		}
+		repoFile := filepath.Join(repoPath, filepath.FromSlash(rel))
+		info, err := os.Stat(repoFile)
+		if err != nil || !info.Mode().IsRegular() {
+			continue
+		}
+		if info.Mode().Perm() == want {
+			continue
+		}
+		if err := os.Chmod(repoFile, want); err != nil {
+			problems = append(problems, manualResolve{path: rel, reason: fmt.Sprintf("fix permissions: %v", err)})
+			continue
+		}
+		fixed = append(fixed, fmt.Sprintf("%s (%04o -> %04o)", rel, info.Mode().Perm(), want))
+	}
+	return fixed, problems, nil
+}
+
+// sanitizeIgnoreGlobs normalizes patterns but keeps their order, since a
+// later "!pattern" overrides earlier matches.
+func sanitizeIgnoreGlobs(patterns []string) []string {
+	var out []string
+	for _, pattern := range patterns {
+		p := strings.TrimSpace(pattern)
+		negate := strings.HasPrefix(p, "!")
+		p = strings.TrimPrefix(p, "!")
+		if p == "" {
+			continue
+		}
+		p = braceEscapes.Replace(p)
+		p = strings.ReplaceAll(p, "\\", "/")
+		p = braceEscapePlaceholders.Replace(p)
+		p = strings.TrimPrefix(p, "./")
+		if negate {
+			p = "!" + p
+		}
+		out = append(out, p)
+	}
+	return out
+}
+
+// Brace escapes survive the backslash-to-slash normalization in
+// sanitizeIgnoreGlobs by passing through NUL-delimited placeholders.
+var (
+	braceEscapes            = strings.NewReplacer(`\{`, "\x00{", `\}`, "\x00}", `\,`, "\x00,")
+	braceEscapePlaceholders = strings.NewReplacer("\x00{", `\{`, "\x00}", `\}`, "\x00,", `\,`)
+	braceUnescaper          = strings.NewReplacer(`\{`, "{", `\}`, "}", `\,`, ",")
+)
+
+// expandBraces expands bash-style alternations such as "{Cache,logs}/**"
+// into one pattern per alternative. Groups may nest and alternatives may be
+// empty; braces without a top-level comma, and braces escaped with a
+// backslash, are kept literally.
+func expandBraces(pattern string) []string {
+	open, end, alternatives := findBraceGroup(pattern)
+	if open < 0 {
+		return []string{braceUnescaper.Replace(pattern)}
+	}
+	prefix, suffix := pattern[:open], pattern[end+1:]
+	var out []string
+	for _, alternative := range alternatives {
+		out = append(out, expandBraces(prefix+alternative+suffix)...)
+	}
+	return out
+}
+
+// findBraceGroup locates the first expandable brace group in pattern and
+// returns its bounds and top-level alternatives, or -1 when there is none.
+func findBraceGroup(pattern string) (int, int, []string) {
+	for open := 0; open < len(pattern); open++ {
+		if pattern[open] == '\\' {
+			open++
+			continue
+		}
+		if pattern[open] != '{' {
+			continue
+		}
+		depth := 0
+		start := open + 1
+		var alternatives []string
+		for i := open + 1; i < len(pattern); i++ {
+			switch pattern[i] {
+			case '\\':
+				i++
+			case '{':
+				depth++
+			case ',':
+				if depth == 0 {
+					alternatives = append(alternatives, pattern[start:i])
+					start = i + 1
+				}
+			case '}':
+				if depth > 0 {
+					depth--
+					continue
+				}
+				if len(alternatives) == 0 {
+					i = len(pattern)
+					continue
+				}
+				return open, i, append(alternatives, pattern[start:i])
+			}
+		}
+	}
+	return -1, -1, nil
+}
+
+// checkBraces rejects a pattern whose unescaped braces do not pair up, which
+// expandBraces would otherwise quietly keep as literal text.
+func checkBraces(pattern string) error {
+	var opens []int
+	for i := 0; i < len(pattern); i++ {
+		switch pattern[i] {
+		case '\\':
+			i++
+		case '{':
+			opens = append(opens, i)
+		case '}':
+			if len(opens) == 0 {
+				return fmt.Errorf("unbalanced brace: unexpected '}' at offset %d", i)
+			}
+			opens = opens[:len(opens)-1]
+		}
+	}
+	if len(opens) > 0 {
+		return fmt.Errorf("unbalanced brace: '{' at offset %d is never closed", opens[0])
 	}
-	return compileGlobMatchers(patterns)
+	return nil
 }
 
-func sanitizeIgnoreGlobs(patterns []string) []string {
+func sanitizeManagedPaths(paths []string) []string {
 	var out []string
-	for _, pattern := range patterns {
-		p := strings.TrimSpace(pattern)
-		if p == "" {
+	for _, raw := range paths {
+		rel, err := normalizeManagedPath(raw)
+		if err != nil {
 			continue
 		}
-		p = strings.ReplaceAll(p, "\\", "/")
-		p = strings.TrimPrefix(p, "./")
-		out = append(out, p)
+		out = append(out, rel)
 	}
 	sort.Strings(out)
 	return unique(out)
 }
 
+// compileGlobMatchers compiles patterns in order, one matcher per pattern.
+// Each matcher keeps the pattern as written so diagnostics can name it.
 func compileGlobMatchers(patterns []string) ([]globMatcher, error) {
 	patterns = sanitizeIgnoreGlobs(patterns)
 	matchers := make([]globMatcher, 0, len(patterns))
 	for _, pattern := range patterns {
-		src, err := globToRegex(pattern)
+		src, err := globToRegex(strings.TrimPrefix(pattern, "!"))
 		if err != nil {
 			return nil, fmt.Errorf("invalid ignore glob %q: %w", pattern, err)
 		}
@@ -1436,22 +7963,49 @@ func compileGlobMatchers(patterns []string) ([]globMatcher, error) {
 		matchers = append(matchers, globMatcher{
 			pattern: pattern,
 			regex:   re,
+			negate:  strings.HasPrefix(pattern, "!"),
 		})
 	}
 	return matchers, nil
 }
 
+// globToRegex compiles an ignore glob into an anchored regex. Like
+// gitignore, a pattern without a slash matches the last path component at any
+// depth; patterns containing a slash match the whole relative path. Brace
+// alternations are expanded first, duplicates dropped, and the alternatives
+// joined into one (?:...|...) group, each anchored by its own slash rule.
 func globToRegex(pattern string) (string, error) {
 	pattern = strings.TrimSpace(pattern)
 	if pattern == "" {
 		return "", fmt.Errorf("empty pattern")
 	}
+	if err := checkBraces(pattern); err != nil {
+		return "", err
+	}
+
+	var alternatives []string
+	seen := map[string]struct{}{}
+	for _, expanded := range expandBraces(pattern) {
+		if _, ok := seen[expanded]; ok {
+			continue
+		}
+		seen[expanded] = struct{}{}
+		alternatives = append(alternatives, globBodyToRegex(expanded))
+	}
+	if len(alternatives) == 1 {
+		return "^" + alternatives[0] + "$", nil
+	}
+	return "^(?:" + strings.Join(alternatives, "|") + ")$", nil
+}
 
+// globBodyToRegex translates one brace-free glob without the outer anchors.
+func globBodyToRegex(pattern string) string {
 	var b strings.Builder
-	b.WriteString("^")
+	if !strings.Contains(pattern, "/") {
+		b.WriteString("(?:.*/)?")
+	}
 	for i := 0; i < len(pattern); i++ {
-		ch := pattern[i]
-		switch ch {
+		switch pattern[i] {
 		case '*':
 			if i+1 < len(pattern) && pattern[i+1] == '*' {
 				b.WriteString(".*")
@@ -1462,46 +8016,216 @@ func globToRegex(pattern string) (string, error) {
 		case '?':
 			b.WriteString("[^/]")
 		default:
-			if strings.ContainsRune(`.+()|[]{}^$\\`, rune(ch)) {
-				b.WriteByte('\\')
-			}
-			b.WriteByte(ch)
+			// Everything but * and ? is literal, including brackets and
+			// braces left over after brace expansion.
+			b.WriteString(regexp.QuoteMeta(pattern[i : i+1]))
 		}
 	}
-	b.WriteString("$")
-	return b.String(), nil
+	return b.String()
 }
 
+// shouldIgnorePath reports whether rel is ignored. The last matching pattern
+// decides, and a path inherits the decision for its closest decided parent
+// directory, so "!pattern" can re-include a file inside an ignored directory.
 func shouldIgnorePath(rel string, isDir bool, matchers []globMatcher) bool {
+	ignored, _, _ := explainIgnore(rel, isDir, matchers)
+	return ignored
+}
+
+// explainIgnore is shouldIgnorePath that also returns the deciding matcher
+// and the path, rel or one of its parent directories, that it matched. The
+// matcher is nil when no pattern matched at all.
+func explainIgnore(rel string, isDir bool, matchers []globMatcher) (bool, *globMatcher, string) {
 	rel = strings.TrimSpace(filepath.ToSlash(rel))
 	if rel == "" || rel == "." {
-		return false
+		return false, nil, ""
+	}
+	var decided *globMatcher
+	matchedPath := ""
+	for i := 0; i < len(rel); i++ {
+		if rel[i] != '/' {
+			continue
+		}
+		if index := lastGlobMatch(rel[:i], true, matchers); index >= 0 {
+			decided, matchedPath = &matchers[index], rel[:i]
+		}
+	}
+	if index := lastGlobMatch(rel, isDir, matchers); index >= 0 {
+		decided, matchedPath = &matchers[index], rel
+	}
+	if decided == nil {
+		return false, nil, ""
+	}
+	return !decided.negate, decided, matchedPath
+}
+
+// lastGlobMatch returns the index of the last matcher that matches rel, or
+// -1 when none does.
+func lastGlobMatch(rel string, isDir bool, matchers []globMatcher) int {
+	last := -1
+	for i, matcher := range matchers {
+		if matcher.regex.MatchString(rel) || (isDir && matcher.regex.MatchString(rel+"/")) {
+			last = i
+		}
 	}
+	return last
+}
+
+// mayReincludeUnder reports whether a negated pattern could match something
+// below dir, in which case an ignored dir must still be walked. It is
+// conservative: any pattern that is not anchored away from dir counts.
+func mayReincludeUnder(dir string, matchers []globMatcher) bool {
+	prefix := dir + "/"
 	for _, matcher := range matchers {
-		if matcher.regex.MatchString(rel) {
+		if !matcher.negate {
+			continue
+		}
+		pattern := strings.TrimPrefix(matcher.pattern, "!")
+		if !strings.Contains(pattern, "/") {
 			return true
 		}
-		if isDir && matcher.regex.MatchString(rel+"/") {
+		literal := pattern
+		if i := strings.IndexAny(pattern, "*?{"); i >= 0 {
+			literal = pattern[:i]
+		}
+		if strings.HasPrefix(literal, prefix) || strings.HasPrefix(prefix, literal) {
 			return true
 		}
 	}
 	return false
 }
 
-func loadManagedFiles(repoPath string) ([]string, error) {
-	tracked, err := gitTrackedFiles(repoPath)
+// reportHostname is a.hostname, or "unknown-host" when it could not be
+// determined.
+func (a *app) reportHostname() string {
+	if a.hostname == "" {
+		return "unknown-host"
+	}
+	return a.hostname
+}
+
+// loadManagedFiles lists the repo paths this host manages, one per live path.
+// Files for other hosts are dropped, and when several repo files map to the
+// same live path the most specific one wins:
+//
+//  1. .cfgs/local/<hostname>/<path> (host overlay)
+//  2. <path>.host-<hostname> (host variant)
+//  3. <path> (shared)
+func (a *app) loadManagedFiles(repoPath string) ([]string, error) {
+	tracked, err := a.gitTrackedFiles(repoPath)
 	if err != nil {
 		return nil, err
 	}
-	var managed []string
+	host := a.hostname
+	type choice struct {
+		rel  string
+		rank int
+	}
+	dirs, err := loadManagedDirs(repoPath)
+	if err != nil {
+		return nil, err
+	}
+	chosen := map[string]choice{}
 	for _, rel := range tracked {
 		if isMetadataPath(rel) {
 			continue
 		}
-		managed = append(managed, rel)
+		if _, ok := managedDirFor(dirs, rel); ok {
+			continue
+		}
+		rank := 0
+		liveRel := rel
+		if overlayHost, overlayRel, ok := splitHostOverlay(liveRel); ok {
+			if overlayHost != host {
+				continue
+			}
+			rank, liveRel = 2, overlayRel
+		}
+		if variantHost, baseRel, ok := splitHostVariant(liveRel); ok {
+			if variantHost != host {
+				continue
+			}
+			rank, liveRel = max(rank, 1), baseRel
+		}
+		if current, ok := chosen[liveRel]; !ok || rank > current.rank {
+			chosen[liveRel] = choice{rel: rel, rank: rank}
+		}
+	}
+	managed := make([]string, 0, len(chosen))
+	for _, c := range chosen {
+		managed = append(managed, c.rel)
 	}
 	sort.Strings(managed)
-	return unique(managed), nil
+	return managed, nil
+}
+
+// hostVariantMarker separates a file name from the host it is meant for, as
+// in nvim/init.lua.host-laptop.
+const hostVariantMarker = ".host-"
+
+// splitHostVariant splits a host variant path into its host name and the
+// base path it replaces on that host.
+func splitHostVariant(rel string) (string, string, bool) {
+	name := path.Base(rel)
+	i := strings.LastIndex(name, hostVariantMarker)
+	if i <= 0 || i+len(hostVariantMarker) == len(name) {
+		return "", "", false
+	}
+	return name[i+len(hostVariantMarker):], path.Join(path.Dir(rel), name[:i]), true
+}
+
+// hostOverlayDir holds per-host subtrees, .cfgs/local/<hostname>/<live path>,
+// that are only linked on the host whose name matches.
+const hostOverlayDir = ".cfgs/local/"
+
+// splitHostOverlay splits a host overlay repo path into its host name and
+// the live path it maps to.
+func splitHostOverlay(rel string) (string, string, bool) {
+	rest, ok := strings.CutPrefix(rel, hostOverlayDir)
+	if !ok {
+		return "", "", false
+	}
+	host, liveRel, ok := strings.Cut(rest, "/")
+	if !ok || host == "" || liveRel == "" {
+		return "", "", false
+	}
+	return host, liveRel, true
+}
+
+// managedLiveRel maps a managed repo path to the path it occupies under the
+// roots, stripping any host overlay prefix and host variant suffix.
+func managedLiveRel(rel string) string {
+	if _, liveRel, ok := splitHostOverlay(rel); ok {
+		rel = liveRel
+	}
+	if _, baseRel, ok := splitHostVariant(rel); ok {
+		rel = baseRel
+	}
+	return rel
+}
+
+func managedLiveSet(managed []string) map[string]struct{} {
+	set := make(map[string]struct{}, len(managed))
+	for _, rel := range managed {
+		set[managedLiveRel(rel)] = struct{}{}
+	}
+	return set
+}
+
+// pointsToRepoAlias reports whether the symlink at liveFile targets another
+// repo path that maps to the same live path as rel, such as the shared file a
+// host overlay or host variant shadows.
+func pointsToRepoAlias(liveFile string, repoPath string, rel string) bool {
+	target, inRepo, err := symlinkRepoTarget(liveFile, filepath.Clean(repoPath))
+	if err != nil || !inRepo {
+		return false
+	}
+	targetRel, err := filepath.Rel(filepath.Clean(repoPath), target)
+	if err != nil {
+		return false
+	}
+	targetRel = filepath.ToSlash(targetRel)
+	return targetRel != rel && managedLiveRel(targetRel) == managedLiveRel(rel)
 }
 
 func normalizeManagedPath(rel string) (string, error) {
@@ -1513,7 +8237,7 @@ func normalizeManagedPath(rel string) (string, error) {
 	if strings.HasPrefix(rel, "/") {
 		return "", fmt.Errorf("absolute paths are not allowed")
 	}
-	if strings.HasPrefix(rel, "../") || strings.Contains(rel, "/../") {
+	if rel == ".." || strings.HasPrefix(rel, "../") {
 		return "", fmt.Errorf("path traversal is not allowed")
 	}
 	if isMetadataPath(rel) {
@@ -1523,8 +8247,153 @@ func normalizeManagedPath(rel string) (string, error) {
 }
 
 func isMetadataPath(rel string) bool {
+	if _, _, ok := splitHostOverlay(rel); ok {
+		return false
+	}
 	return rel == ".git" ||
-		strings.HasPrefix(rel, ".git/")
+		strings.HasPrefix(rel, ".git/") ||
+		rel == cfgsIgnoreFile ||
+		rel == ".cfgs" ||
+		strings.HasPrefix(rel, ".cfgs/")
+}
+
+// parseFileMode parses an octal permission such as "600" or "0755". The owner
+// must keep read access, otherwise the linked file would be unusable.
+func parseFileMode(value string) (fs.FileMode, error) {
+	parsed, err := strconv.ParseUint(strings.TrimPrefix(value, "0o"), 8, 32)
+	if err != nil || parsed > 0o777 {
+		return 0, fmt.Errorf("invalid mode %q: expected octal permission bits like 600 or 0644", value)
+	}
+	mode := fs.FileMode(parsed)
+	if mode&0o400 == 0 {
+		return 0, fmt.Errorf("invalid mode %q: owner must be able to read the file", value)
+	}
+	return mode, nil
+}
+
+// loadFileModes reads the modes manifest; a missing manifest is empty.
+func loadFileModes(repoPath string) (map[string]fs.FileMode, error) {
+	modes := map[string]fs.FileMode{}
+	data, err := os.ReadFile(filepath.Join(repoPath, filepath.FromSlash(modesManifestPath)))
+	if errors.Is(err, fs.ErrNotExist) {
+		return modes, nil
+	}
+	if err != nil {
+		return nil, fmt.Errorf("read %s: %w", modesManifestPath, err)
+	}
+	for i, line := range strings.Split(string(data), "\n") {
+		line = strings.TrimSpace(line)
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+		perm, rel, ok := strings.Cut(line, " ")
+		if !ok {
+			return nil, fmt.Errorf("%s:%d: expected \"<mode> <path>\"", modesManifestPath, i+1)
+		}
+		mode, err := parseFileMode(perm)
+		if err != nil {
+			return nil, fmt.Errorf("%s:%d: %w", modesManifestPath, i+1, err)
+		}
+		modes[strings.TrimSpace(rel)] = mode
+	}
+	return modes, nil
+}
+
+// saveFileModes rewrites the modes manifest sorted by path, removing it once
+// no entries remain.
+func saveFileModes(repoPath string, modes map[string]fs.FileMode) error {
+	manifest := filepath.Join(repoPath, filepath.FromSlash(modesManifestPath))
+	if len(modes) == 0 {
+		if err := os.Remove(manifest); err != nil && !errors.Is(err, fs.ErrNotExist) {
+			return err
+		}
+		removeEmptyDirsUpward(repoPath, filepath.Dir(manifest))
+		return nil
+	}
+	paths := make([]string, 0, len(modes))
+	for rel := range modes {
+		paths = append(paths, rel)
+	}
+	sort.Strings(paths)
+	var b strings.Builder
+	for _, rel := range paths {
+		fmt.Fprintf(&b, "%04o %s\n", modes[rel], rel)
+	}
+	if err := os.MkdirAll(filepath.Dir(manifest), 0o755); err != nil {
+		return err
+	}
+	return os.WriteFile(manifest, []byte(b.String()), 0o644)
+}
+
+// recordFileModes stores mode for every path in tracked that was not already
+// managed before the add.
+func recordFileModes(repoPath string, before map[string]struct{}, tracked map[string]struct{}, mode fs.FileMode) error {
+	modes, err := loadFileModes(repoPath)
+	if err != nil {
+		return err
+	}
+	for rel := range tracked {
+		if _, ok := before[rel]; !ok {
+			modes[rel] = mode
+		}
+	}
+	return saveFileModes(repoPath, modes)
+}
+
+// loadManagedDirs reads the tracked-directory manifest; a missing manifest is
+// empty.
+func loadManagedDirs(repoPath string) ([]string, error) {
+	data, err := os.ReadFile(filepath.Join(repoPath, filepath.FromSlash(dirsManifestPath)))
+	if errors.Is(err, fs.ErrNotExist) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, fmt.Errorf("read %s: %w", dirsManifestPath, err)
+	}
+	var dirs []string
+	for i, line := range strings.Split(string(data), "\n") {
+		line = strings.TrimSpace(line)
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+		rel, err := normalizeManagedPath(line)
+		if err != nil {
+			return nil, fmt.Errorf("%s:%d: %w", dirsManifestPath, i+1, err)
+		}
+		dirs = append(dirs, rel)
+	}
+	return unique(dirs), nil
+}
+
+// saveManagedDirs rewrites the tracked-directory manifest sorted by path,
+// removing it once no entries remain.
+func saveManagedDirs(repoPath string, dirs []string) error {
+	manifest := filepath.Join(repoPath, filepath.FromSlash(dirsManifestPath))
+	if len(dirs) == 0 {
+		if err := os.Remove(manifest); err != nil && !errors.Is(err, fs.ErrNotExist) {
+			return err
+		}
+		removeEmptyDirsUpward(repoPath, filepath.Dir(manifest))
+		return nil
+	}
+	var b strings.Builder
+	for _, rel := range unique(dirs) {
+		fmt.Fprintln(&b, rel)
+	}
+	if err := os.MkdirAll(filepath.Dir(manifest), 0o755); err != nil {
+		return err
+	}
+	return os.WriteFile(manifest, []byte(b.String()), 0o644)
+}
+
+// managedDirFor returns the tracked directory that is rel or contains it.
+func managedDirFor(dirs []string, rel string) (string, bool) {
+	for _, dir := range dirs {
+		if rel == dir || strings.HasPrefix(rel, dir+"/") {
+			return dir, true
+		}
+	}
+	return "", false
 }
 
 func sliceToSet(values []string) map[string]struct{} {
@@ -1556,13 +8425,28 @@ func moveFile(src string, dst string) error {
 		return err
 	}
 
-	if err := copyFile(src, dst); err != nil {
+	if err := copyFileWith(src, dst, copyFileOptions{preserveTimes: true}); err != nil {
 		return err
 	}
 	return os.Remove(src)
 }
 
+// copyFileOptions tunes what copyFileWith carries over besides contents and
+// mode.
+type copyFileOptions struct {
+	// preserveTimes copies the source's modification time to dst.
+	preserveTimes bool
+}
+
+// copyFile copies src to dst, preserving its permission bits.
 func copyFile(src string, dst string) error {
+	return copyFileWith(src, dst, copyFileOptions{})
+}
+
+// copyFileWith copies src to dst. The destination always ends up with the
+// source's permission, setuid, setgid and sticky bits, even when it already
+// existed or the umask would have masked them at creation.
+func copyFileWith(src string, dst string, opts copyFileOptions) error {
 	srcInfo, err := os.Stat(src)
 	if err != nil {
 		return err
@@ -1590,21 +8474,111 @@ func copyFile(src string, dst string) error {
 	if _, err := io.Copy(target, source); err != nil {
 		return err
 	}
+	if err := target.Close(); err != nil {
+		return err
+	}
+
+	mode := srcInfo.Mode() & (os.ModePerm | os.ModeSetuid | os.ModeSetgid | os.ModeSticky)
+	if err := os.Chmod(dst, mode); err != nil {
+		return err
+	}
+	if opts.preserveTimes {
+		return os.Chtimes(dst, srcInfo.ModTime(), srcInfo.ModTime())
+	}
 	return nil
 }
 
+func clearWriteBits(path string) error {
+	info, err := os.Stat(path)
+	if err != nil {
+		return err
+	}
+	return os.Chmod(path, info.Mode().Perm()&^0o222)
+}
+
+func addOwnerWriteBit(path string) error {
+	info, err := os.Stat(path)
+	if err != nil {
+		return err
+	}
+	return os.Chmod(path, info.Mode().Perm()|0o200)
+}
+
+// filesEqual reports whether left and right have identical contents. Sizes
+// are compared first; equal-sized files are streamed in chunks so large files
+// are never held in memory.
 func filesEqual(left string, right string) (bool, error) {
-	leftData, err := os.ReadFile(left)
+	leftInfo, err := os.Stat(left)
+	if err != nil {
+		return false, err
+	}
+	rightInfo, err := os.Stat(right)
 	if err != nil {
 		return false, err
 	}
-	rightData, err := os.ReadFile(right)
+	if leftInfo.Size() != rightInfo.Size() {
+		return false, nil
+	}
+
+	leftFile, err := os.Open(left)
+	if err != nil {
+		return false, err
+	}
+	defer leftFile.Close()
+	rightFile, err := os.Open(right)
 	if err != nil {
 		return false, err
 	}
-	return bytes.Equal(leftData, rightData), nil
+	defer rightFile.Close()
+
+	const chunkSize = 64 * 1024
+	leftBuf := make([]byte, chunkSize)
+	rightBuf := make([]byte, chunkSize)
+	for {
+		leftN, leftErr := io.ReadFull(leftFile, leftBuf)
+		rightN, rightErr := io.ReadFull(rightFile, rightBuf)
+		if !bytes.Equal(leftBuf[:leftN], rightBuf[:rightN]) {
+			return false, nil
+		}
+		leftDone := leftErr == io.EOF || leftErr == io.ErrUnexpectedEOF
+		rightDone := rightErr == io.EOF || rightErr == io.ErrUnexpectedEOF
+		if leftErr != nil && !leftDone {
+			return false, leftErr
+		}
+		if rightErr != nil && !rightDone {
+			return false, rightErr
+		}
+		if leftDone || rightDone {
+			return leftDone == rightDone, nil
+		}
+	}
+}
+
+// createSymlink links liveFile to repoFile using the given link mode.
+func createSymlink(repoFile string, liveFile string, mode string) error {
+	target := repoFile
+	if mode == linkModeRelative {
+		rel, err := filepath.Rel(filepath.Dir(liveFile), repoFile)
+		if err != nil {
+			return err
+		}
+		target = rel
+	}
+	return os.Symlink(target, liveFile)
 }
 
+// symlinkStyle reports whether linkPath stores an absolute or relative target.
+func symlinkStyle(linkPath string) string {
+	target, err := os.Readlink(linkPath)
+	if err != nil || filepath.IsAbs(target) {
+		return linkModeAbsolute
+	}
+	return linkModeRelative
+}
+
+// symlinkPointsTo reports whether linkPath resolves to targetPath. Both sides
+// go through symlink resolution, so a target reached through a linked
+// directory still matches.
 func symlinkPointsTo(linkPath string, targetPath string) (bool, error) {
 	resolved, err := filepath.EvalSymlinks(linkPath)
 	if err != nil {
@@ -1614,13 +8588,17 @@ func symlinkPointsTo(linkPath string, targetPath string) (bool, error) {
 	if err != nil {
 		return false, err
 	}
-	targetAbs, err := filepath.Abs(targetPath)
+	targetResolved, err := resolvePath(targetPath)
 	if err != nil {
 		return false, err
 	}
-	return filepath.Clean(resolvedAbs) == filepath.Clean(targetAbs), nil
+	return filepath.Clean(resolvedAbs) == filepath.Clean(targetResolved), nil
 }
 
+// removeEmptyDirsUpward removes dir and its empty parents, stopping at root.
+// It relies on rmdir refusing non-empty directories rather than checking
+// emptiness first, so a file created concurrently is never lost, and it never
+// touches root itself or anything outside it.
 func removeEmptyDirsUpward(root string, dir string) {
 	root = filepath.Clean(root)
 	dir = filepath.Clean(dir)
@@ -1628,8 +8606,15 @@ func removeEmptyDirsUpward(root string, dir string) {
 		if dir == root || dir == "." || dir == "/" {
 			return
 		}
-		entries, err := os.ReadDir(dir)
-		if err != nil || len(entries) > 0 {
+		within, err := pathWithin(root, dir)
+		if err != nil || !within {
+			return
+		}
+		// A symlink to a directory would be unlinked by os.Remove even though
+		// it is not an empty directory of ours, so only real directories are
+		// candidates.
+		info, err := os.Lstat(dir)
+		if err != nil || !info.IsDir() {
 			return
 		}
 		if err := os.Remove(dir); err != nil {
@@ -1639,12 +8624,108 @@ func removeEmptyDirsUpward(root string, dir string) {
 	}
 }
 
+// addNoCommitFlag registers --no-commit for commands whose changes can be
+// batched.
+func (a *app) addNoCommitFlag(flags *flag.FlagSet) {
+	flags.BoolVar(&a.noCommit, "no-commit", false, "leave changes uncommitted so several operations can be committed together")
+}
+
+// addYesFlags registers --yes and --push for commands that prompt.
+func (a *app) addYesFlags(flags *flag.FlagSet) {
+	flags.BoolVar(&a.assumeYes, "yes", false, "take the default answer to every prompt, for unattended runs")
+	flags.BoolVar(&a.assumeYes, "y", false, "shorthand for --yes")
+	flags.BoolVar(&a.pushWithoutAsking, "push", false, "push new commits without asking")
+}
+
+// addCommitMessageFlags registers -m/--message for commands that commit.
+func (a *app) addCommitMessageFlags(flags *flag.FlagSet) {
+	flags.StringVar(&a.commitMessage, "message", "", "commit with `msg` instead of opening an editor")
+	flags.StringVar(&a.commitMessage, "m", "", "shorthand for --message")
+}
+
+func (a *app) newFlagSet(command string) *flag.FlagSet {
+	fs := flag.NewFlagSet("cfgs "+command, flag.ContinueOnError)
+	fs.SetOutput(a.errOut)
+	fs.BoolVar(&a.verbose, "verbose", false, "log every filesystem check and change to stderr")
+	return fs
+}
+
+// tracef writes one --verbose trace line.
+func (a *app) tracef(format string, args ...any) {
+	if a.verbose {
+		fmt.Fprintf(a.errOut, "trace: "+format+"\n", args...)
+	}
+}
+
+// describeStat renders the result of a Stat or Lstat call for traces.
+func describeStat(info fs.FileInfo, err error) string {
+	var pathErr *fs.PathError
+	if errors.As(err, &pathErr) {
+		return pathErr.Err.Error()
+	}
+	if err != nil {
+		return err.Error()
+	}
+	return info.Mode().String()
+}
+
+func (a *app) parseNoArgs(command string, args []string) error {
+	return parseNoPositional(a.newFlagSet(command), args)
+}
+
+// parseFlags parses args with fs, allowing flags and positional arguments to
+// be interleaved, and returns the positional arguments in order.
+func parseFlags(fs *flag.FlagSet, args []string) ([]string, error) {
+	var positional []string
+	for {
+		if err := fs.Parse(args); err != nil {
+			return nil, err
+		}
+		rest := fs.Args()
+		if consumed := len(args) - len(rest); consumed > 0 && args[consumed-1] == "--" {
+			return append(positional, rest...), nil
+		}
+		if len(rest) == 0 {
+			return positional, nil
+		}
+		positional = append(positional, rest[0])
+		args = rest[1:]
+	}
+}
+
+// stringListFlag collects every value of a repeatable flag.
+type stringListFlag []string
+
+func (f *stringListFlag) String() string {
+	return strings.Join(*f, ",")
+}
+
+func (f *stringListFlag) Set(value string) error {
+	*f = append(*f, value)
+	return nil
+}
+
+func parseNoPositional(fs *flag.FlagSet, args []string) error {
+	positional, err := parseFlags(fs, args)
+	if err != nil {
+		return err
+	}
+	if len(positional) > 0 {
+		return fmt.Errorf("%s: unexpected arguments: %s", fs.Name(), strings.Join(positional, " "))
+	}
+	return nil
+}
+
 func (a *app) promptLine(label string, defaultValue string) (string, error) {
 	if defaultValue != "" {
 		fmt.Fprintf(a.out, "%s [%s]: ", label, defaultValue)
 	} else {
 		fmt.Fprintf(a.out, "%s: ", label)
 	}
+	if a.assumeYes {
+		fmt.Fprintln(a.out, defaultValue)
+		return defaultValue, nil
+	}
 
 	text, err := a.in.ReadString('\n')
 	if err != nil && !errors.Is(err, io.EOF) {
@@ -1657,6 +8738,45 @@ func (a *app) promptLine(label string, defaultValue string) (string, error) {
 	return text, nil
 }
 
+// Answers to promptConflict.
+const (
+	conflictKeepLive = "live"
+	conflictKeepRepo = "repo"
+	conflictSkip     = "skip"
+)
+
+// promptConflict asks how to resolve a live file that differs from the repo,
+// showing the diff as often as asked. Skipping is the default, and the only
+// answer under --yes, since either other choice discards one version.
+func (a *app) promptConflict(rel string, repoFile string, liveFile string) (string, error) {
+	question := fmt.Sprintf("%s: live file differs from repo. Keep [l]ive, keep [r]epo, [s]kip, or show [d]iff?", rel)
+	if a.assumeYes {
+		fmt.Fprintf(a.out, "%s [s]: skip\n", question)
+		return conflictSkip, nil
+	}
+	for {
+		fmt.Fprintf(a.out, "%s [s]: ", question)
+		text, err := a.in.ReadString('\n')
+		if err != nil && !errors.Is(err, io.EOF) {
+			return "", err
+		}
+		switch strings.TrimSpace(strings.ToLower(text)) {
+		case "l", "live":
+			return conflictKeepLive, nil
+		case "r", "repo":
+			return conflictKeepRepo, nil
+		case "", "s", "skip":
+			return conflictSkip, nil
+		case "d", "diff":
+			if err := a.writeFileDiff(a.out, rel, repoFile, liveFile); err != nil {
+				return "", err
+			}
+		default:
+			fmt.Fprintln(a.out, "Please answer l, r, s, or d.")
+		}
+	}
+}
+
 func (a *app) promptYesNo(question string, defaultYes bool) (bool, error) {
 	var suffix string
 	if defaultYes {
@@ -1665,6 +8785,14 @@ func (a *app) promptYesNo(question string, defaultYes bool) (bool, error) {
 		suffix = "[y/N]"
 	}
 
+	if a.assumeYes {
+		answer := "no"
+		if defaultYes {
+			answer = "yes"
+		}
+		fmt.Fprintf(a.out, "%s %s: %s\n", question, suffix, answer)
+		return defaultYes, nil
+	}
 	for {
 		fmt.Fprintf(a.out, "%s %s: ", question, suffix)
 		text, err := a.in.ReadString('\n')
@@ -0,0 +1,2166 @@
+package main
+
+import (
+	"archive/tar"
+	"bufio"
+	"bytes"
+	"compress/gzip"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"io/fs"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"regexp"
+	"slices"
+	"sort"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestRemoveEmptyDirsUpward(t *testing.T) {
+	t.Run("stops at root", func(t *testing.T) {
+		root := t.TempDir()
+		dir := filepath.Join(root, "a", "b")
+		if err := os.MkdirAll(dir, 0o755); err != nil {
+			t.Fatal(err)
+		}
+		removeEmptyDirsUpward(root, dir)
+		if _, err := os.Stat(filepath.Join(root, "a")); !os.IsNotExist(err) {
+			t.Fatalf("a should have been removed, stat err = %v", err)
+		}
+		if _, err := os.Stat(root); err != nil {
+			t.Fatalf("root must survive: %v", err)
+		}
+	})
+
+	t.Run("concurrent create keeps populated parent", func(t *testing.T) {
+		root := t.TempDir()
+		dir := filepath.Join(root, "a", "b")
+		if err := os.MkdirAll(dir, 0o755); err != nil {
+			t.Fatal(err)
+		}
+		// Another process populated a after b was emptied.
+		if err := os.WriteFile(filepath.Join(root, "a", "new"), []byte("x"), 0o644); err != nil {
+			t.Fatal(err)
+		}
+		removeEmptyDirsUpward(root, dir)
+		if _, err := os.Stat(dir); !os.IsNotExist(err) {
+			t.Fatalf("b should have been removed, stat err = %v", err)
+		}
+		if _, err := os.Stat(filepath.Join(root, "a", "new")); err != nil {
+			t.Fatalf("file created concurrently must survive: %v", err)
+		}
+	})
+
+	t.Run("outside root untouched", func(t *testing.T) {
+		root := t.TempDir()
+		outside := t.TempDir()
+		dir := filepath.Join(outside, "empty")
+		if err := os.Mkdir(dir, 0o755); err != nil {
+			t.Fatal(err)
+		}
+		removeEmptyDirsUpward(root, dir)
+		if _, err := os.Stat(dir); err != nil {
+			t.Fatalf("directory outside root must survive: %v", err)
+		}
+	})
+
+	t.Run("symlink to directory is not unlinked", func(t *testing.T) {
+		root := t.TempDir()
+		target := t.TempDir()
+		link := filepath.Join(root, "link")
+		if err := os.Symlink(target, link); err != nil {
+			t.Fatal(err)
+		}
+		removeEmptyDirsUpward(root, link)
+		if _, err := os.Lstat(link); err != nil {
+			t.Fatalf("symlink must survive: %v", err)
+		}
+		if _, err := os.Stat(target); err != nil {
+			t.Fatalf("symlink target must survive: %v", err)
+		}
+	})
+}
+
+func mustGlobMatchers(t *testing.T, patterns ...string) []globMatcher {
+	t.Helper()
+	matchers, err := compileGlobMatchers(patterns)
+	if err != nil {
+		t.Fatalf("compileGlobMatchers(%q): %v", patterns, err)
+	}
+	return matchers
+}
+
+func TestShouldIgnorePathBasenamePatterns(t *testing.T) {
+	tests := []struct {
+		pattern string
+		rel     string
+		want    bool
+	}{
+		{".DS_Store", ".DS_Store", true},
+		{".DS_Store", "nvim/.DS_Store", true},
+		{".DS_Store", "a/b/c/.DS_Store", true},
+		{".DS_Store", "nvim/.DS_Store.bak", false},
+		{"*.swp", "init.lua.swp", true},
+		{"*.swp", "nvim/lua/init.lua.swp", true},
+		{"*.swp", "nvim/swp", false},
+		// Patterns with a slash stay anchored to the whole relative path.
+		{"nvim/*.swp", "nvim/init.swp", true},
+		{"nvim/*.swp", "other/nvim/init.swp", false},
+		{"nvim/*.swp", "nvim/lua/init.swp", false},
+		{"**/cache", "a/b/cache", true},
+	}
+	for _, tt := range tests {
+		matchers := mustGlobMatchers(t, tt.pattern)
+		if got := shouldIgnorePath(tt.rel, false, matchers); got != tt.want {
+			t.Errorf("shouldIgnorePath(%q) with %q = %v, want %v", tt.rel, tt.pattern, got, tt.want)
+		}
+	}
+}
+
+func TestRemoveTrackedFileRollsBackOnRemoveFailure(t *testing.T) {
+	dir := t.TempDir()
+	repoFile := filepath.Join(dir, "repo", "app", "config")
+	liveFile := filepath.Join(dir, "live", "app", "config")
+	if err := os.MkdirAll(filepath.Dir(repoFile), 0o755); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.MkdirAll(filepath.Dir(liveFile), 0o755); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(repoFile, []byte("tracked\n"), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.Symlink(repoFile, liveFile); err != nil {
+		t.Fatal(err)
+	}
+
+	injected := errors.New("injected failure")
+	err := removeTrackedFile(repoFile, liveFile, false, func(string) error { return injected })
+	if !errors.Is(err, injected) {
+		t.Fatalf("removeTrackedFile error = %v, want the injected failure", err)
+	}
+	if !strings.Contains(err.Error(), "restored") {
+		t.Errorf("error %q should say the live path was restored", err)
+	}
+	if ok, err := symlinkPointsTo(liveFile, repoFile); err != nil || !ok {
+		t.Fatalf("live file should be the original symlink again (ok=%v, err=%v)", ok, err)
+	}
+	if _, err := os.Stat(repoFile); err != nil {
+		t.Fatalf("repo file must still exist: %v", err)
+	}
+}
+
+func TestRemoveTrackedFileLeavesLiveCopy(t *testing.T) {
+	dir := t.TempDir()
+	repoFile := filepath.Join(dir, "repo-config")
+	liveFile := filepath.Join(dir, "live-config")
+	if err := os.WriteFile(repoFile, []byte("tracked\n"), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.Symlink(repoFile, liveFile); err != nil {
+		t.Fatal(err)
+	}
+	if err := removeTrackedFile(repoFile, liveFile, false, os.Remove); err != nil {
+		t.Fatal(err)
+	}
+	info, err := os.Lstat(liveFile)
+	if err != nil || !info.Mode().IsRegular() {
+		t.Fatalf("live file should be a regular copy (err=%v)", err)
+	}
+	if data, _ := os.ReadFile(liveFile); string(data) != "tracked\n" {
+		t.Errorf("live copy = %q", data)
+	}
+	if _, err := os.Stat(repoFile); !os.IsNotExist(err) {
+		t.Errorf("repo file should be gone, stat err = %v", err)
+	}
+}
+
+// testEnv is a throwaway HOME with an XDG config dir, a cfgs config and a
+// git repo, so commands run against real files without touching the user's.
+type testEnv struct {
+	t      testing.TB
+	home   string
+	xdg    string
+	repo   string
+	out    bytes.Buffer
+	errOut bytes.Buffer
+}
+
+func newTestEnv(t testing.TB, cfg cfgsConfig) *testEnv {
+	t.Helper()
+	home := t.TempDir()
+	e := &testEnv{
+		t:    t,
+		home: home,
+		xdg:  filepath.Join(home, ".config"),
+		repo: filepath.Join(home, "dotfiles"),
+	}
+	t.Setenv("HOME", home)
+	t.Setenv("XDG_CONFIG_HOME", e.xdg)
+	t.Setenv("CFGS_CONFIG", "")
+	t.Setenv("CFGS_REPO", "")
+	t.Setenv("GIT_CONFIG_NOSYSTEM", "1")
+	t.Setenv("GIT_AUTHOR_NAME", "test")
+	t.Setenv("GIT_AUTHOR_EMAIL", "test@example.com")
+	t.Setenv("GIT_COMMITTER_NAME", "test")
+	t.Setenv("GIT_COMMITTER_EMAIL", "test@example.com")
+	if err := os.MkdirAll(e.repo, 0o755); err != nil {
+		t.Fatal(err)
+	}
+	e.git("init", "-q")
+	e.git("commit", "-q", "--allow-empty", "-m", "init")
+	remote := filepath.Join(home, "remote.git")
+	if _, err := (execRunner{}).Capture("", "git", "init", "-q", "--bare", remote); err != nil {
+		t.Fatal(err)
+	}
+	e.git("remote", "add", "origin", remote)
+	cfg.RepoPath = e.repo
+	if err := saveCfgsConfig(cfg); err != nil {
+		t.Fatal(err)
+	}
+	return e
+}
+
+func (e *testEnv) app() *app {
+	return &app{
+		in:       bufio.NewReader(strings.NewReader("")),
+		out:      &e.out,
+		errOut:   &e.errOut,
+		runner:   execRunner{},
+		hostname: "laptop",
+	}
+}
+
+func (e *testEnv) git(args ...string) string {
+	e.t.Helper()
+	out, err := execRunner{}.Capture(e.repo, "git", args...)
+	if err != nil {
+		e.t.Fatalf("git %v: %v", args, err)
+	}
+	return out
+}
+
+// track commits content at rel in the repo.
+func (e *testEnv) track(rel string, content string) string {
+	e.t.Helper()
+	repoFile := filepath.Join(e.repo, filepath.FromSlash(rel))
+	writeTestFile(e.t, repoFile, content)
+	e.git("add", "--", rel)
+	e.git("commit", "-q", "-m", "track "+rel)
+	return repoFile
+}
+
+// live returns the live path of a file under the default config root.
+func (e *testEnv) live(rel string) string {
+	return filepath.Join(e.xdg, filepath.FromSlash(rel))
+}
+
+func writeTestFile(t testing.TB, name string, content string) {
+	t.Helper()
+	if err := os.MkdirAll(filepath.Dir(name), 0o755); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(name, []byte(content), 0o644); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestReportDriftAutoLinkHonorsHostFilters(t *testing.T) {
+	e := newTestEnv(t, cfgsConfig{
+		ExcludedPaths:    []string{"work/config"},
+		RequiredCommands: map[string]string{"tool/**": "cfgs-test-missing-tool"},
+	})
+	plainRepo := e.track("app/config", "plain\n")
+	e.track("work/config", "work\n")
+	e.track("tool/config", "tool\n")
+
+	a := e.app()
+	roots, err := configuredRoots()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := a.reportDrift(e.repo, roots, true); err != nil {
+		t.Fatal(err)
+	}
+
+	if ok, err := symlinkPointsTo(e.live("app/config"), plainRepo); err != nil || !ok {
+		t.Errorf("app/config should be auto-linked (ok=%v, err=%v)", ok, err)
+	}
+	for _, rel := range []string{"work/config", "tool/config"} {
+		if _, err := os.Lstat(e.live(rel)); !os.IsNotExist(err) {
+			t.Errorf("%s must not be auto-linked, lstat err = %v", rel, err)
+		}
+		if strings.Contains(e.out.String(), rel) {
+			t.Errorf("%s must not be reported as drift:\n%s", rel, e.out.String())
+		}
+	}
+}
+
+func TestExpandBraces(t *testing.T) {
+	tests := []struct {
+		pattern string
+		want    []string
+	}{
+		{"{Cache,cache,logs}/**", []string{"Cache/**", "cache/**", "logs/**"}},
+		{"{a,b}/{c,d}", []string{"a/c", "a/d", "b/c", "b/d"}},
+		{"a{b,c{d,e}}f", []string{"abf", "acdf", "acef"}},
+		{"x{,.bak}", []string{"x", "x.bak"}},
+		{`\{a,b\}`, []string{"{a,b}"}},
+		{`{a\,b,c}`, []string{"a,b", "c"}},
+		{"a{b}.txt", []string{"a{b}.txt"}},
+		{"plain", []string{"plain"}},
+	}
+	for _, tt := range tests {
+		if got := expandBraces(tt.pattern); !slices.Equal(got, tt.want) {
+			t.Errorf("expandBraces(%q) = %q, want %q", tt.pattern, got, tt.want)
+		}
+	}
+}
+
+func TestBraceGlobsMatch(t *testing.T) {
+	tests := []struct {
+		pattern string
+		rel     string
+		want    bool
+	}{
+		{"{Cache,cache,logs}/**", "logs/today", true},
+		{"{Cache,cache,logs}/**", "tmp/today", false},
+		{"a{b,c{d,e}}f", "acef", true},
+		{"a{b,c{d,e}}f", "acf", false},
+		{"x{,.bak}", "x", true},
+		{"x{,.bak}", "x.bak", true},
+		{`\{a,b\}`, "{a,b}", true},
+		{`\{a,b\}`, "a", false},
+	}
+	for _, tt := range tests {
+		matchers := mustGlobMatchers(t, tt.pattern)
+		if got := shouldIgnorePath(tt.rel, false, matchers); got != tt.want {
+			t.Errorf("shouldIgnorePath(%q) with %q = %v, want %v", tt.rel, tt.pattern, got, tt.want)
+		}
+	}
+}
+
+func TestGlobToRegexDedupsBraceAlternatives(t *testing.T) {
+	got, err := globToRegex("{a,a,b,a}")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if want := "^(?:(?:.*/)?a|(?:.*/)?b)$"; got != want {
+		t.Errorf("globToRegex = %q, want %q", got, want)
+	}
+}
+
+func TestDoctorFleetReportMode(t *testing.T) {
+	e := newTestEnv(t, cfgsConfig{})
+	linkedRepo := e.track("app/linked", "repo\n")
+	e.track("app/diverged", "repo\n")
+	writeTestFile(t, e.live("app/diverged"), "edited on this machine\n")
+	reportFile := filepath.Join(t.TempDir(), "cfgs.json")
+
+	a := e.app()
+	code := a.run(context.Background(), []string{"doctor", "--json", "--report-file", reportFile, "--keep-going", "--quiet"})
+	if code != 0 {
+		t.Fatalf("exit code = %d, want 0; stderr:\n%s", code, e.errOut.String())
+	}
+	if e.out.Len() != 0 {
+		t.Errorf("stdout should be empty, got:\n%s", e.out.String())
+	}
+
+	data, err := os.ReadFile(reportFile)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var report doctorReportJSON
+	if err := json.Unmarshal(data, &report); err != nil {
+		t.Fatalf("report is not JSON: %v\n%s", err, data)
+	}
+	if report.Host == "" {
+		t.Error("report has no host")
+	}
+	if _, err := time.Parse(time.RFC3339, report.Timestamp); err != nil {
+		t.Errorf("report timestamp %q: %v", report.Timestamp, err)
+	}
+	if report.RepoPath != e.repo {
+		t.Errorf("repo_path = %q, want %q", report.RepoPath, e.repo)
+	}
+	if !slices.Contains(report.ReplacedWithSymlink, "app/linked") {
+		t.Errorf("replaced_with_symlink = %q, want app/linked", report.ReplacedWithSymlink)
+	}
+	if len(report.RequireManualResolve) != 1 || report.RequireManualResolve[0].Path != "app/diverged" {
+		t.Errorf("require_manual_resolve = %+v, want app/diverged", report.RequireManualResolve)
+	}
+	for name, bucket := range map[string][]string{"did_not_touch": report.DidNotTouch, "skipped": report.Skipped, "unlinked_orphan_symlink": report.UnlinkedOrphanSymlink} {
+		if bucket == nil {
+			t.Errorf("%s should be an empty list, not null", name)
+		}
+	}
+	if ok, err := symlinkPointsTo(e.live("app/linked"), linkedRepo); err != nil || !ok {
+		t.Errorf("app/linked should be linked (ok=%v, err=%v)", ok, err)
+	}
+}
+
+// ageStubRunner stands in for the age binary: "encryption" and "decryption"
+// copy the input file to --output unchanged.
+type ageStubRunner struct{ execRunner }
+
+func (ageStubRunner) Capture(dir string, name string, args ...string) (string, error) {
+	if name != "age" {
+		return execRunner{}.Capture(dir, name, args...)
+	}
+	output := args[slices.Index(args, "--output")+1]
+	data, err := os.ReadFile(args[len(args)-1])
+	if err != nil {
+		return "", err
+	}
+	return "", os.WriteFile(output, data, 0o644)
+}
+
+func TestTrackSelectionsModeAppliesToLiveFile(t *testing.T) {
+	tests := []struct {
+		name string
+		opts func(t *testing.T) trackOptions
+	}{
+		{"copy strategy", func(t *testing.T) trackOptions {
+			return trackOptions{linkStrategy: linkStrategyCopy}
+		}},
+		{"secret", func(t *testing.T) trackOptions {
+			return trackOptions{secrets: &ageSecrets{
+				runner:    ageStubRunner{},
+				matchers:  mustGlobMatchers(t, "app/**"),
+				recipient: "age1test",
+				identity:  "identity",
+			}}
+		}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			e := newTestEnv(t, cfgsConfig{})
+			writeTestFile(t, e.live("app/token"), "secret\n")
+			opts := tt.opts(t)
+			opts.mode = 0o600
+
+			report, _ := e.app().trackSelections(e.repo, nil, []string{"app/token"}, opts)
+			if len(report.failed) != 0 || !slices.Equal(report.succeeded, []string{"app/token"}) {
+				t.Fatalf("report = %+v", report)
+			}
+			for _, name := range []string{filepath.Join(e.repo, "app", "token"), e.live("app/token")} {
+				info, err := os.Lstat(name)
+				if err != nil {
+					t.Fatal(err)
+				}
+				if got := info.Mode().Perm(); got != 0o600 {
+					t.Errorf("%s mode = %04o, want 0600", name, got)
+				}
+			}
+		})
+	}
+}
+
+// lsFilesRunner answers `git ls-files -z` with a fixed listing.
+type lsFilesRunner struct {
+	execRunner
+	listing string
+}
+
+func (r lsFilesRunner) Capture(dir string, name string, args ...string) (string, error) {
+	if name == "git" && len(args) > 0 && args[0] == "ls-files" {
+		return r.listing, nil
+	}
+	return r.execRunner.Capture(dir, name, args...)
+}
+
+func TestMessyTrackedPathsAreCanonicalized(t *testing.T) {
+	listing := strings.Join([]string{
+		"nvim/./init.lua",
+		"nvim//init.lua",
+		"nvim/init.lua",
+		"./zsh/.zshrc",
+		"git/config",
+		"",
+	}, "\x00")
+	var errOut bytes.Buffer
+	a := &app{out: io.Discard, errOut: &errOut, runner: lsFilesRunner{listing: listing}}
+	repoPath := t.TempDir()
+
+	managed, err := a.loadManagedFiles(repoPath)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if want := []string{"git/config", "nvim/init.lua", "zsh/.zshrc"}; !slices.Equal(managed, want) {
+		t.Errorf("loadManagedFiles = %q, want %q", managed, want)
+	}
+
+	a.warnTrackedPathProblems(repoPath)
+	for _, raw := range []string{"nvim/./init.lua", "nvim//init.lua", "./zsh/.zshrc"} {
+		if !strings.Contains(errOut.String(), fmt.Sprintf("non-canonical path %q", raw)) {
+			t.Errorf("no warning for %q in:\n%s", raw, errOut.String())
+		}
+	}
+	if strings.Contains(errOut.String(), `"git/config"`) {
+		t.Errorf("canonical path warned about:\n%s", errOut.String())
+	}
+	if !strings.Contains(errOut.String(), "nvim/./init.lua, nvim//init.lua, nvim/init.lua collide") {
+		t.Errorf("no collision warning in:\n%s", errOut.String())
+	}
+}
+
+func TestHostOverlayMatchesLivePathRules(t *testing.T) {
+	cfg := cfgsConfig{
+		ExcludedPaths:    []string{"work/config"},
+		RequiredCommands: map[string]string{"tool/**": "cfgs-test-missing-tool"},
+	}
+	filter, err := newHostFilter(cfg)
+	if err != nil {
+		t.Fatal(err)
+	}
+	secrets := &ageSecrets{matchers: mustGlobMatchers(t, "ssh/*")}
+	tests := []struct {
+		rel      string
+		excluded bool
+		skip     bool
+		secret   bool
+	}{
+		{".cfgs/local/laptop/work/config", true, true, false},
+		{".cfgs/local/laptop/tool/config", false, true, false},
+		{".cfgs/local/laptop/ssh/key", false, false, true},
+		{".cfgs/local/laptop/app/config", false, false, false},
+		{"work/config", true, true, false},
+	}
+	for _, tt := range tests {
+		if got := filter.isExcluded(tt.rel); got != tt.excluded {
+			t.Errorf("isExcluded(%q) = %v, want %v", tt.rel, got, tt.excluded)
+		}
+		if got := filter.skip(tt.rel) != ""; got != tt.skip {
+			t.Errorf("skip(%q) = %v, want %v", tt.rel, got, tt.skip)
+		}
+		if got := secrets.isSecret(tt.rel); got != tt.secret {
+			t.Errorf("isSecret(%q) = %v, want %v", tt.rel, got, tt.secret)
+		}
+	}
+}
+
+func TestStatusExitCodeSkipsMissingRequiredCommand(t *testing.T) {
+	e := newTestEnv(t, cfgsConfig{
+		RequiredCommands: map[string]string{"tool/**": "cfgs-test-missing-tool"},
+	})
+	repoFile := e.track("app/config", "app\n")
+	e.track("tool/config", "tool\n")
+	if err := os.MkdirAll(filepath.Dir(e.live("app/config")), 0o755); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.Symlink(repoFile, e.live("app/config")); err != nil {
+		t.Fatal(err)
+	}
+
+	if code := e.app().run(context.Background(), []string{"status", "--exit-code"}); code != 0 {
+		t.Fatalf("exit code = %d, want 0; output:\n%s%s", code, e.out.String(), e.errOut.String())
+	}
+	if strings.Contains(e.out.String(), "tool/config") {
+		t.Errorf("tool/config should not be listed:\n%s", e.out.String())
+	}
+}
+
+func TestStatusStopsWhenCancelled(t *testing.T) {
+	e := newTestEnv(t, cfgsConfig{})
+	e.track("app/config", "app\n")
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	if err := e.app().cmdStatus(ctx, nil); !errors.Is(err, context.Canceled) {
+		t.Fatalf("cmdStatus error = %v, want context.Canceled", err)
+	}
+}
+
+// doctorJSON runs doctor with args, writing the JSON report to a file, and
+// returns the parsed report. --keep-going keeps manual-resolve items from
+// failing the run.
+func (e *testEnv) doctorJSON(args ...string) doctorReportJSON {
+	e.t.Helper()
+	reportFile := filepath.Join(e.home, "doctor-report.json")
+	args = append([]string{"doctor", "--json", "--keep-going", "--report-file", reportFile}, args...)
+	if code := e.app().run(context.Background(), args); code != 0 {
+		e.t.Fatalf("cfgs %v: exit code %d; stderr:\n%s", args, code, e.errOut.String())
+	}
+	data, err := os.ReadFile(reportFile)
+	if err != nil {
+		e.t.Fatal(err)
+	}
+	var report doctorReportJSON
+	if err := json.Unmarshal(data, &report); err != nil {
+		e.t.Fatalf("parse doctor report: %v\n%s", err, data)
+	}
+	return report
+}
+
+func manualResolvePaths(report doctorReportJSON) []string {
+	var paths []string
+	for _, item := range report.RequireManualResolve {
+		paths = append(paths, item.Path)
+	}
+	sort.Strings(paths)
+	return paths
+}
+
+func TestIncrementalDoctorNeverMissesDrift(t *testing.T) {
+	e := newTestEnv(t, cfgsConfig{})
+	// The manifest is machine state and must not follow CFGS_CONFIG.
+	configDir := t.TempDir()
+	data, err := os.ReadFile(filepath.Join(e.xdg, "cfgs", "config.json"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	writeTestFile(t, filepath.Join(configDir, "config.json"), string(data))
+	t.Setenv("CFGS_CONFIG", filepath.Join(configDir, "config.json"))
+
+	rels := []string{"app/unchanged", "app/diverged", "app/deleted", "app/retargeted", "app/edited-repo"}
+	for _, rel := range rels {
+		e.track(rel, rel+"\n")
+	}
+	first := e.doctorJSON("--incremental")
+	if len(first.ReplacedWithSymlink) != len(rels) {
+		t.Fatalf("first run linked %q, want all of %q", first.ReplacedWithSymlink, rels)
+	}
+	if _, err := os.Stat(filepath.Join(e.xdg, "cfgs", "doctor-state.json")); err != nil {
+		t.Fatalf("manifest not under $XDG_CONFIG_HOME/cfgs: %v", err)
+	}
+	if _, err := os.Stat(filepath.Join(configDir, "doctor-state.json")); !os.IsNotExist(err) {
+		t.Fatalf("manifest must not be written next to CFGS_CONFIG, stat err = %v", err)
+	}
+
+	// Each kind of real drift, applied after the manifest was written.
+	if err := os.Remove(e.live("app/diverged")); err != nil {
+		t.Fatal(err)
+	}
+	writeTestFile(t, e.live("app/diverged"), "edited live\n")
+	if err := os.Remove(e.live("app/deleted")); err != nil {
+		t.Fatal(err)
+	}
+	elsewhere := filepath.Join(e.home, "elsewhere")
+	writeTestFile(t, elsewhere, "foreign\n")
+	if err := os.Remove(e.live("app/retargeted")); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.Symlink(elsewhere, e.live("app/retargeted")); err != nil {
+		t.Fatal(err)
+	}
+	writeTestFile(t, filepath.Join(e.repo, "app", "edited-repo"), "new repo content\n")
+
+	incremental := e.doctorJSON("--incremental")
+	full := e.doctorJSON()
+
+	if want := []string{"app/diverged", "app/retargeted"}; !slices.Equal(manualResolvePaths(incremental), want) {
+		t.Errorf("incremental run manual resolve = %q, want %q", manualResolvePaths(incremental), want)
+	}
+	if !slices.Contains(incremental.ReplacedWithSymlink, "app/deleted") {
+		t.Errorf("incremental run did not relink app/deleted: %q", incremental.ReplacedWithSymlink)
+	}
+	if !slices.Equal(manualResolvePaths(full), manualResolvePaths(incremental)) {
+		t.Errorf("full run manual resolve = %q, incremental = %q", manualResolvePaths(full), manualResolvePaths(incremental))
+	}
+	for _, rel := range []string{"app/unchanged", "app/edited-repo", "app/deleted"} {
+		repoFile := filepath.Join(e.repo, filepath.FromSlash(rel))
+		if ok, err := symlinkPointsTo(e.live(rel), repoFile); err != nil || !ok {
+			t.Errorf("%s should be linked after the incremental run (ok=%v, err=%v)", rel, ok, err)
+		}
+	}
+}
+
+func BenchmarkDoctor(b *testing.B) {
+	e := newTestEnv(b, cfgsConfig{})
+	for i := 0; i < 300; i++ {
+		writeTestFile(b, filepath.Join(e.repo, "app", fmt.Sprintf("file%03d", i)), "content\n")
+	}
+	e.git("add", "-A")
+	e.git("commit", "-q", "-m", "track files")
+	e.doctorJSON("--incremental")
+
+	for _, bench := range []struct {
+		name string
+		args []string
+	}{
+		{"full", []string{"doctor", "--quiet"}},
+		{"incremental", []string{"doctor", "--quiet", "--incremental"}},
+	} {
+		b.Run(bench.name, func(b *testing.B) {
+			for i := 0; i < b.N; i++ {
+				a := &app{in: bufio.NewReader(strings.NewReader("")), out: io.Discard, errOut: io.Discard, runner: execRunner{}}
+				if code := a.run(context.Background(), bench.args); code != 0 {
+					b.Fatalf("doctor exit code %d", code)
+				}
+			}
+		})
+	}
+}
+
+func TestLoadManagedFilesPrefersHostSpecificFiles(t *testing.T) {
+	listing := strings.Join([]string{
+		"nvim/init.lua",
+		"nvim/init.lua.host-laptop",
+		"nvim/init.lua.host-desktop",
+		"git/config",
+		".cfgs/local/desktop/git/config",
+		"zsh/.zshrc",
+		"zsh/.zshrc.host-laptop",
+		".cfgs/local/laptop/zsh/.zshrc",
+		".cfgs/local/laptop/tmux/tmux.conf",
+	}, "\x00")
+	tests := []struct {
+		hostname string
+		want     []string
+	}{
+		{"laptop", []string{".cfgs/local/laptop/tmux/tmux.conf", ".cfgs/local/laptop/zsh/.zshrc", "git/config", "nvim/init.lua.host-laptop"}},
+		{"desktop", []string{".cfgs/local/desktop/git/config", "nvim/init.lua.host-desktop", "zsh/.zshrc"}},
+		{"server", []string{"git/config", "nvim/init.lua", "zsh/.zshrc"}},
+		{"", []string{"git/config", "nvim/init.lua", "zsh/.zshrc"}},
+	}
+	for _, tt := range tests {
+		a := &app{out: io.Discard, errOut: io.Discard, runner: lsFilesRunner{listing: listing}, hostname: tt.hostname}
+		got, err := a.loadManagedFiles(t.TempDir())
+		if err != nil {
+			t.Fatal(err)
+		}
+		if !slices.Equal(got, tt.want) {
+			t.Errorf("hostname %q: loadManagedFiles = %q, want %q", tt.hostname, got, tt.want)
+		}
+	}
+}
+
+func TestDoctorLinksHostVariant(t *testing.T) {
+	e := newTestEnv(t, cfgsConfig{ExcludedPaths: []string{"git/config"}})
+	e.track("nvim/init.lua", "shared\n")
+	variant := e.track("nvim/init.lua.host-laptop", "laptop\n")
+	e.track("nvim/init.lua.host-desktop", "desktop\n")
+	e.track("git/config.host-laptop", "laptop\n")
+
+	report := e.doctorJSON()
+	if ok, err := symlinkPointsTo(e.live("nvim/init.lua"), variant); err != nil || !ok {
+		t.Errorf("nvim/init.lua should link to the laptop variant (ok=%v, err=%v)", ok, err)
+	}
+	if _, err := os.Lstat(e.live("git/config")); !os.IsNotExist(err) {
+		t.Errorf("git/config is excluded by its live path, but lstat err = %v", err)
+	}
+	if !slices.Contains(report.Skipped, "git/config.host-laptop (excluded on this machine)") {
+		t.Errorf("skipped = %q, want the excluded host variant", report.Skipped)
+	}
+	for _, rel := range []string{"nvim/init.lua.host-laptop", "nvim/init.lua.host-desktop"} {
+		if _, err := os.Lstat(e.live(rel)); !os.IsNotExist(err) {
+			t.Errorf("variant %s must not be linked under its own name, lstat err = %v", rel, err)
+		}
+	}
+}
+
+func TestGitCommitArgsSigning(t *testing.T) {
+	tests := []struct {
+		name string
+		cfg  cfgsConfig
+		want []string
+	}{
+		{"unsigned", cfgsConfig{}, []string{"commit", "-m", "msg"}},
+		{"signed", cfgsConfig{SignCommits: true}, []string{"commit", "-S", "-m", "msg"}},
+		{"signed with key", cfgsConfig{SignCommits: true, SigningKey: "ABC123"}, []string{"commit", "--gpg-sign=ABC123", "-m", "msg"}},
+		{"key without signing", cfgsConfig{SigningKey: "ABC123"}, []string{"commit", "-m", "msg"}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			newTestEnv(t, tt.cfg)
+			got, err := gitCommitArgs("commit", "-m", "msg")
+			if err != nil {
+				t.Fatal(err)
+			}
+			if !slices.Equal(got, tt.want) {
+				t.Errorf("gitCommitArgs = %q, want %q", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestSyncPullArgsSigning(t *testing.T) {
+	tests := []struct {
+		strategy string
+		cfg      cfgsConfig
+		want     []string
+	}{
+		{syncStrategyRebase, cfgsConfig{}, []string{"pull", "--rebase", "--autostash"}},
+		{syncStrategyRebase, cfgsConfig{SignCommits: true}, []string{"pull", "--rebase", "--autostash", "-S"}},
+		{syncStrategyMerge, cfgsConfig{SignCommits: true, SigningKey: "ABC123"}, []string{"pull", "--no-rebase", "--autostash", "--gpg-sign=ABC123"}},
+		{syncStrategyFFOnly, cfgsConfig{SignCommits: true}, []string{"pull", "--ff-only"}},
+	}
+	for _, tt := range tests {
+		got, err := syncPullArgs(tt.cfg, tt.strategy)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if !slices.Equal(got, tt.want) {
+			t.Errorf("syncPullArgs(%s, %+v) = %q, want %q", tt.strategy, tt.cfg, got, tt.want)
+		}
+	}
+}
+
+func TestNegatedIgnoreGlobs(t *testing.T) {
+	matchers := mustGlobMatchers(t, "**/cache/**", "!**/cache/keep.conf")
+	tests := []struct {
+		rel  string
+		want bool
+	}{
+		{"app/cache/junk", true},
+		{"app/cache/sub/keep.conf", true},
+		{"app/cache/keep.conf", false},
+		{"app/config", false},
+	}
+	for _, tt := range tests {
+		if got := shouldIgnorePath(tt.rel, false, matchers); got != tt.want {
+			t.Errorf("shouldIgnorePath(%q) = %v, want %v", tt.rel, got, tt.want)
+		}
+	}
+	// Order matters: a later pattern overrides an earlier negation.
+	reversed := mustGlobMatchers(t, "!**/cache/keep.conf", "**/cache/**")
+	if !shouldIgnorePath("app/cache/keep.conf", false, reversed) {
+		t.Error("a later ignore pattern should win over an earlier negation")
+	}
+}
+
+func TestWalkRootsDescendsIntoDirsANegationMayReinclude(t *testing.T) {
+	base := t.TempDir()
+	for _, rel := range []string{"app/cache/keep.conf", "app/cache/junk", "app/config"} {
+		writeTestFile(t, filepath.Join(base, filepath.FromSlash(rel)), "x\n")
+	}
+	roots := []managedRoot{{name: "config", base: base}}
+	walk := func(patterns ...string) []string {
+		var seen []string
+		err := walkRoots(roots, mustGlobMatchers(t, patterns...), func(_ string, rel string, _ fs.DirEntry) error {
+			seen = append(seen, rel)
+			return nil
+		})
+		if err != nil {
+			t.Fatal(err)
+		}
+		sort.Strings(seen)
+		return seen
+	}
+
+	if got, want := walk("**/cache/**", "!**/cache/keep.conf"), []string{"app/cache/keep.conf", "app/config"}; !slices.Equal(got, want) {
+		t.Errorf("walk with negation = %q, want %q", got, want)
+	}
+	if got, want := walk("**/cache/**"), []string{"app/config"}; !slices.Equal(got, want) {
+		t.Errorf("walk without negation = %q, want %q", got, want)
+	}
+	if !mayReincludeUnder("app/cache", mustGlobMatchers(t, "!**/cache/keep.conf")) {
+		t.Error("mayReincludeUnder should keep app/cache walkable")
+	}
+	if mayReincludeUnder("app/cache", mustGlobMatchers(t, "**/cache/**", "!other/keep.conf")) {
+		t.Error("a negation anchored elsewhere should not keep app/cache walkable")
+	}
+}
+
+func TestGlobRegexMetacharactersAreLiteral(t *testing.T) {
+	tests := []struct {
+		pattern string
+		match   string
+		reject  []string
+	}{
+		{"file(1).conf", "file(1).conf", []string{"file1.conf", "file(1)xconf"}},
+		{"a.b", "a.b", []string{"axb"}},
+		{"a+b", "a+b", []string{"aab", "ab"}},
+		{"a|b", "a|b", []string{"a", "b"}},
+		{"a^b", "a^b", []string{"ab"}},
+		{"a$b", "a$b", []string{"ab"}},
+		{"[ab]", "[ab]", []string{"a", "b"}},
+		{"a]b", "a]b", []string{"ab"}},
+		{"a{b}.txt", "a{b}.txt", []string{"ab.txt", "a.txt"}},
+		{`a\b`, `a\b`, []string{"ab"}},
+		{"a*b", "axyzb", []string{"a/b"}},
+		{"a?b", "axb", []string{"ab", "a/b"}},
+	}
+	for _, tt := range tests {
+		src, err := globToRegex(tt.pattern)
+		if err != nil {
+			t.Errorf("globToRegex(%q): %v", tt.pattern, err)
+			continue
+		}
+		re := regexp.MustCompile(src)
+		if !re.MatchString(tt.match) {
+			t.Errorf("%q (regex %s) should match %q", tt.pattern, src, tt.match)
+		}
+		for _, other := range tt.reject {
+			if re.MatchString(other) {
+				t.Errorf("%q (regex %s) should not match %q", tt.pattern, src, other)
+			}
+		}
+	}
+}
+
+func TestBraceAlternationGlobs(t *testing.T) {
+	tests := []struct {
+		pattern string
+		rel     string
+		want    bool
+	}{
+		{"**/*.{log,tmp,cache}", "app/x.log", true},
+		{"**/*.{log,tmp,cache}", "app/sub/x.cache", true},
+		{"**/*.{log,tmp,cache}", "app/x.conf", false},
+		{"{nvim,vim}/*.{lua,vim}", "nvim/init.lua", true},
+		{"{nvim,vim}/*.{lua,vim}", "vim/init.vim", true},
+		{"{nvim,vim}/*.{lua,vim}", "emacs/init.lua", false},
+		{"{nvim,vim}/*.{lua,vim}", "nvim/init.el", false},
+		{"*.{a,b{c,d}}", "x.bd", true},
+	}
+	for _, tt := range tests {
+		matchers := mustGlobMatchers(t, tt.pattern)
+		if got := shouldIgnorePath(tt.rel, false, matchers); got != tt.want {
+			t.Errorf("shouldIgnorePath(%q) with %q = %v, want %v", tt.rel, tt.pattern, got, tt.want)
+		}
+	}
+}
+
+func TestUnbalancedBraceIsInvalidIgnoreGlob(t *testing.T) {
+	for _, pattern := range []string{"*.{log,tmp", "a}b", "{a,{b,c}"} {
+		_, err := compileGlobMatchers([]string{pattern})
+		if err == nil {
+			t.Errorf("compileGlobMatchers(%q) succeeded, want an error", pattern)
+			continue
+		}
+		if msg := err.Error(); !strings.Contains(msg, "invalid ignore glob") || !strings.Contains(msg, "unbalanced brace") {
+			t.Errorf("compileGlobMatchers(%q) error = %q, want an invalid ignore glob / unbalanced brace error", pattern, msg)
+		}
+	}
+}
+
+func TestFilesEqual(t *testing.T) {
+	dir := t.TempDir()
+	big := bytes.Repeat([]byte("0123456789abcdef"), 64*1024)
+	lastByte := bytes.Clone(big)
+	lastByte[len(lastByte)-1] = 'X'
+	tests := []struct {
+		name        string
+		left, right []byte
+		want        bool
+	}{
+		{"identical", big, bytes.Clone(big), true},
+		{"same size, last byte differs", big, lastByte, false},
+		{"different size", big, big[:len(big)-1], false},
+		{"both empty", nil, nil, true},
+	}
+	for _, tt := range tests {
+		left := filepath.Join(dir, tt.name+"-left")
+		right := filepath.Join(dir, tt.name+"-right")
+		if err := os.WriteFile(left, tt.left, 0o644); err != nil {
+			t.Fatal(err)
+		}
+		if err := os.WriteFile(right, tt.right, 0o644); err != nil {
+			t.Fatal(err)
+		}
+		got, err := filesEqual(left, right)
+		if err != nil {
+			t.Fatalf("%s: %v", tt.name, err)
+		}
+		if got != tt.want {
+			t.Errorf("%s: filesEqual = %v, want %v", tt.name, got, tt.want)
+		}
+	}
+}
+
+func TestExecutableBitSurvivesTrackUnlinkRoundTrip(t *testing.T) {
+	e := newTestEnv(t, cfgsConfig{})
+	script := e.live("foo/bin/run.sh")
+	writeTestFile(t, script, "#!/bin/sh\necho hi\n")
+	if err := os.Chmod(script, 0o755); err != nil {
+		t.Fatal(err)
+	}
+	mtime := time.Date(2020, 1, 2, 3, 4, 5, 0, time.UTC)
+	if err := os.Chtimes(script, mtime, mtime); err != nil {
+		t.Fatal(err)
+	}
+
+	if code := e.app().run(context.Background(), []string{"add", "-m", "track run.sh", "foo/bin/run.sh"}); code != 0 {
+		t.Fatalf("add: exit code %d; stderr:\n%s", code, e.errOut.String())
+	}
+	if info, err := os.Lstat(script); err != nil || info.Mode()&os.ModeSymlink == 0 {
+		t.Fatalf("run.sh should be a symlink after add (err=%v)", err)
+	}
+	if code := e.app().run(context.Background(), []string{"unlink", "-m", "untrack run.sh", "foo/bin/run.sh"}); code != 0 {
+		t.Fatalf("unlink: exit code %d; stderr:\n%s", code, e.errOut.String())
+	}
+
+	info, err := os.Lstat(script)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !info.Mode().IsRegular() {
+		t.Fatalf("run.sh should be a regular file after unlink, mode %v", info.Mode())
+	}
+	if got := info.Mode().Perm(); got != 0o755 {
+		t.Errorf("mode after round trip = %04o, want 0755", got)
+	}
+	if !info.ModTime().Equal(mtime) {
+		t.Errorf("mtime after round trip = %v, want %v", info.ModTime(), mtime)
+	}
+}
+
+func TestFzfPreviewTreatsFileNamesLiterally(t *testing.T) {
+	dir := t.TempDir()
+	marker := filepath.Join(dir, "pwned")
+	name := filepath.Join(dir, "$(touch "+marker+")`touch "+marker+"`;touch "+marker+".conf")
+	writeTestFile(t, name, "literal content\n")
+	list := filepath.Join(dir, "list.txt")
+	writeTestFile(t, list, filepath.Join(dir, "other")+"\n"+name+"\n")
+
+	// fzf replaces {n} with the zero-based index of the focused item.
+	script := strings.ReplaceAll(fzfPreviewPathLookup+defaultFzfPreview, "{n}", "1")
+	cmd := exec.Command("sh", "-c", script)
+	cmd.Dir = dir
+	cmd.Env = append(os.Environ(), "CFGS_PREVIEW_LIST="+list, "PATH=/usr/bin:/bin")
+	out, err := cmd.CombinedOutput()
+	if err != nil {
+		t.Fatalf("preview failed: %v\n%s", err, out)
+	}
+	if !strings.Contains(string(out), "literal content") {
+		t.Errorf("preview output = %q, want the file content", out)
+	}
+	if _, err := os.Stat(marker); !os.IsNotExist(err) {
+		t.Fatalf("the file name was executed: stat %s err = %v", marker, err)
+	}
+}
+
+func TestSymlinkResolutionThroughSymlinkedDirectory(t *testing.T) {
+	// Mimic macOS, where /tmp is a symlink to /private/tmp.
+	base := t.TempDir()
+	private := filepath.Join(base, "private", "tmp")
+	if err := os.MkdirAll(private, 0o755); err != nil {
+		t.Fatal(err)
+	}
+	alias := filepath.Join(base, "tmp")
+	if err := os.Symlink(private, alias); err != nil {
+		t.Fatal(err)
+	}
+	writeTestFile(t, filepath.Join(private, "repo", "app", "config"), "x\n")
+	writeTestFile(t, filepath.Join(private, "elsewhere"), "y\n")
+	live := filepath.Join(base, "live")
+	if err := os.Mkdir(live, 0o755); err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		name     string
+		repoPath string
+		target   string
+		want     bool
+	}{
+		{"repo via alias, link via real path", filepath.Join(alias, "repo"), filepath.Join(private, "repo", "app", "config"), true},
+		{"repo via real path, link via alias", filepath.Join(private, "repo"), filepath.Join(alias, "repo", "app", "config"), true},
+		{"link outside the repo", filepath.Join(alias, "repo"), filepath.Join(private, "elsewhere"), false},
+	}
+	for i, tt := range tests {
+		link := filepath.Join(live, fmt.Sprintf("link%d", i))
+		if err := os.Symlink(tt.target, link); err != nil {
+			t.Fatal(err)
+		}
+		repoFile := filepath.Join(tt.repoPath, "app", "config")
+
+		got, inRepo, err := symlinkRepoTarget(link, tt.repoPath)
+		if err != nil {
+			t.Fatalf("%s: symlinkRepoTarget: %v", tt.name, err)
+		}
+		if inRepo != tt.want || (tt.want && got != repoFile) {
+			t.Errorf("%s: symlinkRepoTarget = %q, %v; want %q, %v", tt.name, got, inRepo, repoFile, tt.want)
+		}
+		ok, err := symlinkPointsTo(link, repoFile)
+		if err != nil {
+			t.Fatalf("%s: symlinkPointsTo: %v", tt.name, err)
+		}
+		if ok != tt.want {
+			t.Errorf("%s: symlinkPointsTo = %v, want %v", tt.name, ok, tt.want)
+		}
+	}
+}
+
+// fakeRunner is a commandRunner that answers from canned responses instead of
+// starting processes. Commands are keyed by their space-joined argv; a key
+// with several responses returns them in order and then repeats the last.
+// Every invocation is recorded in calls.
+type fakeRunner struct {
+	responses map[string][]fakeResponse
+	calls     []string
+}
+
+type fakeResponse struct {
+	out string
+	err error
+}
+
+// on queues a response for the command line key.
+func (f *fakeRunner) on(key string, out string, err error) *fakeRunner {
+	if f.responses == nil {
+		f.responses = map[string][]fakeResponse{}
+	}
+	f.responses[key] = append(f.responses[key], fakeResponse{out: out, err: err})
+	return f
+}
+
+func (f *fakeRunner) respond(argv []string) (string, error) {
+	key := strings.Join(argv, " ")
+	f.calls = append(f.calls, key)
+	queue := f.responses[key]
+	if len(queue) == 0 {
+		return "", fmt.Errorf("fakeRunner: unexpected command %q", key)
+	}
+	if len(queue) > 1 {
+		f.responses[key] = queue[1:]
+	}
+	return queue[0].out, queue[0].err
+}
+
+func (f *fakeRunner) Capture(dir string, name string, args ...string) (string, error) {
+	return f.respond(append([]string{name}, args...))
+}
+
+func (f *fakeRunner) Interactive(dir string, name string, args ...string) error {
+	_, err := f.respond(append([]string{name}, args...))
+	return err
+}
+
+func (f *fakeRunner) Run(cmd *exec.Cmd) error {
+	out, err := f.respond(cmd.Args)
+	if cmd.Stdout != nil {
+		io.WriteString(cmd.Stdout, out)
+	}
+	return err
+}
+
+func TestRunGitWithRetry(t *testing.T) {
+	transient := errors.New("fatal: unable to access: Could not resolve host: github.com")
+	conflict := errors.New("CONFLICT (content): Merge conflict in nvim/init.lua")
+	tests := []struct {
+		name      string
+		responses []error
+		retries   int
+		wantErr   error
+		wantCalls int
+	}{
+		{"succeeds first time", []error{nil}, 3, nil, 1},
+		{"recovers from transient failures", []error{transient, transient, nil}, 3, nil, 3},
+		{"gives up after retries", []error{transient}, 2, transient, 3},
+		{"does not retry a conflict", []error{conflict, nil}, 3, conflict, 1},
+		{"retries disabled", []error{transient, nil}, 0, transient, 1},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			runner := &fakeRunner{}
+			for _, err := range tt.responses {
+				runner.on("git push origin main", "", err)
+			}
+			var errOut bytes.Buffer
+			a := &app{out: io.Discard, errOut: &errOut, runner: runner}
+			err := a.runGitWithRetry(context.Background(), "/repo", tt.retries, "push", "origin", "main")
+			if !errors.Is(err, tt.wantErr) {
+				t.Errorf("error = %v, want %v", err, tt.wantErr)
+			}
+			if len(runner.calls) != tt.wantCalls {
+				t.Errorf("git ran %d time(s), want %d: %q", len(runner.calls), tt.wantCalls, runner.calls)
+			}
+			if got := strings.Count(errOut.String(), "retrying"); got != tt.wantCalls-1 {
+				t.Errorf("%d retry warning(s), want %d:\n%s", got, tt.wantCalls-1, errOut.String())
+			}
+		})
+	}
+}
+
+func TestRunGitWithRetryStopsWaitingWhenCancelled(t *testing.T) {
+	runner := (&fakeRunner{}).on("git fetch origin", "", errors.New("ssh: connect to host example.com: Connection timed out"))
+	a := &app{out: io.Discard, errOut: io.Discard, runner: runner, gitRetryDelay: time.Hour}
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+
+	done := make(chan error, 1)
+	go func() { done <- a.runGitWithRetry(ctx, "/repo", 3, "fetch", "origin") }()
+	select {
+	case err := <-done:
+		if !errors.Is(err, context.Canceled) {
+			t.Errorf("error = %v, want context.Canceled", err)
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatal("runGitWithRetry kept sleeping after the context was cancelled")
+	}
+	if len(runner.calls) != 1 {
+		t.Errorf("git ran %d time(s), want 1", len(runner.calls))
+	}
+}
+
+// processExitError returns a real *exec.ExitError, which is how a runner reports a
+// command that ran but failed.
+func processExitError(t *testing.T) error {
+	t.Helper()
+	err := exec.Command("sh", "-c", "exit 128").Run()
+	var exitErr *exec.ExitError
+	if !errors.As(err, &exitErr) {
+		t.Fatalf("expected an exit error, got %v", err)
+	}
+	return err
+}
+
+func TestRepoIsEmpty(t *testing.T) {
+	tests := []struct {
+		name    string
+		runner  *fakeRunner
+		want    bool
+		wantErr bool
+	}{
+		{"no commits", (&fakeRunner{}).on("git rev-parse --verify HEAD", "", processExitError(t)), true, false},
+		{"only metadata", (&fakeRunner{}).
+			on("git rev-parse --verify HEAD", "", nil).
+			on("git ls-files -z", ".cfgs/modes\x00.cfgs/dirs\x00", nil), true, false},
+		{"tracked files", (&fakeRunner{}).
+			on("git rev-parse --verify HEAD", "", nil).
+			on("git ls-files -z", ".cfgs/modes\x00nvim/init.lua\x00", nil), false, false},
+		{"git cannot start", (&fakeRunner{}).on("git rev-parse --verify HEAD", "", errors.New("exec: git: not found")), false, true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			a := &app{out: io.Discard, errOut: io.Discard, runner: tt.runner}
+			got, err := a.repoIsEmpty("/repo")
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("error = %v, wantErr %v", err, tt.wantErr)
+			}
+			if got != tt.want {
+				t.Errorf("repoIsEmpty = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestGitAheadBehind(t *testing.T) {
+	const upstream = "git rev-parse --abbrev-ref --symbolic-full-name @{u}"
+	const count = "git rev-list --left-right --count @{u}...HEAD"
+	tests := []struct {
+		name                string
+		runner              *fakeRunner
+		ahead, behind       int
+		hasUpstream, hasErr bool
+	}{
+		{"no upstream", (&fakeRunner{}).on(upstream, "", errors.New("no upstream configured")), 0, 0, false, false},
+		{"ahead and behind", (&fakeRunner{}).on(upstream, "origin/main", nil).on(count, "3\t5", nil), 5, 3, true, false},
+		{"in sync", (&fakeRunner{}).on(upstream, "origin/main", nil).on(count, "0\t0", nil), 0, 0, true, false},
+		{"garbled output", (&fakeRunner{}).on(upstream, "origin/main", nil).on(count, "oops", nil), 0, 0, false, true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			a := &app{out: io.Discard, errOut: io.Discard, runner: tt.runner}
+			ahead, behind, hasUpstream, err := a.gitAheadBehind("/repo")
+			if (err != nil) != tt.hasErr {
+				t.Fatalf("error = %v, wantErr %v", err, tt.hasErr)
+			}
+			if ahead != tt.ahead || behind != tt.behind || hasUpstream != tt.hasUpstream {
+				t.Errorf("gitAheadBehind = %d, %d, %v; want %d, %d, %v", ahead, behind, hasUpstream, tt.ahead, tt.behind, tt.hasUpstream)
+			}
+		})
+	}
+}
+
+func TestShowSyncDiff(t *testing.T) {
+	const before, after = "1111111111111111", "2222222222222222"
+	tests := []struct {
+		name         string
+		beforeExists bool
+		afterExists  bool
+		beforeHead   string
+		opts         syncDiffOptions
+		wantCalls    []string
+		wantOut      string
+	}{
+		{"up to date", true, true, after, syncDiffOptions{}, nil, "already up to date"},
+		{"pulled", true, true, before, syncDiffOptions{}, []string{"git --no-pager diff " + before + ".." + after}, "pulled updates (111111111111..222222222222)"},
+		{"pulled with stat", true, true, before, syncDiffOptions{stat: true}, []string{"git --no-pager diff --stat " + before + ".." + after}, "pulled updates"},
+		{"pulled without diff", true, true, before, syncDiffOptions{noDiff: true}, nil, "pulled updates"},
+		{"first commits", false, true, "", syncDiffOptions{}, []string{"git --no-pager show " + after}, "showing latest commit"},
+		{"still empty", false, false, "", syncDiffOptions{}, nil, "no commits found"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			runner := &fakeRunner{}
+			for _, call := range tt.wantCalls {
+				runner.on(call, "", nil)
+			}
+			var out bytes.Buffer
+			a := &app{out: &out, errOut: io.Discard, runner: runner}
+			afterHead := after
+			if !tt.afterExists {
+				afterHead = ""
+			}
+			if err := a.showSyncDiff("/repo", tt.beforeHead, tt.beforeExists, afterHead, tt.afterExists, tt.opts); err != nil {
+				t.Fatal(err)
+			}
+			if !slices.Equal(runner.calls, tt.wantCalls) {
+				t.Errorf("commands = %q, want %q", runner.calls, tt.wantCalls)
+			}
+			if !strings.Contains(out.String(), tt.wantOut) {
+				t.Errorf("output = %q, want it to contain %q", out.String(), tt.wantOut)
+			}
+		})
+	}
+}
+
+// newSecretTestEnv configures secret_globs for app/** and puts a stand-in age
+// on PATH, so commands load secrets and decrypt through ageStubRunner.
+func newSecretTestEnv(t *testing.T, cfg cfgsConfig) *testEnv {
+	t.Helper()
+	bin := t.TempDir()
+	if err := os.WriteFile(filepath.Join(bin, "age"), []byte("#!/bin/sh\nexit 1\n"), 0o755); err != nil {
+		t.Fatal(err)
+	}
+	t.Setenv("PATH", bin+string(os.PathListSeparator)+os.Getenv("PATH"))
+	cfg.SecretGlobs = []string{"app/**"}
+	cfg.AgeRecipient = "age1test"
+	cfg.AgeIdentity = "identity"
+	return newTestEnv(t, cfg)
+}
+
+func (e *testEnv) secretApp() *app {
+	a := e.app()
+	a.runner = ageStubRunner{}
+	return a
+}
+
+func TestClassifierTreatsDecryptedSecretAsLinked(t *testing.T) {
+	e := newSecretTestEnv(t, cfgsConfig{})
+	e.track("app/token", "secret\n")
+	writeTestFile(t, e.live("app/token"), "secret\n")
+
+	a := e.secretApp()
+	if err := a.cmdList(context.Background(), nil); err != nil {
+		t.Fatal(err)
+	}
+	if got := strings.Fields(e.out.String()); !slices.Equal(got, []string{"linked", "app/token"}) {
+		t.Errorf("list = %q, want app/token linked", e.out.String())
+	}
+
+	e.out.Reset()
+	if err := a.cmdStatus(context.Background(), []string{"--exit-code"}); err != nil {
+		t.Errorf("status --exit-code = %v, want no drift:\n%s", err, e.out.String())
+	}
+
+	writeTestFile(t, e.live("app/token"), "edited\n")
+	e.out.Reset()
+	if err := a.cmdList(context.Background(), nil); err != nil {
+		t.Fatal(err)
+	}
+	if got := strings.Fields(e.out.String()); !slices.Equal(got, []string{"diverged", "app/token"}) {
+		t.Errorf("list = %q, want an edited secret diverged", e.out.String())
+	}
+}
+
+func TestRestoreDecryptsMissingSecret(t *testing.T) {
+	e := newSecretTestEnv(t, cfgsConfig{})
+	e.track("app/token", "secret\n")
+	e.track("plain/config", "plain\n")
+
+	if err := e.secretApp().cmdRestore(context.Background(), nil); err != nil {
+		t.Fatalf("restore: %v\n%s", err, e.errOut.String())
+	}
+	info, err := os.Lstat(e.live("app/token"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !info.Mode().IsRegular() || info.Mode().Perm() != 0o600 {
+		t.Errorf("secret live path mode = %v, want a 0600 regular file", info.Mode())
+	}
+	if ok, err := symlinkPointsTo(e.live("plain/config"), filepath.Join(e.repo, "plain", "config")); err != nil || !ok {
+		t.Errorf("plain/config should be symlinked (ok=%v, err=%v)", ok, err)
+	}
+
+	// A second restore finds the decrypted copy in place.
+	e.out.Reset()
+	if err := e.secretApp().cmdRestore(context.Background(), nil); err != nil {
+		t.Fatalf("second restore: %v\n%s", err, e.errOut.String())
+	}
+}
+
+func TestAdoptEncryptsSecretAndKeepsLiveFile(t *testing.T) {
+	e := newSecretTestEnv(t, cfgsConfig{})
+	repoFile := e.track("app/token", "old\n")
+	writeTestFile(t, e.live("app/token"), "new\n")
+
+	// The stub "encrypts" by copying, so mark the ciphertext to tell it apart.
+	a := e.secretApp()
+	a.runner = encryptMarkRunner{}
+	if err := a.cmdAdopt(context.Background(), []string{"-m", "adopt", "app/token"}); err != nil {
+		t.Fatalf("adopt: %v\n%s", err, e.errOut.String())
+	}
+	if data, _ := os.ReadFile(repoFile); string(data) != "ENC:new\n" {
+		t.Errorf("repo file = %q, want the encrypted live content", data)
+	}
+	info, err := os.Lstat(e.live("app/token"))
+	if err != nil || !info.Mode().IsRegular() {
+		t.Fatalf("live secret must stay a regular file: %v, %v", info, err)
+	}
+	if data, _ := os.ReadFile(e.live("app/token")); string(data) != "new\n" {
+		t.Errorf("live file = %q, want it untouched", data)
+	}
+}
+
+// encryptMarkRunner is ageStubRunner with encryption prefixing "ENC:" and
+// decryption stripping it.
+type encryptMarkRunner struct{ ageStubRunner }
+
+func (r encryptMarkRunner) Capture(dir string, name string, args ...string) (string, error) {
+	if name != "age" {
+		return r.ageStubRunner.Capture(dir, name, args...)
+	}
+	data, err := os.ReadFile(args[len(args)-1])
+	if err != nil {
+		return "", err
+	}
+	if slices.Contains(args, "--encrypt") {
+		data = append([]byte("ENC:"), data...)
+	} else {
+		data = bytes.TrimPrefix(data, []byte("ENC:"))
+	}
+	return "", os.WriteFile(args[slices.Index(args, "--output")+1], data, 0o644)
+}
+
+func TestDiffAgainstDecryptsSecrets(t *testing.T) {
+	e := newSecretTestEnv(t, cfgsConfig{})
+	e.track("app/token", "ENC:hunter2\n")
+	writeTestFile(t, e.live("app/token"), "hunter2\n")
+	a := e.secretApp()
+	a.runner = encryptMarkRunner{}
+
+	if err := a.cmdDiff(context.Background(), []string{"--against", "HEAD"}); err != nil {
+		t.Fatal(err)
+	}
+	if got := e.out.String(); got != "diff: live files match HEAD.\n" {
+		t.Errorf("diff of an unchanged secret = %q", got)
+	}
+
+	writeTestFile(t, e.live("app/token"), "correct horse\n")
+	e.out.Reset()
+	if err := a.cmdDiff(context.Background(), []string{"--against", "HEAD"}); err != nil {
+		t.Fatal(err)
+	}
+	out := e.out.String()
+	if !strings.Contains(out, "secret app/token: live file differs from HEAD") || !strings.Contains(out, "1 file(s) differ") {
+		t.Errorf("diff should report the changed secret:\n%s", out)
+	}
+	for _, plaintext := range []string{"hunter2", "correct horse"} {
+		if strings.Contains(out, plaintext) {
+			t.Errorf("diff printed secret content %q:\n%s", plaintext, out)
+		}
+	}
+}
+
+func TestClassifierCopyStrategy(t *testing.T) {
+	e := newTestEnv(t, cfgsConfig{LinkStrategy: linkStrategyCopy})
+	e.track("app/config", "same\n")
+	writeTestFile(t, e.live("app/config"), "same\n")
+
+	a := e.app()
+	if err := a.cmdList(context.Background(), nil); err != nil {
+		t.Fatal(err)
+	}
+	if got := strings.Fields(e.out.String()); !slices.Equal(got, []string{"linked", "app/config"}) {
+		t.Errorf("list = %q, want an identical copy linked under the copy strategy", e.out.String())
+	}
+	if err := a.cmdStatus(context.Background(), []string{"--exit-code"}); err != nil {
+		t.Errorf("status --exit-code = %v, want no drift", err)
+	}
+}
+
+func TestLinkStrategyAppliesOutsideDoctor(t *testing.T) {
+	for _, strategy := range []string{linkStrategyCopy, linkStrategyHardlink} {
+		t.Run(strategy, func(t *testing.T) {
+			e := newTestEnv(t, cfgsConfig{LinkStrategy: strategy})
+			restored := e.track("app/restored", "restored\n")
+			watched := e.track("app/watched", "watched\n")
+			adopted := e.track("app/adopted", "old\n")
+
+			a := e.app()
+			if err := a.cmdRestore(context.Background(), nil); err != nil {
+				t.Fatalf("restore: %v\n%s", err, e.errOut.String())
+			}
+			if err := os.Remove(e.live("app/adopted")); err != nil {
+				t.Fatal(err)
+			}
+			writeTestFile(t, e.live("app/adopted"), "new\n")
+			if err := os.Remove(e.live("app/watched")); err != nil {
+				t.Fatal(err)
+			}
+			roots, err := configuredRoots()
+			if err != nil {
+				t.Fatal(err)
+			}
+			if err := a.reportDrift(e.repo, roots, true); err != nil {
+				t.Fatal(err)
+			}
+			if err := a.cmdAdopt(context.Background(), []string{"-m", "adopt", "app/adopted"}); err != nil {
+				t.Fatalf("adopt: %v\n%s", err, e.errOut.String())
+			}
+
+			placed := loadPlacedFiles(e.repo)
+			for rel, repoFile := range map[string]string{"app/restored": restored, "app/watched": watched, "app/adopted": adopted} {
+				liveFile := e.live(rel)
+				info, err := os.Lstat(liveFile)
+				if err != nil {
+					t.Fatal(err)
+				}
+				if !info.Mode().IsRegular() {
+					t.Errorf("%s: live path mode = %v, want a regular file", rel, info.Mode())
+				}
+				if same, err := filesEqual(repoFile, liveFile); err != nil || !same {
+					t.Errorf("%s: live file differs from the repo (err=%v)", rel, err)
+				}
+				if sum, _ := fileSHA256(repoFile); placed[rel] != sum {
+					t.Errorf("%s: placed-files manifest = %q, want %q", rel, placed[rel], sum)
+				}
+			}
+			if data, _ := os.ReadFile(adopted); string(data) != "new\n" {
+				t.Errorf("adopted repo file = %q, want the live content", data)
+			}
+		})
+	}
+}
+
+func TestTrackDirectoriesRefusesNonSymlinkStrategy(t *testing.T) {
+	e := newTestEnv(t, cfgsConfig{})
+	writeTestFile(t, e.live("app/config"), "x\n")
+
+	report, dirs := trackDirectories(e.repo, nil, nil, []string{"app"}, nil, linkStrategyCopy, "")
+	if len(report.failed) != 1 || len(dirs) != 0 {
+		t.Fatalf("report = %+v, dirs = %v; want app refused", report, dirs)
+	}
+	if info, err := os.Lstat(e.live("app")); err != nil || !info.IsDir() {
+		t.Errorf("live directory must be left in place: %v, %v", info, err)
+	}
+	if _, err := os.Lstat(filepath.Join(e.repo, "app")); !os.IsNotExist(err) {
+		t.Errorf("nothing may be moved into the repo, lstat err = %v", err)
+	}
+}
+
+func TestPartialConfigWriteKeepsOldConfig(t *testing.T) {
+	e := newTestEnv(t, cfgsConfig{IgnoreGlobs: []string{"old"}})
+	configPath, err := cfgsConfigPath()
+	if err != nil {
+		t.Fatal(err)
+	}
+	before, err := os.ReadFile(configPath)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	data, err := json.Marshal(cfgsConfig{RepoPath: e.repo, IgnoreGlobs: []string{"new"}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	crash := errors.New("killed mid-write")
+	err = writeFileAtomicWith(configPath, 0o644, func(w io.Writer) error {
+		if _, err := w.Write(data[:len(data)/2]); err != nil {
+			return err
+		}
+		return crash
+	})
+	if !errors.Is(err, crash) {
+		t.Fatalf("writeFileAtomicWith = %v, want %v", err, crash)
+	}
+
+	if after, _ := os.ReadFile(configPath); !bytes.Equal(after, before) {
+		t.Errorf("config = %q, want the old content %q", after, before)
+	}
+	cfg, _, err := loadCfgsConfig()
+	if err != nil {
+		t.Fatalf("old config no longer loads: %v", err)
+	}
+	if !slices.Equal(cfg.IgnoreGlobs, []string{"old"}) {
+		t.Errorf("ignore_globs = %v, want [old]", cfg.IgnoreGlobs)
+	}
+	entries, err := os.ReadDir(filepath.Dir(configPath))
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, entry := range entries {
+		if strings.Contains(entry.Name(), ".tmp-") {
+			t.Errorf("temp file %s left behind", entry.Name())
+		}
+	}
+}
+
+// symlinkXDG moves the test config home to a real directory elsewhere and
+// leaves XDG_CONFIG_HOME as a symlink to it, returning the real directory.
+func (e *testEnv) symlinkXDG() string {
+	e.t.Helper()
+	real := filepath.Join(e.home, "store", "config")
+	if err := os.MkdirAll(filepath.Dir(real), 0o755); err != nil {
+		e.t.Fatal(err)
+	}
+	if err := os.Rename(e.xdg, real); err != nil {
+		e.t.Fatal(err)
+	}
+	if err := os.Symlink(real, e.xdg); err != nil {
+		e.t.Fatal(err)
+	}
+	return real
+}
+
+func TestSymlinkedConfigHome(t *testing.T) {
+	e := newTestEnv(t, cfgsConfig{})
+	linked := e.track("app/linked", "linked\n")
+	orphan := e.track("app/orphan", "orphan\n")
+	real := e.symlinkXDG()
+	writeTestFile(t, filepath.Join(real, "app", "config"), "live\n")
+	if err := os.Symlink(linked, filepath.Join(real, "app", "linked")); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.Symlink(orphan, filepath.Join(real, "app", "orphan")); err != nil {
+		t.Fatal(err)
+	}
+
+	files, problems, err := e.app().scanXDGRegularFiles(e.repo, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(problems) != 0 {
+		t.Errorf("problems = %+v", problems)
+	}
+	if !slices.Contains(files, "app/config") {
+		t.Errorf("scan = %v, want app/config", files)
+	}
+	for _, rel := range files {
+		if strings.HasPrefix(rel, "..") || filepath.IsAbs(rel) {
+			t.Errorf("scan produced %q, which is not relative to the config home", rel)
+		}
+	}
+
+	roots, err := configuredRoots()
+	if err != nil {
+		t.Fatal(err)
+	}
+	managed := map[string]struct{}{"app/linked": {}}
+	report, err := reconcileOrphanRepoSymlinks(e.repo, roots, managed, nil, false)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !slices.Equal(report.unlinkedOrphanSymlink, []string{"app/orphan"}) || len(report.requireManualResolve) != 0 {
+		t.Errorf("orphans = %v, problems = %+v; want only app/orphan", report.unlinkedOrphanSymlink, report.requireManualResolve)
+	}
+	if info, err := os.Lstat(e.live("app/orphan")); err != nil || !info.Mode().IsRegular() {
+		t.Errorf("orphan should be replaced by a copy: %v, %v", info, err)
+	}
+	if ok, err := symlinkPointsTo(e.live("app/linked"), linked); err != nil || !ok {
+		t.Errorf("managed link must be left alone (ok=%v, err=%v)", ok, err)
+	}
+}
+
+// writeScanTree fills the config home with dirs*files regular files under
+// app<N>/, plus an ignored cache tree of the same size.
+func writeScanTree(t testing.TB, e *testEnv, dirs int, files int) []string {
+	var want []string
+	for d := 0; d < dirs; d++ {
+		for f := 0; f < files; f++ {
+			rel := fmt.Sprintf("app%02d/file%03d", d, f)
+			writeTestFile(t, e.live(rel), "x\n")
+			writeTestFile(t, e.live(fmt.Sprintf("cache/app%02d/file%03d", d, f)), "x\n")
+			want = append(want, rel)
+		}
+	}
+	return want
+}
+
+func TestScanXDGRegularFilesOnWorkerPool(t *testing.T) {
+	e := newTestEnv(t, cfgsConfig{IgnoreGlobs: []string{"cfgs", "cache"}})
+	want := writeScanTree(t, e, 8, 25)
+	if err := os.Symlink(e.live("app00/file000"), e.live("app00/link")); err != nil {
+		t.Fatal(err)
+	}
+
+	for _, workers := range []int{1, 4, 16} {
+		t.Run(fmt.Sprint(workers), func(t *testing.T) {
+			defer func(n int) { scanWorkers = n }(scanWorkers)
+			scanWorkers = workers
+
+			files, problems, err := e.app().scanXDGRegularFiles(e.repo, nil)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if len(problems) != 0 {
+				t.Errorf("problems = %+v", problems)
+			}
+			if !slices.Equal(files, want) {
+				t.Errorf("scan returned %d files, want the %d sorted app files without cache or links", len(files), len(want))
+			}
+		})
+	}
+}
+
+func BenchmarkScanXDGRegularFiles(b *testing.B) {
+	e := newTestEnv(b, cfgsConfig{IgnoreGlobs: []string{"cfgs", "cache"}})
+	writeScanTree(b, e, 40, 50)
+	a := e.app()
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		if _, _, err := a.scanXDGRegularFiles(e.repo, nil); err != nil {
+			b.Fatal(err)
+		}
+	}
+}
+
+func TestRepoNestedInConfigHomeIsSkipped(t *testing.T) {
+	e := newTestEnv(t, cfgsConfig{IgnoreGlobs: []string{"cfgs"}})
+	repo := e.live("dotfiles")
+	repoFile := filepath.Join(repo, "app", "config")
+	writeTestFile(t, repoFile, "repo\n")
+	// A symlink inside the repo that points at a repo file would look like
+	// an orphan link if the walk descended into the repo.
+	repoLink := filepath.Join(repo, "app", "alias")
+	if err := os.Symlink(repoFile, repoLink); err != nil {
+		t.Fatal(err)
+	}
+	writeTestFile(t, e.live("app/other"), "live\n")
+
+	a := e.app()
+	files, _, err := a.scanXDGRegularFiles(repo, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !slices.Equal(files, []string{"app/other"}) {
+		t.Errorf("scan = %v, want only app/other", files)
+	}
+	if !strings.Contains(e.errOut.String(), "inside the config root") {
+		t.Errorf("missing nested-repo warning:\n%s", e.errOut.String())
+	}
+
+	roots, err := configuredRoots()
+	if err != nil {
+		t.Fatal(err)
+	}
+	report, err := reconcileOrphanRepoSymlinks(repo, roots, map[string]struct{}{}, nil, false)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(report.unlinkedOrphanSymlink) != 0 || len(report.requireManualResolve) != 0 {
+		t.Errorf("orphan walk entered the repo: %+v", report)
+	}
+	if info, err := os.Lstat(repoLink); err != nil || info.Mode()&os.ModeSymlink == 0 {
+		t.Errorf("symlink inside the repo must be left alone: %v, %v", info, err)
+	}
+}
+
+func TestTrackedDirectoryCommands(t *testing.T) {
+	e := newTestEnv(t, cfgsConfig{})
+	writeTestFile(t, e.live("nvim/init.lua"), "init\n")
+	writeTestFile(t, e.live("nvim/lua/plugins.lua"), "plugins\n")
+	repoDir := filepath.Join(e.repo, "nvim")
+	liveDir := e.live("nvim")
+	ctx := context.Background()
+
+	run := func(name string, fn func(context.Context, []string) error, args ...string) {
+		t.Helper()
+		e.out.Reset()
+		if err := fn(ctx, args); err != nil {
+			t.Fatalf("%s %v: %v\n%s", name, args, err, e.errOut.String())
+		}
+	}
+	wantList := func(want ...string) {
+		t.Helper()
+		run("list", e.app().cmdList)
+		if got := strings.Fields(e.out.String()); !slices.Equal(got, want) {
+			t.Errorf("list = %q, want %q", got, want)
+		}
+	}
+	wantLinked := func(step string) {
+		t.Helper()
+		if ok, err := symlinkPointsTo(liveDir, repoDir); err != nil || !ok {
+			t.Fatalf("after %s: live nvim should link to the repo (ok=%v, err=%v)", step, ok, err)
+		}
+	}
+
+	run("add", e.app().cmdAdd, "--dir", "-m", "track nvim", "nvim")
+	wantLinked("add")
+	wantList("linked", "nvim/")
+
+	// A fresh machine: restore creates the directory link.
+	if err := os.Remove(liveDir); err != nil {
+		t.Fatal(err)
+	}
+	wantList("missing", "nvim/")
+	run("restore", e.app().cmdRestore)
+	wantLinked("restore")
+
+	if err := os.Remove(liveDir); err != nil {
+		t.Fatal(err)
+	}
+	roots, err := configuredRoots()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := e.app().reportDrift(e.repo, roots, true); err != nil {
+		t.Fatal(err)
+	}
+	wantLinked("watch")
+
+	run("unlink", e.app().cmdUnlink, "nvim")
+	if info, err := os.Lstat(liveDir); err != nil || !info.IsDir() {
+		t.Fatalf("unlink should leave a real directory: %v, %v", info, err)
+	}
+	wantList("diverged", "nvim/")
+
+	writeTestFile(t, filepath.Join(liveDir, "init.lua"), "edited\n")
+	run("adopt", e.app().cmdAdopt, "-m", "adopt nvim", "nvim")
+	wantLinked("adopt")
+	if data, _ := os.ReadFile(filepath.Join(repoDir, "init.lua")); string(data) != "edited\n" {
+		t.Errorf("repo init.lua = %q, want the adopted content", data)
+	}
+
+	run("remove", e.app().cmdRemove, "-m", "remove nvim", "nvim")
+	if data, err := os.ReadFile(filepath.Join(liveDir, "lua", "plugins.lua")); err != nil || string(data) != "plugins\n" {
+		t.Errorf("remove should leave a live copy: %q, %v", data, err)
+	}
+	if _, err := os.Lstat(repoDir); !os.IsNotExist(err) {
+		t.Errorf("repo directory should be gone, lstat err = %v", err)
+	}
+	if dirs, err := loadManagedDirs(e.repo); err != nil || len(dirs) != 0 {
+		t.Errorf("tracked dirs = %v, %v; want none", dirs, err)
+	}
+	if status := e.git("status", "--porcelain"); status != "" {
+		t.Errorf("remove left uncommitted changes:\n%s", status)
+	}
+	wantList()
+}
+
+func TestAddDirRespectsIgnoreGlobs(t *testing.T) {
+	e := newTestEnv(t, cfgsConfig{})
+	writeTestFile(t, e.live("app/config"), "x\n")
+	writeTestFile(t, e.live("app/node_modules/pkg/index.js"), "x\n")
+	writeTestFile(t, e.live("node_modules/pkg/index.js"), "x\n")
+
+	matchers, err := e.app().configuredIgnoreMatchers()
+	if err != nil {
+		t.Fatal(err)
+	}
+	report, dirs := trackDirectories(e.repo, nil, nil, []string{"app", "node_modules"}, matchers, linkStrategySymlink, "")
+	if len(dirs) != 0 || report.changed {
+		t.Fatalf("nothing should be tracked: report = %+v, dirs = %v", report, dirs)
+	}
+	if len(report.failed) != 1 || !strings.Contains(report.failed[0], "app/node_modules") {
+		t.Errorf("failed = %q, want app refused for its node_modules", report.failed)
+	}
+	if len(report.skipped) != 1 || !strings.HasPrefix(report.skipped[0], "node_modules:") {
+		t.Errorf("skipped = %q, want node_modules skipped as ignored", report.skipped)
+	}
+	for _, rel := range []string{"app", "node_modules"} {
+		if info, err := os.Lstat(e.live(rel)); err != nil || !info.IsDir() {
+			t.Errorf("%s must stay a real directory: %v, %v", rel, info, err)
+		}
+	}
+}
+
+func TestAssumeYesTakesTheDefault(t *testing.T) {
+	for _, defaultYes := range []bool{true, false} {
+		in := bufio.NewReader(strings.NewReader("y\n"))
+		a := &app{in: in, out: io.Discard, errOut: io.Discard, assumeYes: true}
+		got, err := a.promptYesNo("Proceed?", defaultYes)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if got != defaultYes {
+			t.Errorf("promptYesNo(default %v) under --yes = %v", defaultYes, got)
+		}
+		if rest, _ := in.ReadString('\n'); rest != "y\n" {
+			t.Errorf("promptYesNo read stdin under --yes")
+		}
+	}
+}
+
+func TestYesDoesNotConfirmRemoveAll(t *testing.T) {
+	e := newTestEnv(t, cfgsConfig{})
+	repoFile := e.track("app/config", "x\n")
+	if err := e.app().cmdRemove(context.Background(), []string{"--all", "--yes", "-m", "remove"}); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := os.Stat(repoFile); err != nil {
+		t.Errorf("remove --all --yes must not remove anything: %v", err)
+	}
+}
+
+func TestYesIsOnlyAcceptedByPromptingCommands(t *testing.T) {
+	e := newTestEnv(t, cfgsConfig{})
+	for _, args := range [][]string{{"list", "--yes"}, {"log", "-y"}, {"status", "--yes"}} {
+		if code := e.app().run(context.Background(), args); code == 0 {
+			t.Errorf("cfgs %v exit code 0, want --yes rejected", args)
+		}
+	}
+}
+
+func TestStatusClassifiesTrackedDirectories(t *testing.T) {
+	tests := []struct {
+		name     string
+		live     func(e *testEnv)
+		wantLine string
+		wantCode int
+	}{
+		{"linked", func(e *testEnv) {
+			if err := os.Symlink(filepath.Join(e.repo, "nvim"), e.live("nvim")); err != nil {
+				t.Fatal(err)
+			}
+		}, "", 0},
+		{"missing", func(e *testEnv) {}, "missing          nvim/", statusExitDrift},
+		{"real directory", func(e *testEnv) {
+			writeTestFile(t, e.live("nvim/init.lua"), "local\n")
+		}, "diverged         nvim/", statusExitUnresolved},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			e := newTestEnv(t, cfgsConfig{})
+			writeTestFile(t, filepath.Join(e.repo, "nvim", "init.lua"), "init\n")
+			if err := saveManagedDirs(e.repo, []string{"nvim"}); err != nil {
+				t.Fatal(err)
+			}
+			e.git("add", "-A")
+			e.git("commit", "-q", "-m", "track nvim")
+			if err := os.MkdirAll(e.xdg, 0o755); err != nil {
+				t.Fatal(err)
+			}
+			tt.live(e)
+
+			err := e.app().cmdStatus(context.Background(), []string{"--exit-code"})
+			var exit exitError
+			code := 0
+			if errors.As(err, &exit) {
+				code = exit.code
+			} else if err != nil {
+				t.Fatal(err)
+			}
+			if code != tt.wantCode {
+				t.Errorf("exit code = %d, want %d\n%s", code, tt.wantCode, e.out.String())
+			}
+			if tt.wantLine != "" && !strings.Contains(e.out.String(), tt.wantLine) {
+				t.Errorf("status output lacks %q:\n%s", tt.wantLine, e.out.String())
+			}
+		})
+	}
+}
+
+func TestModeRulesPreferTheMostSpecificGlob(t *testing.T) {
+	rules, err := compileModeRules(map[string]string{
+		"**":            "644",
+		"private/**":    "600",
+		"private/ssh/*": "400",
+		"*.sh":          "755",
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	tests := []struct {
+		rel  string
+		want fs.FileMode
+	}{
+		{"git/config", 0o644},
+		{"private/token", 0o600},
+		{"private/ssh/config", 0o400},
+		{"bin.sh", 0o755},
+	}
+	for _, tt := range tests {
+		got, ok := matchModeRule(tt.rel, rules)
+		if !ok || got != tt.want {
+			t.Errorf("matchModeRule(%q) = %04o, %v; want %04o", tt.rel, got, ok, tt.want)
+		}
+	}
+}
+
+func TestScanProblemsAreSorted(t *testing.T) {
+	e := newTestEnv(t, cfgsConfig{IgnoreGlobs: []string{"cfgs"}})
+	var want []string
+	for i := 0; i < 20; i++ {
+		rel := fmt.Sprintf("app/loop%02d", i)
+		if err := os.MkdirAll(filepath.Dir(e.live(rel)), 0o755); err != nil {
+			t.Fatal(err)
+		}
+		if err := os.Symlink(e.live(rel), e.live(rel)); err != nil {
+			t.Fatal(err)
+		}
+		want = append(want, rel)
+	}
+
+	defer func(n int) { scanWorkers = n }(scanWorkers)
+	scanWorkers = 8
+	for run := 0; run < 5; run++ {
+		_, problems, err := e.app().scanXDGRegularFiles(e.repo, nil)
+		if err != nil {
+			t.Fatal(err)
+		}
+		var got []string
+		for _, problem := range problems {
+			got = append(got, problem.path)
+		}
+		if !slices.Equal(got, want) {
+			t.Fatalf("run %d: problems = %v, want them sorted by path", run, got)
+		}
+	}
+}
+
+// testArchiveEntry is a regular file, or a symlink when linkname is set.
+type testArchiveEntry struct {
+	name     string
+	linkname string
+	content  string
+}
+
+func writeTestArchive(t *testing.T, name string, entries []testArchiveEntry) {
+	t.Helper()
+	var buf bytes.Buffer
+	gz := gzip.NewWriter(&buf)
+	tw := tar.NewWriter(gz)
+	for _, entry := range entries {
+		header := &tar.Header{Name: entry.name, Mode: 0o644, Typeflag: tar.TypeReg, Size: int64(len(entry.content))}
+		if entry.linkname != "" {
+			header = &tar.Header{Name: entry.name, Mode: 0o777, Typeflag: tar.TypeSymlink, Linkname: entry.linkname}
+		}
+		if err := tw.WriteHeader(header); err != nil {
+			t.Fatal(err)
+		}
+		if _, err := tw.Write([]byte(entry.content)); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := tw.Close(); err != nil {
+		t.Fatal(err)
+	}
+	if err := gz.Close(); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(name, buf.Bytes(), 0o644); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestImportStaysInsideDestination(t *testing.T) {
+	tests := []struct {
+		name    string
+		entries func(outside string) []testArchiveEntry
+	}{
+		{"symlink then child", func(outside string) []testArchiveEntry {
+			return []testArchiveEntry{{name: "x", linkname: outside}, {name: "x/pwned", content: "pwned\n"}}
+		}},
+		{"relative symlink then child", func(outside string) []testArchiveEntry {
+			return []testArchiveEntry{{name: "x", linkname: "../outside"}, {name: "x/pwned", content: "pwned\n"}}
+		}},
+		{"absolute symlink", func(outside string) []testArchiveEntry {
+			return []testArchiveEntry{{name: "app/link", linkname: filepath.Join(outside, "pwned")}}
+		}},
+		{"escaping symlink", func(outside string) []testArchiveEntry {
+			return []testArchiveEntry{{name: "app/link", linkname: "../../outside/pwned"}}
+		}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			dir := t.TempDir()
+			outside := filepath.Join(dir, "outside")
+			dest := filepath.Join(dir, "dest")
+			for _, d := range []string{outside, dest} {
+				if err := os.MkdirAll(d, 0o755); err != nil {
+					t.Fatal(err)
+				}
+			}
+			archive := filepath.Join(dir, "evil.tar.gz")
+			writeTestArchive(t, archive, tt.entries(outside))
+
+			if _, err := importFiles(archive, dest, false); err == nil {
+				t.Error("import succeeded, want it refused")
+			}
+			if entries, _ := os.ReadDir(outside); len(entries) != 0 {
+				t.Errorf("import wrote outside the destination: %v", entries)
+			}
+		})
+	}
+}
+
+func TestImportForceDoesNotFollowExistingParentSymlink(t *testing.T) {
+	dir := t.TempDir()
+	outside := filepath.Join(dir, "outside")
+	dest := filepath.Join(dir, "dest")
+	victim := filepath.Join(outside, "config")
+	writeTestFile(t, victim, "keep\n")
+	if err := os.MkdirAll(dest, 0o755); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.Symlink(outside, filepath.Join(dest, "app")); err != nil {
+		t.Fatal(err)
+	}
+	archive := filepath.Join(dir, "export.tar.gz")
+	writeTestArchive(t, archive, []testArchiveEntry{{name: "app/config", content: "replaced\n"}})
+
+	if _, err := importFiles(archive, dest, true); err == nil {
+		t.Error("import succeeded, want it refused")
+	}
+	if data, _ := os.ReadFile(victim); string(data) != "keep\n" {
+		t.Errorf("file outside the destination = %q, want it untouched", data)
+	}
+}
+
+func TestImportKeepsSymlinksInsideDestination(t *testing.T) {
+	dir := t.TempDir()
+	dest := filepath.Join(dir, "dest")
+	archive := filepath.Join(dir, "export.tar.gz")
+	writeTestArchive(t, archive, []testArchiveEntry{
+		{name: "app/config", content: "x\n"},
+		{name: "app/alias", linkname: "config"},
+	})
+	if count, err := importFiles(archive, dest, false); err != nil || count != 2 {
+		t.Fatalf("importFiles = %d, %v; want 2 entries", count, err)
+	}
+	if target, err := os.Readlink(filepath.Join(dest, "app", "alias")); err != nil || target != "config" {
+		t.Errorf("alias -> %q, %v; want config", target, err)
+	}
+}
+
+// installFakeFzf puts an fzf on PATH that runs script instead of showing a
+// picker.
+func installFakeFzf(t *testing.T, script string) {
+	t.Helper()
+	bin := t.TempDir()
+	if err := os.WriteFile(filepath.Join(bin, "fzf"), []byte("#!/bin/sh\n"+script), 0o755); err != nil {
+		t.Fatal(err)
+	}
+	t.Setenv("PATH", bin+string(os.PathListSeparator)+os.Getenv("PATH"))
+}
+
+func TestCheckCommitsOnlyTheSelectedPaths(t *testing.T) {
+	e := newTestEnv(t, cfgsConfig{})
+	writeTestFile(t, filepath.Join(e.repo, "app", "picked"), "picked\n")
+	writeTestFile(t, filepath.Join(e.repo, "app", "staged"), "staged\n")
+	writeTestFile(t, filepath.Join(e.repo, "app", "other"), "other\n")
+	e.git("add", "app/staged")
+	installFakeFzf(t, "cat >/dev/null\necho app/picked\n")
+
+	a := e.app()
+	a.in = bufio.NewReader(strings.NewReader("y\nn\n"))
+	if err := a.cmdCheck(context.Background(), []string{"-m", "pick"}); err != nil {
+		t.Fatal(err)
+	}
+	if got := strings.Fields(e.git("show", "--name-only", "--format=", "HEAD")); !slices.Equal(got, []string{"app/picked"}) {
+		t.Errorf("committed %q, want only app/picked", got)
+	}
+	if got := e.git("diff", "--cached", "--name-only"); strings.TrimSpace(got) != "app/staged" {
+		t.Errorf("index after check = %q, want app/staged still staged", got)
+	}
+}
+
+func TestCheckCancelledSelectionCommitsNothing(t *testing.T) {
+	e := newTestEnv(t, cfgsConfig{})
+	writeTestFile(t, filepath.Join(e.repo, "app", "one"), "one\n")
+	writeTestFile(t, filepath.Join(e.repo, "app", "two"), "two\n")
+	installFakeFzf(t, "cat >/dev/null\nexit 130\n")
+	head := e.git("rev-parse", "HEAD")
+
+	a := e.app()
+	a.in = bufio.NewReader(strings.NewReader("y\n"))
+	if err := a.cmdCheck(context.Background(), []string{"-m", "cancel"}); err != nil {
+		t.Fatal(err)
+	}
+	if got := e.git("rev-parse", "HEAD"); got != head {
+		t.Errorf("HEAD moved to %s after cancelling the selection", got)
+	}
+	if !strings.Contains(e.out.String(), "Skipped commit.") {
+		t.Errorf("output = %q, want Skipped commit.", e.out.String())
+	}
+}